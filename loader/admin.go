@@ -0,0 +1,248 @@
+package loader
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// AdminStatus - состояние загрузчика, которое отдает /loader/status
+type AdminStatus struct {
+	Source             string       `json:"source"`
+	ConfigHash         string       `json:"config_hash,omitempty"`
+	UsesFallbackConfig bool         `json:"uses_fallback_config"`
+	FallbackIndex      int          `json:"fallback_index"`
+	FallbackSavedAt    *time.Time   `json:"fallback_saved_at,omitempty"`
+	FallbackAge        string       `json:"fallback_age,omitempty"`
+	FallbackDiff       []FieldDiff  `json:"fallback_diff,omitempty"`
+	FallbackSections   []string     `json:"fallback_sections,omitempty"`
+	DefaultedFields    []string     `json:"defaulted_fields,omitempty"`
+	ConfigError        string       `json:"config_error,omitempty"`
+	ConfigErrorFields  []FieldError `json:"config_error_fields,omitempty"`
+	CanaryHeld         bool         `json:"canary_held,omitempty"`
+	// приложение работает на новом конфиге, который еще не сохранен как рабочий:
+	// не стартовало или еще не прошло LOADER_PROBATION_PERIOD / LOADER_CRASH_LOOP_WINDOW
+	PendingConfirmation bool   `json:"pending_confirmation,omitempty"`
+	Schema              string `json:"schema"`
+	// последний откат, в том числе до перезапуска процесса, см. AppLoader.LastRollback
+	LastRollback *RollbackReason `json:"last_rollback,omitempty"`
+}
+
+// Status возвращает текущее состояние загрузчика
+func (l *AppLoader) Status() AdminStatus {
+	cfg := l.Config().Redacted()
+	status := AdminStatus{
+		Source:             sourceName(l.source),
+		ConfigHash:         cfg.ConfigHash,
+		UsesFallbackConfig: cfg.UsesFallbackConfig,
+		FallbackIndex:      cfg.FallbackIndex,
+		FallbackSavedAt:    cfg.FallbackSavedAt,
+		FallbackDiff:       cfg.FallbackDiff,
+		FallbackSections:   cfg.FallbackSections,
+		DefaultedFields:    cfg.DefaultedFields,
+		ConfigError:        cfg.ConfigError,
+		ConfigErrorFields:  cfg.ConfigErrorFields,
+		CanaryHeld:         cfg.CanaryHeld,
+		Schema:             l.schema,
+	}
+	l.mu.RLock()
+	status.PendingConfirmation = !cfg.UsesFallbackConfig && l.app != nil && l.confirmed != l.app
+	l.mu.RUnlock()
+	if reason, ok := l.LastRollback(); ok {
+		status.LastRollback = &reason
+	}
+	if cfg.UsesFallbackConfig && cfg.FallbackSavedAt != nil {
+		status.FallbackAge = time.Since(*cfg.FallbackSavedAt).Round(time.Second).String()
+	}
+	return status
+}
+
+func sourceName(source ConfigSource) string {
+	if s, ok := source.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", source)
+}
+
+// AdminHandler отдает хендлер админки загрузчика:
+//   - GET /loader/status - источник конфига, используется ли откат и последняя ошибка конфига;
+//   - GET /loader/config - текущий конфиг, секретные поля замаскированы, см. Config.Redacted;
+//     с ?provenance=1 - и откуда взято значение каждого поля, см. AppLoader.Provenance;
+//   - GET /loader/health - состояние приложения (см. AppLoader.Health), 503 если оно недоступно;
+//   - GET /loader/ready - 200, если приложение готово принимать запросы, иначе 503;
+//     с ?promoted=1 - еще и только после того, как конфиг процесса сохранен как рабочий, см. AppLoader.Promoted;
+//   - POST /loader/rollback - принудительный откат на предыдущий рабочий конфиг;
+//   - POST /loader/promote - сохранить текущий конфиг как рабочий, см. AppLoader.PromoteCurrentConfig;
+//   - POST /loader/invalidate - удалить последний сохраненный конфиг, см. AppLoader.InvalidateFallback;
+//   - GET /loader/flags - флаги конфига и откуда взяты их значения, см. AppLoader.Flags;
+//   - POST /loader/flags/<name>?enabled=true|false - переключить флаг, DELETE - снять переключение, см. AppLoader.SetFlag;
+//   - GET /metrics - метрики загрузчика, если реестр из WithMetrics умеет их отдавать;
+//   - /debug/pprof/, /debug/vars и /debug/gc - профили и состояние рантайма, если задан LOADER_ADMIN_DEBUG.
+//
+// Если задан LOADER_ADMIN_ADDR, загрузчик сам поднимает с ним отдельный сервер на время Start,
+// иначе хендлер можно подключить к серверу приложения.
+// Все, кроме health и ready, требует LOADER_ADMIN_TOKEN и клиентский сертификат от LOADER_ADMIN_CLIENT_CA,
+// если они заданы, и проходит через WithAdminMiddleware. Вызовы, кроме GET, пишутся в журнал с AuditAdmin.
+func (l *AppLoader) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loader/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, l.Status())
+	})
+	mux.HandleFunc("/loader/config", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		cfg := l.Config().Redacted()
+		if isTrue(r.FormValue("provenance")) {
+			provenance, err := l.Provenance(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			cfg.Provenance = provenance
+		}
+		writeJSON(w, http.StatusOK, cfg)
+	})
+	mux.HandleFunc("/loader/health", l.handleHealth)
+	mux.HandleFunc("/loader/ready", l.handleReady)
+	mux.HandleFunc("/loader/rollback", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := l.Rollback(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, l.Status())
+	})
+	mux.HandleFunc("/loader/promote", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := l.PromoteCurrentConfig(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, l.Status())
+	})
+	mux.HandleFunc("/loader/invalidate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := l.InvalidateFallback(); err != nil {
+			code := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, ErrFallbackNotFound):
+				code = http.StatusNotFound
+			case errors.Is(err, ErrDeleteNotSupported):
+				code = http.StatusNotImplemented
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		writeJSON(w, http.StatusOK, l.Status())
+	})
+	mux.HandleFunc("/loader/flags", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, l.Flags())
+	})
+	mux.HandleFunc("/loader/flags/", l.handleFlag)
+	mux.HandleFunc("/loader/graph", l.handleGraph)
+	if g, ok := l.metrics.gatherer(); ok {
+		mux.Handle("/metrics", promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+	}
+	if l.cfg.AdminDebug {
+		debugRoutes(mux)
+	}
+	return l.protectAdmin(mux)
+}
+
+// handleFlag переключает флаг из пути /loader/flags/<name>
+func (l *AppLoader) handleFlag(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/loader/flags/")
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		enabled, parseErr := strconv.ParseBool(r.FormValue("enabled"))
+		if parseErr != nil {
+			http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+			return
+		}
+		err = l.SetFlag(name, enabled)
+	case http.MethodDelete:
+		err = l.ClearFlag(name)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if errors.Is(err, ErrUnknownFlag) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, l.Flags())
+}
+
+// protectAdmin проверяет доступ к handler и пишет в журнал, кто что поменял.
+// Middleware из WithAdminMiddleware видят вызывающего из adminAuth и могут задать своего.
+// /loader/health и /loader/ready открыты, чтобы их могли опрашивать пробы
+func (l *AppLoader) protectAdmin(handler http.Handler) http.Handler {
+	protected := l.auditAdmin(handler)
+	for i := len(l.adminMiddleware) - 1; i >= 0; i-- {
+		protected = l.adminMiddleware[i](protected)
+	}
+	protected = l.adminAuth(protected)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/loader/health" || r.URL.Path == "/loader/ready" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		protected.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(b)
+}
+
+// поднимает сервер с handler на addr, например админку, сервер нужно закрыть после остановки приложения.
+// С tlsCfg сервер отвечает по https
+func serveHTTP(addr string, handler http.Handler, tlsCfg *tls.Config) (*http.Server, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsCfg != nil {
+		lis = tls.NewListener(lis, tlsCfg)
+	}
+	srv := &http.Server{Handler: handler}
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	return srv, nil
+}
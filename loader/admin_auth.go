@@ -0,0 +1,186 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// кто вызвал админку с токеном LOADER_ADMIN_TOKEN
+const adminTokenCaller = "token"
+
+type adminCallerKey struct{}
+
+// ContextWithAdminCaller запоминает, кто вызывает админку, например в своем middleware из WithAdminMiddleware.
+// Имя попадает в журнал вызовов, см. AdminCaller
+func ContextWithAdminCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, adminCallerKey{}, caller)
+}
+
+// AdminCaller возвращает, кто вызывает админку: CN клиентского сертификата, token
+// или имя из ContextWithAdminCaller. Пустая строка, если вызывающий неизвестен
+func AdminCaller(ctx context.Context) string {
+	caller, _ := ctx.Value(adminCallerKey{}).(string)
+	return caller
+}
+
+// initAdminTLS загружает сертификат админки LOADER_ADMIN_TLS_CERT / LOADER_ADMIN_TLS_KEY
+// и CA клиентских сертификатов LOADER_ADMIN_CLIENT_CA
+func (l *AppLoader) initAdminTLS() error {
+	if l.cfg.AdminClientCA != "" && l.cfg.AdminTLSCert == "" {
+		return errors.New("admin client CA requires admin TLS certificate")
+	}
+	if l.cfg.AdminTLSCert == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(l.cfg.AdminTLSCert, l.cfg.AdminTLSKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to load admin TLS certificate")
+	}
+	l.adminTLS = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if l.cfg.AdminClientCA == "" {
+		return nil
+	}
+	pem, err := os.ReadFile(l.cfg.AdminClientCA)
+	if err != nil {
+		return errors.Wrap(err, "failed to read admin client CA")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return errors.Errorf("no certificates in admin client CA %s", l.cfg.AdminClientCA)
+	}
+	l.adminTLS.ClientCAs = pool
+	// без сертификата пускаем только к пробам, остальное проверяет adminAuth
+	l.adminTLS.ClientAuth = tls.VerifyClientCertIfGiven
+	return nil
+}
+
+// adminAuth пропускает к next только запросы с токеном и клиентским сертификатом, если они настроены
+func (l *AppLoader) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := l.Config()
+		caller := ""
+		if cfg.AdminClientCA != "" {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				l.denyAdmin(w, r, "client certificate required")
+				return
+			}
+			cert := r.TLS.VerifiedChains[0][0]
+			caller = cert.Subject.CommonName
+			if caller == "" {
+				caller = cert.Subject.String()
+			}
+		}
+		if cfg.AdminToken != "" {
+			auth := r.Header.Get("Authorization")
+			token := strings.TrimPrefix(auth, "Bearer ")
+			if token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) != 1 {
+				l.denyAdmin(w, r, "invalid or missing token")
+				return
+			}
+			if caller == "" {
+				caller = adminTokenCaller
+			}
+		}
+		if caller != "" {
+			r = r.WithContext(ContextWithAdminCaller(r.Context(), caller))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *AppLoader) denyAdmin(w http.ResponseWriter, r *http.Request, reason string) {
+	l.log.Error("admin request denied", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr, "reason", reason)
+	http.Error(w, reason, http.StatusUnauthorized)
+}
+
+// auditAdmin пишет в лог и журнал, кто и что поменял через админку. GET запросы только читают и не записываются
+func (l *AppLoader) auditAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		var err error
+		if rec.code >= http.StatusBadRequest {
+			reason := strings.TrimSpace(rec.body.String())
+			if reason == "" {
+				reason = http.StatusText(rec.code)
+			}
+			err = errors.New(reason)
+		}
+		l.RecordAdminCall(r.Context(), r.Method+" "+r.URL.Path, err)
+	})
+}
+
+// RecordAdminCall пишет в лог загрузчика и в журнал (см. WithAuditLog) вызов call, который поменял
+// или пытался поменять состояние загрузчика, например из своего gRPC или HTTP управления.
+// Вызывающий берется из ctx, см. AdminCaller
+func (l *AppLoader) RecordAdminCall(ctx context.Context, call string, err error) {
+	caller := AdminCaller(ctx)
+	if err != nil {
+		l.log.Error("admin call failed", "caller", caller, "call", call, "error", err)
+	} else {
+		l.log.Info("admin call", "caller", caller, "call", call)
+	}
+	if len(l.auditLog.logs) == 0 {
+		return
+	}
+	cfg := l.Config()
+	rec := AuditRecord{
+		Time:          time.Now().UTC(),
+		Action:        AuditAdmin,
+		Source:        sourceName(l.source),
+		ConfigHash:    cfg.ConfigHash,
+		Fallback:      cfg.UsesFallbackConfig,
+		FallbackIndex: cfg.FallbackIndex,
+		Result:        AuditOK,
+		Generation:    atomic.LoadInt64(&l.auditLog.generation),
+		Caller:        caller,
+		Call:          call,
+	}
+	if err != nil {
+		rec.Result = AuditFailed
+		masked := cfg
+		masked.ConfigError = err.Error()
+		rec.Error = masked.Redacted().ConfigError
+	}
+	l.writeAudit(rec)
+}
+
+// сколько текста ошибки из ответа попадает в журнал
+const maxAdminErrorLen = 512
+
+// statusRecorder запоминает код ответа хендлера и начало текста ошибки
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.code >= http.StatusBadRequest && r.body.Len() < maxAdminErrorLen {
+		rest := b
+		if len(rest) > maxAdminErrorLen-r.body.Len() {
+			rest = rest[:maxAdminErrorLen-r.body.Len()]
+		}
+		r.body.Write(rest)
+	}
+	return r.ResponseWriter.Write(b)
+}
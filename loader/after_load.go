@@ -0,0 +1,75 @@
+package loader
+
+import (
+	"github.com/pkg/errors"
+)
+
+// AfterLoader может реализовать конфиг приложения (или конфиг секции из WithConfig),
+// чтобы заполнить производные поля: собрать адрес из хоста и порта, разобрать строку в url.URL,
+// раскрыть ссылки ${VAR}. AfterLoad вызывается после чтения конфига из источника
+// или из хранилища при откате, перед валидацией, так что конструкторам не нужно делать это самим.
+// Ошибка считается ошибкой конфига, см. ErrBadConfig
+type AfterLoader interface {
+	AfterLoad() error
+}
+
+// loadHook дополняет прочитанный конфиг приложения
+type loadHook func(appCfg interface{}) error
+
+// хук из WithAfterLoad ждет конфиг другого типа - ошибка в коде, а не в конфиге, откат ее не исправит
+var errHookConfigType = errors.New("unexpected config type")
+
+// WithAfterLoad добавляет хук, который, как AfterLoader, дополняет конфиг после чтения и перед валидацией.
+// T - тип конфига приложения или одной из секций WithConfig. Хуки вызываются по порядку после AfterLoad.
+// Конфиг при hot reload сравнивается с прошлым уже после хуков, поэтому для одинакового конфига
+// хук должен давать одинаковый результат
+func WithAfterLoad[T any](hook func(cfg *T) error) Option {
+	return func(l *AppLoader) {
+		l.loadHooks = append(l.loadHooks, func(appCfg interface{}) error {
+			if cfg, ok := appCfg.(*T); ok {
+				return hook(cfg)
+			}
+			if len(l.sections) > 0 {
+				for _, v := range sectionValues(appCfg) {
+					if cfg, ok := v.Interface().(*T); ok && cfg != nil {
+						return hook(cfg)
+					}
+				}
+			}
+			return errors.Wrapf(errHookConfigType, "after load hook expects %T, got %T", (*T)(nil), appCfg)
+		})
+	}
+}
+
+// afterLoad вызывает AfterLoad у конфига и секций, затем хуки из WithAfterLoad
+func (l *AppLoader) afterLoad(appCfg interface{}) error {
+	targets := []interface{}{appCfg}
+	if len(l.sections) > 0 {
+		targets = targets[:0]
+		for _, v := range sectionValues(appCfg) {
+			if !v.IsNil() {
+				targets = append(targets, v.Interface())
+			}
+		}
+	}
+	for _, t := range targets {
+		if a, ok := t.(AfterLoader); ok {
+			if err := a.AfterLoad(); err != nil {
+				return afterLoadError(err)
+			}
+		}
+	}
+	for _, hook := range l.loadHooks {
+		if err := hook(appCfg); err != nil {
+			return afterLoadError(err)
+		}
+	}
+	return nil
+}
+
+func afterLoadError(err error) error {
+	if _, ok := unwrapBadConfigError(err); ok || errors.Is(err, errHookConfigType) {
+		return err
+	}
+	return ErrBadConfig{Cause: errors.Wrap(err, "after load hook failed")}
+}
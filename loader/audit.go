@@ -0,0 +1,156 @@
+package loader
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// события журнала смены конфигов, AuditRecord.Action
+const (
+	// приложение запущено с конфигом из источника
+	AuditApply = "apply"
+	// приложение запущено с сохраненным или предыдущим конфигом вместо нового
+	AuditRollback = "rollback"
+	// новый конфиг отклонен при hot reload, приложение работает как работало
+	AuditReject = "reject"
+	// вызов админки или gRPC управления, который меняет состояние загрузчика
+	AuditAdmin = "admin"
+)
+
+// AuditRecord.Source для отката: конфиг взят из хранилища сохраненных конфигов
+const auditFallbackSource = "fallback"
+
+// результаты события, AuditRecord.Result
+const (
+	AuditOK     = "ok"
+	AuditFailed = "failed"
+)
+
+// AuditRecord - запись журнала о том, с каким конфигом работало приложение
+type AuditRecord struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	// источник конфига приложения, для отката - fallback
+	Source string `json:"source"`
+	// sha256 конфига приложения из события
+	ConfigHash    string `json:"config_hash"`
+	Fallback      bool   `json:"fallback"`
+	FallbackIndex int    `json:"fallback_index,omitempty"`
+	// поля, которыми конфиг отличается от предыдущего или от отклоненного, без значений
+	Changed []string `json:"changed,omitempty"`
+	Result  string   `json:"result"`
+	Error   string   `json:"error,omitempty"`
+	// почему приложение работает не на конфиге из источника, см. Config.ConfigError
+	Reason string `json:"reason,omitempty"`
+	// номер запуска приложения в процессе: растет с каждым стартовавшим приложением
+	Generation int64 `json:"generation"`
+	// для AuditAdmin: кто вызвал (см. AdminCaller) и что, например POST /loader/rollback
+	Caller string `json:"caller,omitempty"`
+	Call   string `json:"call,omitempty"`
+}
+
+// AuditLog принимает записи журнала, см. WithAuditLog.
+// Record вызывается синхронно при смене конфига, ошибка записи только попадает в лог загрузчика
+type AuditLog interface {
+	Record(rec AuditRecord) error
+}
+
+// FileAuditLog дописывает записи в файл по одной json строке.
+// Файл открывается на каждую запись, так что его можно ротировать снаружи
+type FileAuditLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileAuditLog(path string) *FileAuditLog {
+	return &FileAuditLog{path: path}
+}
+
+func (a *FileAuditLog) Record(rec AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode audit record")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open audit log")
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write audit log")
+	}
+	return f.Close()
+}
+
+// auditLog - журналы из WithAuditLog и LOADER_AUDIT_LOG
+type auditLog struct {
+	logs       []AuditLog
+	generation int64
+}
+
+// auditAction - событие запуска приложения с конфигом cfg
+func auditAction(cfg *Config) string {
+	if cfg.UsesFallbackConfig {
+		return AuditRollback
+	}
+	return AuditApply
+}
+
+// audit записывает событие action с конфигом cfg. prev - конфиг, с которым приложение работало до этого,
+// если он известен, err - ошибка запуска или причина отказа
+func (l *AppLoader) audit(action string, cfg, prev *Config, err error) {
+	if len(l.auditLog.logs) == 0 {
+		return
+	}
+	rec := AuditRecord{
+		Time:          time.Now().UTC(),
+		Action:        action,
+		Source:        sourceName(l.source),
+		Fallback:      cfg.UsesFallbackConfig,
+		FallbackIndex: cfg.FallbackIndex,
+		Result:        AuditOK,
+		Generation:    atomic.LoadInt64(&l.auditLog.generation),
+	}
+	if action == AuditRollback {
+		rec.Source = auditFallbackSource
+	}
+	// секретные значения из конфига не должны попасть в журнал вместе с ошибками
+	rec.Reason = cfg.Redacted().ConfigError
+	if err != nil {
+		rec.Result = AuditFailed
+		masked := *cfg
+		masked.ConfigError = err.Error()
+		rec.Error = masked.Redacted().ConfigError
+		if rec.Reason == rec.Error {
+			rec.Reason = ""
+		}
+	} else if action != AuditReject {
+		rec.Generation = atomic.AddInt64(&l.auditLog.generation, 1)
+	}
+	rec.ConfigHash = l.configHash(cfg.App)
+	diff := cfg.FallbackDiff
+	if len(diff) == 0 && prev != nil {
+		diff = diffConfigs(flattenConfig(prev.App), flattenConfig(cfg.App))
+	}
+	for _, d := range diff {
+		rec.Changed = append(rec.Changed, d.Field)
+	}
+	sort.Strings(rec.Changed)
+	l.writeAudit(rec)
+}
+
+func (l *AppLoader) writeAudit(rec AuditRecord) {
+	for _, a := range l.auditLog.logs {
+		if err := a.Record(rec); err != nil {
+			l.log.Error("failed to write audit record", "action", rec.Action, "error", err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package loader
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+)
+
+// как подменяется приложение при hot reload и откатах (LOADER_RELOAD_STRATEGY)
+const (
+	// остановить старое приложение и запустить новое
+	ReloadRestart = "restart"
+	// запустить новое приложение рядом со старым и остановить старое, только когда новое стартовало
+	ReloadBlueGreen = "bluegreen"
+)
+
+func (l *AppLoader) initReloadStrategy() error {
+	switch l.cfg.ReloadStrategy {
+	case "":
+		l.cfg.ReloadStrategy = ReloadRestart
+	case ReloadRestart, ReloadBlueGreen:
+	default:
+		return errors.Errorf("unknown reload strategy %q", l.cfg.ReloadStrategy)
+	}
+	return nil
+}
+
+// swapBlueGreen запускает app с конфигом next, пока старое приложение еще работает,
+// и останавливает старое только после этого. Если app не стартовало, старое продолжает работать как было
+func (l *AppLoader) swapBlueGreen(prev, next *Config, app *fx.App) error {
+	old := l.currentApp()
+	// OnStart нового приложения подставит свои проверки здоровья, а если оно не стартует,
+	// проверки старого нужно вернуть
+	l.health.mu.Lock()
+	checks := l.health.checks
+	l.health.mu.Unlock()
+
+	l.beginStart(next, app)
+	startCtx, cancel := context.WithTimeout(context.Background(), next.StartTimeout)
+	startErr := app.Start(startCtx)
+	cancel()
+	if startErr != nil {
+		l.log.Error("failed to start app with new config, previous app keeps running", "error", startErr)
+		l.events.OnAppStartFailed(*next, startErr)
+		l.audit(auditAction(next), next, prev, startErr)
+		stopCtx, cancel := context.WithTimeout(context.Background(), next.StopTimeout)
+		_ = l.stopApp(stopCtx, app)
+		cancel()
+		l.health.mu.Lock()
+		l.health.checks = checks
+		l.health.mu.Unlock()
+		return errors.Wrap(startErr, "failed to start app with new config")
+	}
+
+	l.setCurrent(next, app)
+	stopCtx, cancel := context.WithTimeout(context.Background(), prev.StopTimeout)
+	if err := l.stopApp(stopCtx, old); err != nil {
+		l.log.Error("failed to stop previous app", "error", err)
+	}
+	cancel()
+	l.log.Info("app switched to new config", "fallback", next.UsesFallbackConfig, "strategy", ReloadBlueGreen, "config_hash", next.ConfigHash)
+	l.audit(auditAction(next), next, prev, nil)
+	l.subs.notify(*next)
+	return nil
+}
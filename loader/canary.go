@@ -0,0 +1,120 @@
+package loader
+
+import (
+	"context"
+	"hash/fnv"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// initCanary решает, входит ли экземпляр в LOADER_CANARY_PERCENT процентов,
+// которые применяют новый конфиг первыми. Решение зависит только от LOADER_CANARY_ID
+// (по умолчанию hostname), так что канарейками остаются одни и те же экземпляры
+func (l *AppLoader) initCanary() error {
+	if l.cfg.CanaryPercent == 0 {
+		return nil
+	}
+	if l.cfg.CanaryPercent < 0 || l.cfg.CanaryPercent > 100 {
+		return errors.Errorf("canary percent must be between 0 and 100, got %d", l.cfg.CanaryPercent)
+	}
+	if l.cfg.CanaryID == "" {
+		l.cfg.CanaryID, _ = os.Hostname()
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(l.cfg.CanaryID))
+	l.canaryHoldback = int(h.Sum32()%100) >= l.cfg.CanaryPercent
+	l.log.Info("canary rollout is enabled", "id", l.cfg.CanaryID, "canary", !l.canaryHoldback, "percent", l.cfg.CanaryPercent)
+	return nil
+}
+
+// canaryHeld возвращает последний сохраненный рабочий конфиг, на котором остается экземпляр не из канареек,
+// пока новый конфиг appCfg не сохранят как рабочий канарейки. Если сдерживать нечего, возвращает nil
+func (l *AppLoader) canaryHeld(ctx context.Context, appCfg interface{}) interface{} {
+	if !l.canaryHoldback {
+		return nil
+	}
+	stored, err := l.lastKnownGood(ctx, appCfg)
+	if err != nil {
+		// без сохраненного конфига оставаться не на чем
+		if !errors.Is(err, ErrFallbackNotFound) {
+			l.log.Error("failed to load known-good config for canary rollout, applying new config", "error", err)
+		}
+		return nil
+	}
+	// в хранилище конфиг лежит в том виде, в каком его сохранили хуки WithBeforeSave
+	saved, err := l.savedForm(appCfg)
+	if err != nil {
+		saved = appCfg
+	}
+	// секреты из внешних хранилищ в stored запрошены заново, а в сохраненном виде их нет
+	if reflect.DeepEqual(l.withoutSecrets(stored), saved) {
+		return nil
+	}
+	return stored
+}
+
+// lastKnownGood читает последний сохраненный рабочий конфиг в новый экземпляр того же типа, что appCfg
+func (l *AppLoader) lastKnownGood(ctx context.Context, appCfg interface{}) (interface{}, error) {
+	data, err := loadFromStore(ctx, l.store)
+	if err != nil {
+		return nil, err
+	}
+	stored := newAppConfig(appCfg)
+	if _, err := l.applyFallbackConfig(ctx, data, stored); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// holdCanary оставляет cfg.App на сохраненном рабочем конфиге, если экземпляр не из канареек.
+// Если приложение с ним не собралось, возвращает cfg.App к новому конфигу
+func (l *AppLoader) holdCanary(ctx context.Context, cfg *Config) (restore func(), held bool) {
+	stored := l.canaryHeld(ctx, cfg.App)
+	if stored == nil {
+		return nil, false
+	}
+	target := reflect.ValueOf(cfg.App).Elem()
+	next := reflect.New(target.Type()).Elem()
+	next.Set(target)
+	diff := diffConfigs(flattenConfig(cfg.App), flattenConfig(stored))
+	target.Set(reflect.ValueOf(stored).Elem())
+	cfg.CanaryHeld = true
+	cfg.FallbackDiff = diff
+	l.log.Info("new config is rolling out on canaries, keeping known-good config", "diff", diff)
+	return func() {
+		target.Set(next)
+		cfg.CanaryHeld = false
+		cfg.FallbackDiff = nil
+	}, true
+}
+
+// watchCanary, пока экземпляр сдерживает новый конфиг, раз в LOADER_WATCH_INTERVAL проверяет хранилище.
+// Когда канарейки сохранили новый рабочий конфиг, экземпляр перечитывает источник и применяет его
+func (l *AppLoader) watchCanary(ctx context.Context) {
+	ticker := time.NewTicker(l.Config().WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cur := l.Config()
+		if !cur.CanaryHeld {
+			continue
+		}
+		loadCtx, cancel := l.loadContext(ctx)
+		stored, err := l.lastKnownGood(loadCtx, cur.App)
+		cancel()
+		if err != nil || reflect.DeepEqual(stored, cur.App) {
+			continue
+		}
+		l.log.Info("known-good config changed in fallback store, reloading")
+		// ошибка уже сохранена в ConfigError, а приложение осталось на прошлом конфиге
+		_ = l.Reload()
+	}
+}
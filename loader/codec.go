@@ -0,0 +1,95 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigCodec сериализует конфиг приложения перед сохранением в FallbackStore
+type ConfigCodec interface {
+	Encode(v interface{}) ([]byte, error)
+	Decode(data []byte, v interface{}) error
+}
+
+// JSONCodec пишет конфиг в читаемом json с отступами, чтобы его можно было поправить руками
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(v interface{}) ([]byte, error) {
+	return json.MarshalIndent(v, "", "  ")
+}
+
+func (JSONCodec) Decode(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type YAMLCodec struct{}
+
+func (YAMLCodec) Encode(v interface{}) ([]byte, error) {
+	return yaml.Marshal(v)
+}
+
+func (YAMLCodec) Decode(data []byte, v interface{}) error {
+	return yaml.Unmarshal(data, v)
+}
+
+// GobCodec - формат, в котором конфиг сохранялся раньше
+type GobCodec struct{}
+
+func (GobCodec) Encode(v interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// MigratingCodec пишет конфиг в формате Codec, а читает сначала в нем же, потом в Legacy.
+// Старый конфиг остается читаемым, а при следующем сохранении перезаписывается в новом формате.
+type MigratingCodec struct {
+	Codec  ConfigCodec
+	Legacy ConfigCodec
+}
+
+func (c MigratingCodec) Encode(v interface{}) ([]byte, error) {
+	return c.Codec.Encode(v)
+}
+
+func (c MigratingCodec) Decode(data []byte, v interface{}) error {
+	err := c.Codec.Decode(data, v)
+	if err == nil {
+		return nil
+	}
+	if legacyErr := c.Legacy.Decode(data, v); legacyErr != nil {
+		return err
+	}
+	return nil
+}
+
+// по умолчанию пишем json, но умеем читать файлы, сохраненные в gob старыми версиями
+func defaultCodec() ConfigCodec {
+	return MigratingCodec{Codec: JSONCodec{}, Legacy: GobCodec{}}
+}
+
+// codecByName возвращает кодек по значению LOADER_FALLBACK_CODEC
+func codecByName(name string) (ConfigCodec, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return defaultCodec(), nil
+	case "json":
+		return JSONCodec{}, nil
+	case "yaml", "yml":
+		return YAMLCodec{}, nil
+	case "gob":
+		return GobCodec{}, nil
+	}
+	return nil, errors.Errorf("unknown fallback codec %q", name)
+}
@@ -0,0 +1,56 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// сколько последних байт stderr команды попадает в ошибку
+const maxCommandStderr = 1024
+
+// commandError означает, что команда отработала, но завершилась с ненулевым кодом
+type commandError struct {
+	name   string
+	code   int
+	stderr string
+}
+
+func (e *commandError) Error() string {
+	msg := fmt.Sprintf("%s exited with code %d", e.name, e.code)
+	if e.stderr != "" {
+		msg += ": " + e.stderr
+	}
+	return msg
+}
+
+// runCommand запускает name с аргументами args и возвращает его stdout.
+// Если команда завершилась с ненулевым кодом, возвращает *commandError с концом stderr,
+// если ее прервал ctx - ошибку ctx, а если ее не удалось запустить (например, ее нет в PATH) -
+// ошибку запуска, под которой лежит exec.ErrNotFound
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, errors.Wrapf(ctx.Err(), "%s was interrupted", name)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > maxCommandStderr {
+			msg = "..." + msg[len(msg)-maxCommandStderr:]
+		}
+		return nil, &commandError{name: name, code: exitErr.ExitCode(), stderr: msg}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to run %s", name)
+	}
+	return stdout.Bytes(), nil
+}
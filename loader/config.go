@@ -0,0 +1,91 @@
+package loader
+
+import (
+	"context"
+	"time"
+)
+
+type Config struct {
+	// Здесь содержатся конфиги для AppLoader
+	LoaderConfig
+	// Здесь лежит указатель на конфиг самого приложения
+	App interface{} `json:"app_config"`
+	// откуда взяты значения полей App, только в ответе /loader/config?provenance=1, см. AppLoader.Provenance
+	Provenance map[string]ValueOrigin `json:"loader_provenance,omitempty"`
+}
+
+type LoaderConfig struct {
+	UsesFallbackConfig   bool          `ignored:"true" json:"loader_uses_fallback_config"`
+	FallbackIndex        int           `ignored:"true" json:"loader_fallback_index,omitempty"`
+	FallbackSavedAt      *time.Time    `ignored:"true" json:"loader_fallback_saved_at,omitempty"`
+	IgnoreFallbackConfig bool          `envconfig:"loader_ignore_fallback_config" json:"loader_ignore_fallback_config"`
+	Strict               bool          `envconfig:"loader_strict" json:"loader_strict,omitempty"`
+	HoldOnFailure        bool          `envconfig:"loader_hold_on_failure" json:"loader_hold_on_failure,omitempty"`
+	RetryMinInterval     time.Duration `envconfig:"loader_retry_min_interval" json:"loader_retry_min_interval,omitempty"`
+	RetryMaxInterval     time.Duration `envconfig:"loader_retry_max_interval" json:"loader_retry_max_interval,omitempty"`
+	ConfigError          string        `ignored:"true" json:"loader_config_error,omitempty"`
+	ConfigErrorFields    []FieldError  `ignored:"true" json:"loader_config_error_fields,omitempty"`
+	FallbackDiff         []FieldDiff   `ignored:"true" json:"loader_fallback_diff,omitempty"`
+	FallbackSections     []string      `ignored:"true" json:"loader_fallback_sections,omitempty"`
+	DefaultedFields      []string      `ignored:"true" json:"loader_defaulted_fields,omitempty"`
+	ConfigHash           string        `ignored:"true" json:"loader_config_hash,omitempty"`
+	CanaryHeld           bool          `ignored:"true" json:"loader_canary_held,omitempty"`
+	StartTimeout         time.Duration `envconfig:"loader_start_timeout" json:"loader_start_timeout"`
+	LoadTimeout          time.Duration `envconfig:"loader_load_timeout" json:"loader_load_timeout"`
+	StopTimeout          time.Duration `envconfig:"loader_stop_timeout" json:"loader_stop_timeout"`
+	EnvFile              string        `envconfig:"loader_env_file" json:"loader_env_file,omitempty"`
+	ConfigFile           string        `envconfig:"loader_config_file" json:"loader_config_file,omitempty"`
+	Profile              string        `envconfig:"loader_profile" json:"loader_profile,omitempty"`
+	ConfigURL            string        `envconfig:"loader_config_url" json:"loader_config_url,omitempty"`
+	HTTPTimeout          time.Duration `envconfig:"loader_http_timeout" json:"loader_http_timeout,omitempty"`
+	ConfigExec           string        `envconfig:"loader_config_exec" json:"loader_config_exec,omitempty"`
+	ConfigExecTimeout    time.Duration `envconfig:"loader_config_exec_timeout" json:"loader_config_exec_timeout,omitempty"`
+	MergeConflict        string        `envconfig:"loader_merge_conflict" json:"loader_merge_conflict,omitempty"`
+	Watch                bool          `envconfig:"loader_watch" json:"loader_watch"`
+	WatchInterval        time.Duration `envconfig:"loader_watch_interval" json:"loader_watch_interval"`
+	FlagsURL             string        `envconfig:"loader_flags_url" json:"loader_flags_url,omitempty"`
+	FlagsInterval        time.Duration `envconfig:"loader_flags_interval" json:"loader_flags_interval,omitempty"`
+	ReloadOnSighup       bool          `envconfig:"loader_reload_on_sighup" json:"loader_reload_on_sighup"`
+	SDNotify             bool          `envconfig:"loader_sd_notify" json:"loader_sd_notify,omitempty"`
+	ReloadStrategy       string        `envconfig:"loader_reload_strategy" json:"loader_reload_strategy,omitempty"`
+	CanaryPercent        int           `envconfig:"loader_canary_percent" json:"loader_canary_percent,omitempty"`
+	CanaryID             string        `envconfig:"loader_canary_id" json:"loader_canary_id,omitempty"`
+	FallbackPath         string        `envconfig:"loader_fallback_path" json:"loader_fallback_path,omitempty"`
+	FallbackStore        string        `envconfig:"loader_fallback_store" json:"loader_fallback_store,omitempty"`
+	FallbackCodec        string        `envconfig:"loader_fallback_codec" json:"loader_fallback_codec,omitempty"`
+	FallbackHistory      int           `envconfig:"loader_fallback_history" json:"loader_fallback_history"`
+	FallbackKey          string        `envconfig:"loader_fallback_key" json:"-"`
+	FallbackMaxAge       time.Duration `envconfig:"loader_fallback_max_age" json:"loader_fallback_max_age,omitempty"`
+	FallbackStalePolicy  string        `envconfig:"loader_fallback_stale_policy" json:"loader_fallback_stale_policy,omitempty"`
+	FallbackLoaderConfig bool          `envconfig:"loader_fallback_loader_config" json:"loader_fallback_loader_config,omitempty"`
+	UnknownEnv           string        `envconfig:"loader_unknown_env" json:"loader_unknown_env,omitempty"`
+	BadConfigMatch       string        `envconfig:"loader_bad_config_match" json:"loader_bad_config_match,omitempty"`
+	ExpandEnv            bool          `envconfig:"loader_expand_env" json:"loader_expand_env,omitempty"`
+	SchemaMismatch       string        `envconfig:"loader_schema_mismatch" json:"loader_schema_mismatch"`
+	ProbationPeriod      time.Duration `envconfig:"loader_probation_period" json:"loader_probation_period,omitempty"`
+	ProbationInterval    time.Duration `envconfig:"loader_probation_interval" json:"loader_probation_interval,omitempty"`
+	ProbeURL             string        `envconfig:"loader_probe_url" json:"loader_probe_url,omitempty"`
+	CrashLoopThreshold   int           `envconfig:"loader_crash_loop_threshold" json:"loader_crash_loop_threshold,omitempty"`
+	CrashLoopWindow      time.Duration `envconfig:"loader_crash_loop_window" json:"loader_crash_loop_window,omitempty"`
+	AdminAddr            string        `envconfig:"loader_admin_addr" json:"loader_admin_addr,omitempty"`
+	AdminToken           string        `envconfig:"loader_admin_token" json:"-"`
+	AdminTLSCert         string        `envconfig:"loader_admin_tls_cert" json:"loader_admin_tls_cert,omitempty"`
+	AdminTLSKey          string        `envconfig:"loader_admin_tls_key" json:"-"`
+	AdminClientCA        string        `envconfig:"loader_admin_client_ca" json:"loader_admin_client_ca,omitempty"`
+	AdminDebug           bool          `envconfig:"loader_admin_debug" json:"loader_admin_debug,omitempty"`
+	DiagnosticsAddr      string        `envconfig:"loader_diagnostics_addr" json:"loader_diagnostics_addr,omitempty"`
+	AuditLog             string        `envconfig:"loader_audit_log" json:"loader_audit_log,omitempty"`
+	StartupReport        string        `envconfig:"loader_startup_report" json:"loader_startup_report,omitempty"`
+	NotifyWebhook        string        `envconfig:"loader_notify_webhook" json:"-"`
+	NotifySlack          string        `envconfig:"loader_notify_slack" json:"-"`
+	PrintConfigDoc       string        `envconfig:"loader_print_config_doc" json:"-"`
+}
+
+// ConfigProvider отдает текущий конфиг, с которым было собрано приложение.
+// Предоставляется в fx граф загрузчиком.
+type ConfigProvider interface {
+	Config() Config
+	// Subscribe возвращает канал с новыми конфигами после hot reload и откатов,
+	// канал закрывается, когда отменен ctx
+	Subscribe(ctx context.Context) <-chan Config
+}
@@ -0,0 +1,46 @@
+package loader
+
+import (
+	"net/http"
+)
+
+// ConfigHashHeader - заголовок ответа с хешем конфига, см. ConfigHashMiddleware
+const ConfigHashHeader = "X-Config-Hash"
+
+// configHash - sha256 конфига приложения в кодеке хранилища, тот же, что в журнале конфигов.
+// Пустой, если конфиг не кодируется
+func (l *AppLoader) configHash(app interface{}) string {
+	if l.codec == nil {
+		return ""
+	}
+	payload, err := l.codec.Encode(app)
+	if err != nil {
+		return ""
+	}
+	return checksum(payload)
+}
+
+// ConfigHash возвращает хеш конфига, на котором работает приложение, без копирования всего конфига
+func (l *AppLoader) ConfigHash() string {
+	if cfg := l.snapshot.Load(); cfg != nil {
+		return cfg.ConfigHash
+	}
+	return l.Config().ConfigHash
+}
+
+// ConfigHashMiddleware добавляет к ответам next заголовок X-Config-Hash с хешем текущего конфига,
+// так что по ответу видно, на каком конфиге работает экземпляр
+func ConfigHashMiddleware(provider ConfigProvider, next http.Handler) http.Handler {
+	hash := func() string {
+		return provider.Config().ConfigHash
+	}
+	if h, ok := provider.(interface{ ConfigHash() string }); ok {
+		hash = h.ConfigHash
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := hash(); v != "" {
+			w.Header().Set(ConfigHashHeader, v)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,159 @@
+package consulstore
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sgrishanin/fx-rollback-proto/loader"
+)
+
+// минимальный TTL сессии, который принимает Consul
+const minSessionTTL = time.Second * 10
+
+const defaultSessionTTL = time.Minute
+
+// Leader реализует loader.LeaderElector блокировкой ключа в Consul.
+// Лидером становится реплика, первой захватившая ключ сессией с TTL, и остается им,
+// пока продлевает сессию. Если лидер пропал, ключ освобождается по истечении TTL
+// и его захватывает следующая реплика, которая сохраняет конфиг.
+type Leader struct {
+	consul *Store
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	session string
+	// закрывается, чтобы остановить продление сессии
+	stop chan struct{}
+}
+
+var _ loader.LeaderElector = (*Leader)(nil)
+
+// NewLeader создает выборы лидера на ключе lockKey, это должен быть отдельный ключ,
+// а не ключ Store с конфигом. ttl - TTL сессии Consul, не меньше 10s, по умолчанию минута
+func NewLeader(lockKey string, ttl time.Duration, opts ...Option) *Leader {
+	if ttl == 0 {
+		ttl = defaultSessionTTL
+	}
+	if ttl < minSessionTTL {
+		ttl = minSessionTTL
+	}
+	return &Leader{consul: New(lockKey, opts...), ttl: ttl}
+}
+
+// IsLeader захватывает ключ, если он свободен. Для лидера захват повторно проходит успешно
+func (l *Leader) IsLeader(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.session == "" {
+		if err := l.createSession(ctx); err != nil {
+			return false, err
+		}
+	}
+	holder, _ := os.Hostname()
+	resp, err := l.consul.do(ctx, http.MethodPut, url.Values{"acquire": {l.session}}, []byte(holder))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		// сессия могла истечь, пока реплика не могла достучаться до Consul
+		l.closeSession()
+		return false, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read consul response")
+	}
+	return strings.TrimSpace(string(body)) == "true", nil
+}
+
+// Close освобождает ключ, чтобы лидером сразу стала другая реплика, а не через TTL
+func (l *Leader) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.session == "" {
+		return nil
+	}
+	session := l.session
+	l.closeSession()
+	resp, err := l.consul.request(context.Background(), http.MethodPut, "session/destroy/"+session, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+func (l *Leader) createSession(ctx context.Context) error {
+	body, err := json.Marshal(map[string]string{
+		"Name":     l.consul.key,
+		"TTL":      l.ttl.String(),
+		"Behavior": "release",
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := l.consul.request(ctx, http.MethodPut, "session/create", nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return errors.Wrap(err, "failed to create consul session")
+	}
+	var created struct {
+		ID string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return errors.Wrap(err, "failed to decode consul response")
+	}
+	l.session = created.ID
+	l.stop = make(chan struct{})
+	go l.renew(l.session, l.stop)
+	return nil
+}
+
+// renew продлевает сессию каждые пол TTL, пока ее не закроют или она не истечет
+func (l *Leader) renew(session string, stop chan struct{}) {
+	ticker := time.NewTicker(l.ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), l.ttl/2)
+		resp, err := l.consul.request(ctx, http.MethodPut, "session/renew/"+session, nil, nil)
+		cancel()
+		if err != nil {
+			// Consul недоступен, попробуем на следующем тике, пока сессия не истекла
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			// сессия истекла, при следующем сохранении IsLeader создаст новую
+			l.mu.Lock()
+			if l.session == session {
+				l.closeSession()
+			}
+			l.mu.Unlock()
+			return
+		}
+	}
+}
+
+// closeSession забывает текущую сессию, вызывается под mu
+func (l *Leader) closeSession() {
+	close(l.stop)
+	l.session = ""
+}
@@ -0,0 +1,251 @@
+// Package consulstore хранит последний рабочий конфиг loader в Consul KV.
+// Работает напрямую с HTTP API Consul, чтобы не тянуть в зависимости его клиент.
+package consulstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sgrishanin/fx-rollback-proto/loader"
+)
+
+// ErrCASConflict означает, что ключ постоянно меняется другими репликами
+// и записать конфиг за отведенное число попыток не получилось
+var ErrCASConflict = errors.New("consul cas conflict")
+
+const (
+	defaultAddr       = "127.0.0.1:8500"
+	defaultTimeout    = time.Second * 10
+	defaultCASRetries = 5
+)
+
+// Store реализует loader.FallbackStore.
+// Запись идет через CAS по ModifyIndex, чтобы реплики, которые одновременно
+// сохраняют конфиг, не затирали друг друга вслепую.
+type Store struct {
+	addr       string
+	token      string
+	key        string
+	client     *http.Client
+	casRetries int
+}
+
+type Option func(s *Store)
+
+// WithAddress задает адрес агента Consul, по умолчанию берется из CONSUL_HTTP_ADDR
+func WithAddress(addr string) Option {
+	return func(s *Store) {
+		s.addr = addr
+	}
+}
+
+// WithToken задает ACL токен, по умолчанию берется из CONSUL_HTTP_TOKEN
+func WithToken(token string) Option {
+	return func(s *Store) {
+		s.token = token
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *Store) {
+		s.client = client
+	}
+}
+
+// WithCASRetries задает, сколько раз повторять запись при конфликте CAS
+func WithCASRetries(n int) Option {
+	return func(s *Store) {
+		s.casRetries = n
+	}
+}
+
+func New(key string, opts ...Option) *Store {
+	s := &Store{
+		addr:       os.Getenv("CONSUL_HTTP_ADDR"),
+		token:      os.Getenv("CONSUL_HTTP_TOKEN"),
+		key:        strings.TrimPrefix(key, "/"),
+		client:     &http.Client{Timeout: defaultTimeout},
+		casRetries: defaultCASRetries,
+	}
+	if s.addr == "" {
+		s.addr = defaultAddr
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if !strings.Contains(s.addr, "://") {
+		scheme := "http"
+		if ssl, _ := strconv.ParseBool(os.Getenv("CONSUL_HTTP_SSL")); ssl {
+			scheme = "https"
+		}
+		s.addr = scheme + "://" + s.addr
+	}
+	s.addr = strings.TrimSuffix(s.addr, "/")
+	return s
+}
+
+var (
+	_ loader.ContextStore = (*Store)(nil)
+	_ loader.DeleteStore  = (*Store)(nil)
+)
+
+func (s *Store) Load() ([]byte, error) {
+	return s.LoadContext(context.Background())
+}
+
+func (s *Store) Save(data []byte) error {
+	return s.SaveContext(context.Background(), data)
+}
+
+func (s *Store) LoadContext(ctx context.Context) ([]byte, error) {
+	pair, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, loader.ErrFallbackNotFound
+	}
+	return pair.Value, nil
+}
+
+func (s *Store) SaveContext(ctx context.Context, data []byte) error {
+	for i := 0; i < s.casRetries; i++ {
+		pair, err := s.get(ctx)
+		if err != nil {
+			return err
+		}
+		// индекс 0 означает "записать, только если ключа еще нет"
+		var index uint64
+		if pair != nil {
+			if bytes.Equal(pair.Value, data) {
+				// другая реплика уже сохранила такой же конфиг
+				return nil
+			}
+			index = pair.ModifyIndex
+		}
+		ok, err := s.cas(ctx, data, index)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrCASConflict, "failed to save key %q after %d attempts", s.key, s.casRetries)
+}
+
+// Delete удаляет ключ с конфигом. Истории в Consul нет, так что сохраненного конфига больше не будет.
+// Удаление тоже идет через CAS, чтобы не стереть конфиг, который другая реплика сохранила только что
+func (s *Store) Delete() error {
+	ctx := context.Background()
+	pair, err := s.get(ctx)
+	if err != nil {
+		return err
+	}
+	if pair == nil {
+		return loader.ErrFallbackNotFound
+	}
+	query := url.Values{"cas": {strconv.FormatUint(pair.ModifyIndex, 10)}}
+	resp, err := s.do(ctx, http.MethodDelete, query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read consul response")
+	}
+	if strings.TrimSpace(string(body)) != "true" {
+		return errors.Wrapf(ErrCASConflict, "key %q changed while deleting", s.key)
+	}
+	return nil
+}
+
+type kvPair struct {
+	Value       []byte
+	ModifyIndex uint64
+}
+
+func (s *Store) get(ctx context.Context) (*kvPair, error) {
+	resp, err := s.do(ctx, http.MethodGet, url.Values{"consistent": {""}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var pairs []kvPair
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		return nil, errors.Wrap(err, "failed to decode consul response")
+	}
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	return &pairs[0], nil
+}
+
+func (s *Store) cas(ctx context.Context, data []byte, index uint64) (bool, error) {
+	query := url.Values{"cas": {strconv.FormatUint(index, 10)}}
+	resp, err := s.do(ctx, http.MethodPut, query, data)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp); err != nil {
+		return false, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read consul response")
+	}
+	return strings.TrimSpace(string(body)) == "true", nil
+}
+
+func (s *Store) do(ctx context.Context, method string, query url.Values, body []byte) (*http.Response, error) {
+	return s.request(ctx, method, "kv/"+s.key, query, body)
+}
+
+func (s *Store) request(ctx context.Context, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	u := fmt.Sprintf("%s/v1/%s?%s", s.addr, path, query.Encode())
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "consul request %s %s failed", method, path)
+	}
+	return resp, nil
+}
+
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return errors.Errorf("consul responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,95 @@
+package loader
+
+import (
+	"context"
+)
+
+// ContextSource - источник, загрузку из которого можно прервать через ctx.
+// Если источник реализует ContextSource, загрузчик вызывает LoadContext вместо Load.
+// Отмена ctx - не ошибка конфига: LoadContext должен вернуть ее как есть, а не ErrBadConfig,
+// иначе загрузчик начнет откатываться на сохраненный конфиг с уже отмененным ctx.
+type ContextSource interface {
+	ConfigSource
+	LoadContext(ctx context.Context, cfg interface{}) error
+}
+
+// ContextStore - хранилище рабочих конфигов, операции которого можно прервать через ctx
+type ContextStore interface {
+	FallbackStore
+	SaveContext(ctx context.Context, data []byte) error
+	LoadContext(ctx context.Context) ([]byte, error)
+}
+
+// ContextHistoryStore - HistoryStore, чтение истории из которого можно прервать через ctx
+type ContextHistoryStore interface {
+	HistoryStore
+	LoadHistoryContext(ctx context.Context, n int) ([][]byte, error)
+}
+
+// ContextValidator - валидатор, которому нужен ctx, например чтобы сходить во внешний сервис
+type ContextValidator interface {
+	Validator
+	ValidateContext(ctx context.Context, cfg interface{}) error
+}
+
+type ContextValidatorFunc func(ctx context.Context, cfg interface{}) error
+
+func (f ContextValidatorFunc) Validate(cfg interface{}) error {
+	return f(context.Background(), cfg)
+}
+
+func (f ContextValidatorFunc) ValidateContext(ctx context.Context, cfg interface{}) error {
+	return f(ctx, cfg)
+}
+
+// loadFrom читает конфиг из source, передавая ctx источникам, которые его поддерживают.
+// Обычные источники прервать нельзя, поэтому ctx проверяется перед вызовом
+func loadFrom(ctx context.Context, source ConfigSource, cfg interface{}) error {
+	if cs, ok := source.(ContextSource); ok {
+		return cs.LoadContext(ctx, cfg)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return source.Load(cfg)
+}
+
+func loadFromStore(ctx context.Context, store FallbackStore) ([]byte, error) {
+	if cs, ok := store.(ContextStore); ok {
+		return cs.LoadContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return store.Load()
+}
+
+func saveToStore(ctx context.Context, store FallbackStore, data []byte) error {
+	if cs, ok := store.(ContextStore); ok {
+		return cs.SaveContext(ctx, data)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return store.Save(data)
+}
+
+func loadHistoryFromStore(ctx context.Context, store HistoryStore, n int) ([][]byte, error) {
+	if cs, ok := store.(ContextHistoryStore); ok {
+		return cs.LoadHistoryContext(ctx, n)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return store.LoadHistory(n)
+}
+
+func validateWith(ctx context.Context, v Validator, cfg interface{}) error {
+	if cv, ok := v.(ContextValidator); ok {
+		return cv.ValidateContext(ctx, cfg)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return v.Validate(cfg)
+}
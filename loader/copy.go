@@ -0,0 +1,72 @@
+package loader
+
+import "reflect"
+
+// DeepCopy возвращает глубокую копию v: структуры, указатели, слайсы, массивы и map копируются,
+// так что изменения копии не видны в оригинале. Неэкспортируемые поля копируются как есть.
+// Config и его App копируются так же, как их отдает ConfigProvider.Config
+func DeepCopy[T any](v T) T {
+	// через указатель, чтобы T-интерфейс не терял свой тип, а nil копировался в nil
+	out, _ := copyDeep(reflect.ValueOf(&v).Elem()).Interface().(T)
+	return out
+}
+
+// copyInto заменяет значение, на которое указывает dst, копией значения src того же типа.
+// Указатель dst остается прежним, так что его видят все, кто его держит
+func copyInto(dst, src interface{}) {
+	reflect.ValueOf(dst).Elem().Set(copyDeep(reflect.ValueOf(src).Elem()))
+}
+
+func copyDeep(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(copyDeep(v.Elem()))
+		return out
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.Set(copyDeep(v.Elem()))
+		return out
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if f := out.Field(i); f.CanSet() {
+				f.Set(copyDeep(v.Field(i)))
+			}
+		}
+		return out
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(copyDeep(v.Index(i)))
+		}
+		return out
+	case reflect.Array:
+		out := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(copyDeep(v.Index(i)))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(iter.Key(), copyDeep(iter.Value()))
+		}
+		return out
+	}
+	return v
+}
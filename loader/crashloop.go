@@ -0,0 +1,165 @@
+package loader
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+)
+
+// ErrCrashLoop означает, что процесс несколько раз подряд упал вскоре после старта с этим конфигом
+var ErrCrashLoop = errors.New("config is suspected of crash loop")
+
+const defaultCrashLoopWindow = time.Minute
+
+// bootID отличает запуски процесса друг от друга, pid для этого не годится: в контейнере он всегда 1
+var bootID = func() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}()
+
+// startJournal - журнал запусков, который переживает падения процесса (см. LOADER_CRASH_LOOP_THRESHOLD).
+// Хранится рядом с сохраненным конфигом в файле LOADER_FALLBACK_PATH.starts
+type startJournal struct {
+	// контрольная сумма конфига приложения, с которым был последний запуск
+	Config string `json:"config"`
+	// сколько запусков с этим конфигом подряд закончились падением
+	Crashes int `json:"crashes"`
+	// последний запуск еще не подтвержден: процесс не проработал LOADER_CRASH_LOOP_WINDOW
+	// и не остановился штатно
+	Pending   bool       `json:"pending"`
+	Boot      string     `json:"boot"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+}
+
+// crashes возвращает, сколько запусков подряд с конфигом sum закончились падением.
+// Неподтвержденный запуск другого процесса тоже считается падением
+func (j startJournal) crashes(sum string) int {
+	if j.Config != sum {
+		return 0
+	}
+	if j.Pending && j.Boot != bootID {
+		return j.Crashes + 1
+	}
+	return j.Crashes
+}
+
+func journalPath(cfg *Config) string {
+	return cfg.FallbackPath + ".starts"
+}
+
+func readJournal(path string) (startJournal, error) {
+	var j startJournal
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return j, nil
+	}
+	if err != nil {
+		return j, err
+	}
+	return j, json.Unmarshal(data, &j)
+}
+
+// writeStateFile атомарно записывает v в json файл path, который должен пережить перезапуск процесса
+func writeStateFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := writeTempFile(dir, filepath.Base(path), data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Rename(tmp, path)
+}
+
+// configChecksum возвращает контрольную сумму конфига приложения для журнала запусков
+func (l *AppLoader) configChecksum(appCfg interface{}) (string, error) {
+	payload, err := l.codec.Encode(appCfg)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode config")
+	}
+	return checksum(payload), nil
+}
+
+// checkCrashLoop возвращает ErrBadConfig, если с конфигом cfg процесс уже
+// LOADER_CRASH_LOOP_THRESHOLD раз подряд падал вскоре после старта
+func (l *AppLoader) checkCrashLoop(cfg *Config) error {
+	if cfg.CrashLoopThreshold <= 0 {
+		return nil
+	}
+	sum, err := l.configChecksum(cfg.App)
+	if err != nil {
+		return nil
+	}
+	j, err := readJournal(journalPath(cfg))
+	if err != nil {
+		l.log.Error("failed to read start journal", "error", err)
+		return nil
+	}
+	if n := j.crashes(sum); n >= cfg.CrashLoopThreshold {
+		return ErrBadConfig{Cause: errors.Wrapf(ErrCrashLoop, "%d starts in a row crashed within %s", n, cfg.CrashLoopWindow)}
+	}
+	return nil
+}
+
+// beginStart записывает в журнал запуск app с конфигом cfg. Если за LOADER_CRASH_LOOP_WINDOW
+// процесс не упал и app не подменили, запуск подтверждается
+func (l *AppLoader) beginStart(cfg *Config, app *fx.App) {
+	if cfg.CrashLoopThreshold <= 0 || cfg.UsesFallbackConfig {
+		return
+	}
+	sum, err := l.configChecksum(cfg.App)
+	if err != nil {
+		return
+	}
+	path := journalPath(cfg)
+	j, err := readJournal(path)
+	if err != nil {
+		l.log.Error("failed to read start journal", "error", err)
+	}
+	now := time.Now().UTC()
+	next := startJournal{Config: sum, Crashes: j.crashes(sum), Pending: true, Boot: bootID, StartedAt: &now}
+	if err := writeStateFile(path, next); err != nil {
+		l.log.Error("failed to write start journal", "error", err)
+		return
+	}
+	time.AfterFunc(cfg.CrashLoopWindow, func() {
+		if l.currentApp() == app {
+			l.confirmStart(path, sum)
+		}
+	})
+}
+
+// confirmStart отмечает последний запуск с конфигом sum как успешный
+func (l *AppLoader) confirmStart(path, sum string) {
+	j, err := readJournal(path)
+	if err != nil || j.Config != sum || !j.Pending {
+		return
+	}
+	if err := writeStateFile(path, startJournal{Config: sum, Boot: bootID, StartedAt: j.StartedAt}); err != nil {
+		l.log.Error("failed to write start journal", "error", err)
+	}
+}
+
+// confirmStop отмечает запуск текущего конфига как успешный при штатной остановке
+func (l *AppLoader) confirmStop() {
+	cfg := l.Config()
+	if cfg.CrashLoopThreshold <= 0 || cfg.UsesFallbackConfig {
+		return
+	}
+	if sum, err := l.configChecksum(cfg.App); err == nil {
+		l.confirmStart(journalPath(&cfg), sum)
+	}
+}
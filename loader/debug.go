@@ -0,0 +1,187 @@
+package loader
+
+import (
+	"expvar"
+	"fmt"
+	"html"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// net/http/pprof не используется: он регистрирует профили в http.DefaultServeMux,
+// и приложение, которое отдает DefaultServeMux наружу, отдало бы и их
+
+// сколько по умолчанию пишется профиль CPU и trace, как в net/http/pprof
+const defaultProfileDuration = 30 * time.Second
+
+// debugRoutes добавляет в админку отладочные ручки, см. LOADER_ADMIN_DEBUG:
+//   - GET /debug/pprof/ - список профилей, /debug/pprof/<name> - профиль (heap, goroutine, allocs, block, mutex...),
+//     ?debug=1 или 2 - в текстовом виде, /debug/pprof/goroutine?debug=2 - стеки всех горутин;
+//   - GET /debug/pprof/profile?seconds=30 - профиль CPU, /debug/pprof/trace?seconds=5 - trace;
+//   - GET /debug/vars - переменные expvar;
+//   - GET /debug/gc - статистика GC и памяти, POST /debug/gc - собрать мусор и вернуть память ОС.
+func debugRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", handlePprof)
+	mux.HandleFunc("/debug/pprof/profile", handleCPUProfile)
+	mux.HandleFunc("/debug/pprof/trace", handleTrace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/gc", handleGC)
+}
+
+func handlePprof(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[len("/debug/pprof/"):]
+	if name == "" {
+		handlePprofIndex(w)
+		return
+	}
+	profile := pprof.Lookup(name)
+	if profile == nil {
+		http.Error(w, "unknown profile "+name, http.StatusNotFound)
+		return
+	}
+	level, _ := strconv.Atoi(r.FormValue("debug"))
+	if name == "heap" && r.FormValue("gc") != "" {
+		runtime.GC()
+	}
+	if level > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	}
+	_ = profile.WriteTo(w, level)
+}
+
+func handlePprofIndex(w http.ResponseWriter) {
+	profiles := pprof.Profiles()
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name() < profiles[j].Name() })
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, "<html><body><table>\n")
+	for _, p := range profiles {
+		name := html.EscapeString(p.Name())
+		fmt.Fprintf(w, "<tr><td>%d</td><td><a href=\"%s?debug=1\">%s</a></td></tr>\n", p.Count(), name, name)
+	}
+	fmt.Fprint(w, "<tr><td></td><td><a href=\"goroutine?debug=2\">goroutine stacks</a></td></tr>\n")
+	fmt.Fprint(w, "<tr><td></td><td><a href=\"profile\">profile</a> (CPU, 30s)</td></tr>\n")
+	fmt.Fprint(w, "<tr><td></td><td><a href=\"trace?seconds=5\">trace</a></td></tr>\n")
+	fmt.Fprint(w, "</table></body></html>\n")
+}
+
+func handleCPUProfile(w http.ResponseWriter, r *http.Request) {
+	d, ok := profileDuration(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		// профиль CPU может писать только один запрос
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "failed to start CPU profile: "+err.Error(), http.StatusConflict)
+		return
+	}
+	sleep(r, d)
+	pprof.StopCPUProfile()
+}
+
+func handleTrace(w http.ResponseWriter, r *http.Request) {
+	d, ok := profileDuration(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace"`)
+	if err := trace.Start(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "failed to start trace: "+err.Error(), http.StatusConflict)
+		return
+	}
+	sleep(r, d)
+	trace.Stop()
+}
+
+func profileDuration(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	d := defaultProfileDuration
+	if s := r.FormValue("seconds"); s != "" {
+		sec, err := strconv.ParseFloat(s, 64)
+		if err != nil || sec <= 0 {
+			http.Error(w, "invalid seconds", http.StatusBadRequest)
+			return 0, false
+		}
+		d = time.Duration(sec * float64(time.Second))
+	}
+	return d, true
+}
+
+// sleep ждет d или пока клиент не отключится
+func sleep(r *http.Request, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+}
+
+// gcStats - ответ /debug/gc
+type gcStats struct {
+	NumGC         int64      `json:"num_gc"`
+	LastGC        *time.Time `json:"last_gc,omitempty"`
+	PauseTotal    string     `json:"pause_total"`
+	RecentPauses  []string   `json:"recent_pauses"`
+	HeapAlloc     uint64     `json:"heap_alloc"`
+	HeapSys       uint64     `json:"heap_sys"`
+	HeapObjects   uint64     `json:"heap_objects"`
+	Sys           uint64     `json:"sys"`
+	NextGC        uint64     `json:"next_gc"`
+	MemoryLimit   int64      `json:"memory_limit"`
+	NumGoroutine  int        `json:"num_goroutine"`
+	GOMAXPROCS    int        `json:"gomaxprocs"`
+	GoVersion     string     `json:"go_version"`
+	GCCPUFraction float64    `json:"gc_cpu_fraction"`
+}
+
+func handleGC(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		debug.FreeOSMemory()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := gcStats{
+		NumGC:         gc.NumGC,
+		PauseTotal:    gc.PauseTotal.String(),
+		HeapAlloc:     mem.HeapAlloc,
+		HeapSys:       mem.HeapSys,
+		HeapObjects:   mem.HeapObjects,
+		Sys:           mem.Sys,
+		NextGC:        mem.NextGC,
+		MemoryLimit:   debug.SetMemoryLimit(-1), // с отрицательным значением только возвращает текущий лимит
+		NumGoroutine:  runtime.NumGoroutine(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		GoVersion:     runtime.Version(),
+		GCCPUFraction: mem.GCCPUFraction,
+	}
+	if gc.NumGC > 0 {
+		stats.LastGC = &gc.LastGC
+	}
+	for i, p := range gc.Pause {
+		if i == 10 {
+			break
+		}
+		stats.RecentPauses = append(stats.RecentPauses, p.String())
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,79 @@
+package loader
+
+import (
+	"context"
+	"reflect"
+	"sort"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Defaulter может реализовать конфиг приложения (или конфиг секции из WithConfig),
+// чтобы задать значения по умолчанию, которые неудобно писать тегом default.
+// Defaults вызывается перед чтением конфига из источника, после подстановки тегов default.
+type Defaulter interface {
+	Defaults()
+}
+
+// loadSource заполняет appCfg значениями по умолчанию и читает в него конфиг из источника
+func (l *AppLoader) loadSource(ctx context.Context, appCfg interface{}) (err error) {
+	ctx, span := l.startSpan(ctx, spanLoad, attribute.String("source", sourceName(l.source)))
+	defer func() { endSpan(span, err) }()
+
+	if err := l.applyDefaults(appCfg); err != nil {
+		return withClass(ErrConfigParse, err)
+	}
+	if err := loadFrom(ctx, l.source, appCfg); err != nil {
+		return withClass(ErrConfigParse, phaseError(ctx, phaseLoad, err))
+	}
+	if err := l.checkUnknownEnv(appCfg); err != nil {
+		return withClass(ErrConfigParse, phaseError(ctx, phaseLoad, err))
+	}
+	if err := l.expandEnv(appCfg); err != nil {
+		return withClass(ErrConfigParse, phaseError(ctx, phaseLoad, err))
+	}
+	l.applyFlags(appCfg)
+	return withClass(ErrConfigParse, l.afterLoad(appCfg))
+}
+
+// applyDefaults подставляет значения из тегов default и вызывает Defaults у конфига и секций
+func (l *AppLoader) applyDefaults(appCfg interface{}) error {
+	if err := applyDefaults(appCfg); err != nil {
+		return errors.Wrap(err, "failed to apply defaults")
+	}
+	targets := []interface{}{appCfg}
+	if len(l.sections) > 0 {
+		targets = targets[:0]
+		for _, v := range sectionValues(appCfg) {
+			targets = append(targets, v.Interface())
+		}
+	}
+	for _, t := range targets {
+		if d, ok := t.(Defaulter); ok {
+			d.Defaults()
+		}
+	}
+	return nil
+}
+
+// defaultedFields возвращает пути к полям appCfg, значения которых совпадают
+// с непустыми значениями по умолчанию, то есть, скорее всего, не заданы ни в одном источнике
+func (l *AppLoader) defaultedFields(appCfg interface{}) []string {
+	defaults := newAppConfig(appCfg)
+	if err := l.applyDefaults(defaults); err != nil {
+		return nil
+	}
+	values := flattenConfig(appCfg)
+	var fields []string
+	for path, def := range flattenConfig(defaults) {
+		if def.value == nil || reflect.ValueOf(def.value).IsZero() {
+			continue
+		}
+		if reflect.DeepEqual(values[path].value, def.value) {
+			fields = append(fields, path)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,34 @@
+package loader
+
+import (
+	"net/http"
+)
+
+// diagnosticsHandler отвечает на любой запрос 503 с состоянием загрузчика и плохими полями конфига,
+// пока приложение не собралось ни с одним конфигом, см. LOADER_DIAGNOSTICS_ADDR.
+// POST /loader/reload перечитывает конфиг сразу, не дожидаясь следующей попытки
+func (l *AppLoader) diagnosticsHandler() http.Handler {
+	// перечитать конфиг можно с теми же правами, что и через админку
+	reload := l.protectAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l.retry <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/loader/reload" {
+			reload.ServeHTTP(w, r)
+			return
+		}
+		writeJSON(w, http.StatusServiceUnavailable, l.Status())
+	})
+}
+
+// initDiagnostics включает ожидание рабочего конфига: без него процесс упал бы раньше,
+// чем диагностика успела бы что-то показать
+func (l *AppLoader) initDiagnostics() {
+	if l.cfg.DiagnosticsAddr != "" {
+		l.cfg.HoldOnFailure = true
+	}
+}
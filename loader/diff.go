@@ -0,0 +1,132 @@
+package loader
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// FieldDiff - поле, значение которого отличается в отвергнутом и примененном конфигах.
+// Значения секретных полей замаскированы.
+type FieldDiff struct {
+	Field string `json:"field"`
+	// значение в отвергнутом конфиге
+	Old interface{} `json:"old"`
+	// значение в конфиге, на котором работает приложение
+	New interface{} `json:"new"`
+}
+
+// flatValue - значение поля конфига, снятое до того, как конфиг перезапишется сохраненным
+type flatValue struct {
+	value  interface{}
+	secret bool
+}
+
+// flattenConfig снимает значения всех полей конфига приложения по их путям.
+// Слайсы и map копируются, потому что при чтении сохраненного конфига
+// в ту же структуру их содержимое может перезаписаться.
+func flattenConfig(cfg interface{}) map[string]flatValue {
+	values := map[string]flatValue{}
+	secrets := map[string]bool{}
+	walkFields(cfg, func(f configField) {
+		secret := isSecretField(f.Field) || secrets[parentPath(f.Path)]
+		if secret {
+			secrets[f.Path] = true
+		}
+		v := deref(f.Value)
+		if v.Kind() == reflect.Struct && !isScalar(v) {
+			return
+		}
+		values[f.Path] = flatValue{value: copyValue(v), secret: secret}
+	})
+	return values
+}
+
+func copyValue(v reflect.Value) interface{} {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return v.Interface()
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(out, v)
+		return out.Interface()
+	case reflect.Map:
+		if v.IsNil() {
+			return v.Interface()
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return out.Interface()
+	}
+	return v.Interface()
+}
+
+// diffConfigs сравнивает снятые значения отвергнутого и примененного конфигов
+func diffConfigs(old, applied map[string]flatValue) []FieldDiff {
+	paths := make([]string, 0, len(old))
+	for path := range old {
+		paths = append(paths, path)
+	}
+	for path := range applied {
+		if _, ok := old[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var diff []FieldDiff
+	for _, path := range paths {
+		o, n := old[path], applied[path]
+		if reflect.DeepEqual(o.value, n.value) {
+			continue
+		}
+		d := FieldDiff{Field: path, Old: displayValue(o.value), New: displayValue(n.value)}
+		if o.secret || n.secret {
+			d.Old, d.New = maskDiffValue(o.value), maskDiffValue(n.value)
+		}
+		diff = append(diff, d)
+	}
+	return diff
+}
+
+// displayValue показывает значения вроде time.Duration так, как их пишут в конфиге
+func displayValue(v interface{}) interface{} {
+	if s, ok := v.(fmt.Stringer); ok && reflect.ValueOf(v).Kind() != reflect.Struct {
+		return s.String()
+	}
+	return v
+}
+
+func maskDiffValue(v interface{}) interface{} {
+	if v == nil || reflect.ValueOf(v).IsZero() {
+		return nil
+	}
+	return redactedValue
+}
+
+func parentPath(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == '.' {
+			return path[:i]
+		}
+	}
+	return ""
+}
+
+// Diff сравнивает конфиги a и b одного типа по значениям полей: FieldDiff.Old - значение из a,
+// New - из b. Значения секретных полей замаскированы
+func Diff(a, b interface{}) []FieldDiff {
+	return diffConfigs(flattenConfig(a), flattenConfig(b))
+}
+
+// Equal проверяет, что у конфигов a и b одинаковые значения всех полей
+func Equal(a, b interface{}) bool {
+	return len(Diff(a, b)) == 0
+}
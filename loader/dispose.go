@@ -0,0 +1,106 @@
+package loader
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/fx"
+	"go.uber.org/multierr"
+)
+
+// Disposer предоставляется в fx граф загрузчиком. Через него конструктор, который занимает ресурс
+// прямо при сборке графа (net.Listen, открытый файл, соединение), регистрирует его освобождение.
+// Загрузчик вызывает зарегистрированные функции в обратном порядке, когда это поколение приложения
+// больше не нужно: после Stop, а если граф не собрался или приложение не стартовало - сразу,
+// до того как собирать приложение на сохраненном конфиге, так что повторная сборка
+// не наткнется на порт, занятый первой. Ресурсы, за которые отвечают OnStop хуки, регистрировать не нужно
+type Disposer interface {
+	OnDispose(fn func() error)
+}
+
+// disposer - освобождение ресурсов одного собранного графа
+type disposer struct {
+	mu       sync.Mutex
+	fns      []func() error
+	disposed bool
+}
+
+func (d *disposer) OnDispose(fn func() error) {
+	d.mu.Lock()
+	if !d.disposed {
+		d.fns = append(d.fns, fn)
+		d.mu.Unlock()
+		return
+	}
+	d.mu.Unlock()
+	// граф уже брошен, например конструктор завис и досрочно завершился по таймауту загрузки
+	_ = fn()
+}
+
+// dispose вызывает зарегистрированные функции один раз, последние зарегистрированные - первыми
+func (d *disposer) dispose() error {
+	d.mu.Lock()
+	fns := d.fns
+	d.fns = nil
+	d.disposed = true
+	d.mu.Unlock()
+	var err error
+	for i := len(fns) - 1; i >= 0; i-- {
+		err = multierr.Append(err, fns[i]())
+	}
+	return err
+}
+
+// appDisposers - disposer каждого собранного загрузчиком приложения
+type appDisposers struct {
+	mu    sync.Mutex
+	byApp map[*fx.App]*disposer
+}
+
+func (s *appDisposers) add(app *fx.App, d *disposer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.byApp == nil {
+		s.byApp = map[*fx.App]*disposer{}
+	}
+	s.byApp[app] = d
+}
+
+func (s *appDisposers) take(app *fx.App) *disposer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.byApp[app]
+	delete(s.byApp, app)
+	return d
+}
+
+// provideDisposer кладет d в граф. Без d (AppOptions) приложение останавливает не загрузчик,
+// поэтому ресурсы освобождаются OnStop хуком
+func provideDisposer(d *disposer) interface{} {
+	if d != nil {
+		return func() Disposer { return d }
+	}
+	return func(lc fx.Lifecycle) Disposer {
+		d := &disposer{}
+		lc.Append(fx.Hook{OnStop: func(context.Context) error { return d.dispose() }})
+		return d
+	}
+}
+
+// disposeGraph освобождает ресурсы графа, который не собрался
+func (l *AppLoader) disposeGraph(d *disposer) {
+	if err := d.dispose(); err != nil {
+		l.log.Error("failed to dispose app resources", "error", err)
+	}
+}
+
+// stopApp останавливает app и освобождает ресурсы, зарегистрированные в Disposer.
+// Вызывается и для приложения, которое не стартовало: fx откатывает только выполненные OnStart хуки
+func (l *AppLoader) stopApp(ctx context.Context, app *fx.App) error {
+	err := app.Stop(ctx)
+	l.graphs.remove(app)
+	if d := l.disposers.take(app); d != nil {
+		l.disposeGraph(d)
+	}
+	return err
+}
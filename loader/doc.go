@@ -0,0 +1,168 @@
+package loader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// FieldDoc описывает одну настройку конфига приложения
+type FieldDoc struct {
+	// путь к полю, например server.port
+	Field string `json:"field"`
+	// имя переменной окружения
+	Env      string `json:"env"`
+	Type     string `json:"type"`
+	Default  string `json:"default,omitempty"`
+	Validate string `json:"validate,omitempty"`
+	Required bool   `json:"required,omitempty"`
+	Secret   bool   `json:"secret,omitempty"`
+	// описание из тега desc
+	Desc string `json:"desc,omitempty"`
+	// откуда взято текущее значение, только у AppLoader.Describe работающего загрузчика
+	Origin *ValueOrigin `json:"origin,omitempty"`
+
+	typ reflect.Type
+}
+
+// форматы описания конфига для WriteDoc и LOADER_PRINT_CONFIG_DOC
+const (
+	DocFormatText     = "text"
+	DocFormatMarkdown = "markdown"
+	DocFormatJSON     = "json"
+)
+
+// Describe описывает все настройки конфига cfg, который читается из env с префиксом prefix
+func Describe(prefix string, cfg interface{}) ([]FieldDoc, error) {
+	return describe(prefix, cfg, EnvNaming{})
+}
+
+func describe(prefix string, cfg interface{}, naming EnvNaming) ([]FieldDoc, error) {
+	// обход env создает вложенные структуры по nil указателям, так что работаем с копией
+	spec := newAppConfig(cfg)
+	vars, err := gatherEnvVars(prefix, spec, naming)
+	if err != nil {
+		return nil, err
+	}
+	paths := envVarPaths(spec, vars)
+
+	docs := make([]FieldDoc, 0, len(vars))
+	for i, v := range vars {
+		doc := FieldDoc{
+			Field:    paths[i],
+			Env:      v.Key,
+			Type:     v.Field.Type().String(),
+			Default:  v.Tags.Get("default"),
+			Validate: v.Tags.Get("validate"),
+			Required: isTrue(v.Tags.Get("required")) || hasRule(v.Tags.Get("validate"), "required"),
+			Secret:   isSecretTag(v.Tags),
+			Desc:     v.Tags.Get("desc"),
+			typ:      v.Field.Type(),
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// Describe описывает настройки конфига приложения этого загрузчика. Когда конфиг уже загружен,
+// у каждой настройки есть и Origin - откуда взято ее текущее значение, см. Provenance
+func (l *AppLoader) Describe() ([]FieldDoc, error) {
+	docs, err := describe(l.prefix, l.cfg.App, l.envNaming)
+	if err != nil || l.source == nil || l.snapshot.Load() == nil {
+		return docs, err
+	}
+	provenance, err := l.Provenance(context.Background())
+	if err != nil {
+		// описание нужно и тогда, когда источник не читается
+		l.log.Error("failed to trace config provenance", "error", err)
+		return docs, nil
+	}
+	for i := range docs {
+		if o, ok := provenance[docs[i].Field]; ok {
+			docs[i].Origin = &o
+		}
+	}
+	return docs, nil
+}
+
+func hasRule(rules, name string) bool {
+	for _, r := range strings.Split(rules, ",") {
+		if strings.TrimSpace(r) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteDoc пишет описание настроек в w в формате text, markdown или json
+func WriteDoc(w io.Writer, docs []FieldDoc, format string) error {
+	switch format {
+	case DocFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(docs)
+	case DocFormatMarkdown:
+		fmt.Fprintln(w, "| Переменная | Поле | Тип | По умолчанию | Проверки | Описание |")
+		fmt.Fprintln(w, "|---|---|---|---|---|---|")
+		for _, d := range docs {
+			fmt.Fprintf(w, "| `%s`%s | %s | %s | %s | %s | %s |\n",
+				d.Env, docMarks(d), d.Field, d.Type, markdownCode(d.Default), markdownCode(d.Validate), d.Desc)
+		}
+		return nil
+	case DocFormatText, "":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ENV\tFIELD\tTYPE\tDEFAULT\tVALIDATE\tDESCRIPTION")
+		for _, d := range docs {
+			fmt.Fprintf(tw, "%s%s\t%s\t%s\t%s\t%s\t%s\n", d.Env, docMarks(d), d.Field, d.Type, d.Default, d.Validate, d.Desc)
+		}
+		return tw.Flush()
+	default:
+		return errors.Errorf("unknown config doc format %q", format)
+	}
+}
+
+// docMarks помечает обязательные (*) и секретные (secret) настройки
+func docMarks(d FieldDoc) string {
+	var marks string
+	if d.Required {
+		marks += " *"
+	}
+	if d.Secret {
+		marks += " (secret)"
+	}
+	return marks
+}
+
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "`" + strings.ReplaceAll(s, "|", "\\|") + "`"
+}
+
+// printConfigDoc печатает описание конфига в stdout и завершает процесс, если задан LOADER_PRINT_CONFIG_DOC
+func (l *AppLoader) printConfigDoc() error {
+	format := l.cfg.PrintConfigDoc
+	if format == "" {
+		return nil
+	}
+	if isTrue(format) {
+		format = DocFormatText
+	}
+	docs, err := l.Describe()
+	if err != nil {
+		return err
+	}
+	if err := WriteDoc(os.Stdout, docs, format); err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
@@ -0,0 +1,67 @@
+package loader
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+)
+
+// appGraphs - граф зависимостей каждого собранного загрузчиком приложения в формате DOT
+type appGraphs struct {
+	mu    sync.Mutex
+	byApp map[*fx.App]fx.DotGraph
+}
+
+func (s *appGraphs) add(app *fx.App, graph fx.DotGraph) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.byApp == nil {
+		s.byApp = map[*fx.App]fx.DotGraph{}
+	}
+	s.byApp[app] = graph
+}
+
+func (s *appGraphs) get(app *fx.App) (fx.DotGraph, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	graph, ok := s.byApp[app]
+	return graph, ok
+}
+
+func (s *appGraphs) remove(app *fx.App) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.byApp, app)
+}
+
+// DotGraph возвращает граф зависимостей текущего приложения в формате DOT (Graphviz), как fx.DotGraph:
+// все конструкторы приложения вместе с тем, что кладет в граф загрузчик (Config, ConfigProvider,
+// ListenerProvider, конфиг приложения и секций). Картинку из него рисует `dot -Tsvg`
+func (l *AppLoader) DotGraph() (string, error) {
+	app := l.currentApp()
+	if app == nil {
+		return "", errors.New("app is not built")
+	}
+	graph, ok := l.graphs.get(app)
+	if !ok {
+		return "", errors.New("app graph is not available")
+	}
+	return string(graph), nil
+}
+
+// handleGraph отдает DotGraph, /loader/graph
+func (l *AppLoader) handleGraph(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	graph, err := l.DotGraph()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+	_, _ = w.Write([]byte(graph))
+}
@@ -0,0 +1,133 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+)
+
+// encryptedMagic отличает зашифрованный конфиг от открытого
+var encryptedMagic = []byte("loader-aesgcm-v1:")
+
+// ErrFallbackEncrypted означает, что сохраненный конфиг зашифрован, а ключ не задан
+var ErrFallbackEncrypted = errors.New("fallback config is encrypted, but no key is set (LOADER_FALLBACK_KEY)")
+
+// EncryptedStore шифрует сохраненный конфиг в AES-GCM перед записью в Store.
+// Открытые конфиги, сохраненные до включения шифрования, читаются как есть
+// и перезаписываются зашифрованными при следующем сохранении.
+type EncryptedStore struct {
+	Store FallbackStore
+	aead  cipher.AEAD
+}
+
+// NewEncryptedStore оборачивает store, key - ключ AES длиной 16, 24 или 32 байта
+func NewEncryptedStore(store FallbackStore, key []byte) (*EncryptedStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid fallback key")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedStore{Store: store, aead: aead}, nil
+}
+
+// parseFallbackKey разбирает ключ из LOADER_FALLBACK_KEY, записанный в base64
+func parseFallbackKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "fallback key must be base64")
+	}
+	return key, nil
+}
+
+var (
+	_ ContextHistoryStore = (*EncryptedStore)(nil)
+	_ DeleteStore         = (*EncryptedStore)(nil)
+)
+
+func (s *EncryptedStore) Save(data []byte) error {
+	return s.SaveContext(context.Background(), data)
+}
+
+func (s *EncryptedStore) Load() ([]byte, error) {
+	return s.LoadContext(context.Background())
+}
+
+func (s *EncryptedStore) LoadHistory(n int) ([][]byte, error) {
+	return s.LoadHistoryContext(context.Background(), n)
+}
+
+// Delete удаляет конфиг из обернутого хранилища, если оно реализует DeleteStore
+func (s *EncryptedStore) Delete() error {
+	return deleteFromStore(s.Store)
+}
+
+func (s *EncryptedStore) SaveContext(ctx context.Context, data []byte) error {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	out := append([]byte{}, encryptedMagic...)
+	out = append(out, nonce...)
+	out = s.aead.Seal(out, nonce, data, encryptedMagic)
+	return saveToStore(ctx, s.Store, out)
+}
+
+func (s *EncryptedStore) LoadContext(ctx context.Context) ([]byte, error) {
+	data, err := loadFromStore(ctx, s.Store)
+	if err != nil {
+		return nil, err
+	}
+	return s.decrypt(data)
+}
+
+func (s *EncryptedStore) LoadHistoryContext(ctx context.Context, n int) ([][]byte, error) {
+	hs, ok := s.Store.(HistoryStore)
+	if !ok {
+		data, err := s.LoadContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{data}, nil
+	}
+	history, err := loadHistoryFromStore(ctx, hs, n)
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]byte, 0, len(history))
+	for _, data := range history {
+		plain, err := s.decrypt(data)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, plain)
+	}
+	return out, nil
+}
+
+func (s *EncryptedStore) decrypt(data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < s.aead.NonceSize() {
+		return nil, errors.New("encrypted fallback config is truncated")
+	}
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, encryptedMagic)
+	if err != nil {
+		return nil, errors.New("failed to decrypt fallback config: wrong key or corrupted data")
+	}
+	return plain, nil
+}
+
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
@@ -0,0 +1,373 @@
+package loader
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
+)
+
+// разбор конфига из переменных окружения по тем же правилам, что и у envconfig,
+// но с подменяемым источником значений, чтобы переменные можно было брать не только из os.Environ
+
+var (
+	wordsRegexp   = regexp.MustCompile("([^A-Z]+|[A-Z]+[^A-Z]+|[A-Z]+)")
+	acronymRegexp = regexp.MustCompile("([A-Z]+)([A-Z][^A-Z]+)")
+)
+
+// envVar описывает переменную окружения, из которой заполняется поле конфига
+type envVar struct {
+	// имя поля в структуре
+	Name string
+	// полное имя переменной с префиксом
+	Key string
+	// имя из тега envconfig без префикса, ищется, если нет Key
+	Alt   string
+	Field reflect.Value
+	Tags  reflect.StructTag
+}
+
+// gatherEnvVars собирает переменные для всех полей spec, вложенные структуры получают префикс поля.
+// Имена строятся по правилам naming
+func gatherEnvVars(prefix string, spec interface{}, naming EnvNaming) ([]envVar, error) {
+	if err := naming.check(); err != nil {
+		return nil, err
+	}
+	s := reflect.ValueOf(spec)
+	if s.Kind() != reflect.Ptr || s.Elem().Kind() != reflect.Struct {
+		return nil, envconfig.ErrInvalidSpecification
+	}
+	s = s.Elem()
+	t := s.Type()
+
+	vars := make([]envVar, 0, s.NumField())
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		sf := t.Field(i)
+		if !f.CanSet() || isTrue(sf.Tag.Get("ignored")) {
+			continue
+		}
+
+		for f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				// указатели на не-структуры заполняются в setFieldValue
+				if f.Type().Elem().Kind() != reflect.Struct {
+					break
+				}
+				f.Set(reflect.New(f.Type().Elem()))
+			}
+			f = f.Elem()
+		}
+
+		v := envVar{
+			Name:  sf.Name,
+			Key:   sf.Name,
+			Alt:   naming.caseOf(sf.Tag.Get("envconfig")),
+			Field: f,
+			Tags:  sf.Tag,
+		}
+		if isTrue(sf.Tag.Get("split_words")) {
+			v.Key = splitWords(sf.Name)
+		}
+		if v.Alt != "" {
+			v.Key = v.Alt
+		}
+		v.Key = naming.caseOf(naming.join(prefix, v.Key))
+		// полное имя из тега env не зависит ни от префикса, ни от правил
+		if full := sf.Tag.Get("env"); full != "" {
+			v.Key = full
+			v.Alt = ""
+		}
+
+		if f.Kind() == reflect.Struct && !isScalar(f) {
+			innerPrefix := prefix
+			if !sf.Anonymous {
+				innerPrefix = v.Key
+			}
+			inner, err := gatherEnvVars(innerPrefix, f.Addr().Interface(), naming)
+			if err != nil {
+				return nil, err
+			}
+			vars = append(vars, inner...)
+			continue
+		}
+		vars = append(vars, v)
+	}
+	return vars, nil
+}
+
+// envVarPaths возвращает пути полей для переменных vars, собранных gatherEnvVars из spec
+func envVarPaths(spec interface{}, vars []envVar) []string {
+	fields := map[reflect.Value]string{}
+	walkFields(spec, func(f configField) {
+		if f.Value.CanAddr() {
+			fields[f.Value.Addr()] = f.Path
+		}
+	})
+	paths := make([]string, len(vars))
+	for i, v := range vars {
+		if v.Field.CanAddr() {
+			paths[i] = fields[v.Field.Addr()]
+		}
+	}
+	return paths
+}
+
+// processEnvLayer заполняет spec значениями, найденными через lookup, как envconfig.Process.
+// Поля, для которых значения нет, не трогаются. Без withDefaults значения из тегов default не подставляются,
+// а обязательное поле считается заданным, если уже заполнено нижним слоем конфига
+func processEnvLayer(prefix string, spec interface{}, lookup func(key string) (string, bool), naming EnvNaming, withDefaults bool) error {
+	vars, err := gatherEnvVars(prefix, spec, naming)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range vars {
+		value, ok := lookup(v.Key)
+		if !ok && v.Alt != "" {
+			value, ok = lookup(v.Alt)
+		}
+
+		def := v.Tags.Get("default")
+		if !withDefaults {
+			def = ""
+		}
+		if !ok && def != "" {
+			value = def
+		}
+		if !ok && def == "" {
+			if isTrue(v.Tags.Get("required")) && (withDefaults || v.Field.IsZero()) {
+				key := v.Key
+				if v.Alt != "" {
+					key = v.Alt
+				}
+				return &missingKeyError{Key: key}
+			}
+			continue
+		}
+
+		if err := setFieldValue(value, v.Field); err != nil {
+			if isSecretTag(v.Tags) {
+				err = redactError(err, value)
+				value = redactedValue
+			}
+			return &envconfig.ParseError{
+				KeyName:   v.Key,
+				FieldName: v.Name,
+				TypeName:  v.Field.Type().String(),
+				Value:     value,
+				Err:       err,
+			}
+		}
+	}
+	return nil
+}
+
+// applyDefaults заполняет поля spec значениями из тегов default
+func applyDefaults(spec interface{}) error {
+	vars, err := gatherEnvVars("", spec, EnvNaming{})
+	if err != nil {
+		return err
+	}
+	for _, v := range vars {
+		def := v.Tags.Get("default")
+		if def == "" {
+			continue
+		}
+		if err := setFieldValue(def, v.Field); err != nil {
+			return errors.Wrapf(err, "invalid default for %s", v.Name)
+		}
+	}
+	return nil
+}
+
+// missingKeyError - не задана обязательная переменная
+type missingKeyError struct {
+	Key string
+}
+
+func (e *missingKeyError) Error() string {
+	return fmt.Sprintf("required key %s missing value", e.Key)
+}
+
+// setFieldValue разбирает строковое значение в поле любого поддерживаемого типа
+func setFieldValue(value string, field reflect.Value) error {
+	// указатель создается до поиска Decoder и остальных, иначе они вызывались бы на nil
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		field = field.Elem()
+	}
+	if d := asDecoder(field); d != nil {
+		return d.Decode(value)
+	}
+	if s := asSetter(field); s != nil {
+		return s.Set(value)
+	}
+	if u := asTextUnmarshaler(field); u != nil {
+		return u.UnmarshalText([]byte(value))
+	}
+	if u := asBinaryUnmarshaler(field); u != nil {
+		return u.UnmarshalBinary([]byte(value))
+	}
+
+	typ := field.Type()
+	switch typ.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if typ == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			field.SetInt(int64(d))
+			return nil
+		}
+		val, err := strconv.ParseInt(value, 0, typ.Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(val)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		val, err := strconv.ParseUint(value, 0, typ.Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(val)
+	case reflect.Bool:
+		val, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(val)
+	case reflect.Float32, reflect.Float64:
+		val, err := strconv.ParseFloat(value, typ.Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(val)
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			field.SetBytes([]byte(value))
+			return nil
+		}
+		sl := reflect.MakeSlice(typ, 0, 0)
+		if strings.TrimSpace(value) != "" {
+			items := strings.Split(value, ",")
+			sl = reflect.MakeSlice(typ, len(items), len(items))
+			for i, item := range items {
+				if err := setFieldValue(item, sl.Index(i)); err != nil {
+					return err
+				}
+			}
+		}
+		field.Set(sl)
+	case reflect.Map:
+		mp := reflect.MakeMap(typ)
+		if strings.TrimSpace(value) != "" {
+			for _, pair := range strings.Split(value, ",") {
+				kv := strings.Split(pair, ":")
+				if len(kv) != 2 {
+					return fmt.Errorf("invalid map item: %q", pair)
+				}
+				k := reflect.New(typ.Key()).Elem()
+				if err := setFieldValue(kv[0], k); err != nil {
+					return err
+				}
+				v := reflect.New(typ.Elem()).Elem()
+				if err := setFieldValue(kv[1], v); err != nil {
+					return err
+				}
+				mp.SetMapIndex(k, v)
+			}
+		}
+		field.Set(mp)
+	default:
+		return errors.Errorf("unsupported type %s", typ)
+	}
+	return nil
+}
+
+// isScalar говорит, что структура сама разбирает свое значение и не раскладывается на поля
+func isScalar(field reflect.Value) bool {
+	return asDecoder(field) != nil || asSetter(field) != nil ||
+		asTextUnmarshaler(field) != nil || asBinaryUnmarshaler(field) != nil
+}
+
+// ищет реализацию интерфейса у значения поля или у указателя на него
+func fieldInterface(field reflect.Value) []interface{} {
+	if !field.CanInterface() {
+		return nil
+	}
+	candidates := []interface{}{field.Interface()}
+	if field.CanAddr() {
+		candidates = append(candidates, field.Addr().Interface())
+	}
+	return candidates
+}
+
+func asDecoder(field reflect.Value) envconfig.Decoder {
+	for _, c := range fieldInterface(field) {
+		if d, ok := c.(envconfig.Decoder); ok {
+			return d
+		}
+	}
+	return nil
+}
+
+func asSetter(field reflect.Value) envconfig.Setter {
+	for _, c := range fieldInterface(field) {
+		if s, ok := c.(envconfig.Setter); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+func asTextUnmarshaler(field reflect.Value) encoding.TextUnmarshaler {
+	for _, c := range fieldInterface(field) {
+		if u, ok := c.(encoding.TextUnmarshaler); ok {
+			return u
+		}
+	}
+	return nil
+}
+
+func asBinaryUnmarshaler(field reflect.Value) encoding.BinaryUnmarshaler {
+	for _, c := range fieldInterface(field) {
+		if u, ok := c.(encoding.BinaryUnmarshaler); ok {
+			return u
+		}
+	}
+	return nil
+}
+
+// splitWords переводит CamelCase в CAMEL_CASE, как split_words в envconfig
+func splitWords(name string) string {
+	words := wordsRegexp.FindAllStringSubmatch(name, -1)
+	if len(words) == 0 {
+		return name
+	}
+	parts := make([]string, 0, len(words))
+	for _, w := range words {
+		if m := acronymRegexp.FindStringSubmatch(w[0]); len(m) == 3 {
+			parts = append(parts, m[1], m[2])
+		} else {
+			parts = append(parts, w[0])
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+func isTrue(s string) bool {
+	b, _ := strconv.ParseBool(s)
+	return b
+}
@@ -0,0 +1,155 @@
+package loader
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envTestDecoder разбирает значение сам через envconfig.Decoder
+type envTestDecoder struct {
+	Value string
+}
+
+func (d *envTestDecoder) Decode(value string) error {
+	d.Value = "decoded:" + value
+	return nil
+}
+
+// envTestSetter разбирает значение сам через envconfig.Setter
+type envTestSetter []string
+
+func (s *envTestSetter) Set(value string) error {
+	*s = strings.Split(value, "|")
+	return nil
+}
+
+type envTestConfig struct {
+	String   string
+	Int      int
+	Int8     int8
+	Hex      int64
+	Uint     uint16
+	Bool     bool
+	Float    float64
+	Duration time.Duration
+	Ptr      *int
+	PtrDur   *time.Duration
+	Bytes    []byte
+	Ints     []int
+	Durs     []time.Duration
+	Empty    []string
+	Map      map[string]int
+	Size     ByteSize
+	URL      *URL
+	Decoder  envTestDecoder
+	DecPtr   *envTestDecoder
+	Setter   envTestSetter
+	Chan     chan int
+	Struct   struct{ A int }
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func durationPtr(v time.Duration) *time.Duration {
+	return &v
+}
+
+func TestSetFieldValue(t *testing.T) {
+	for _, tc := range []struct {
+		field string
+		value string
+		want  interface{}
+	}{
+		{"String", "a,b", "a,b"},
+		{"Int", "-42", -42},
+		{"Int8", "127", int8(127)},
+		{"Hex", "0x1f", int64(31)},
+		{"Uint", "8080", uint16(8080)},
+		{"Bool", "true", true},
+		{"Float", "0.5", 0.5},
+		{"Duration", "1m30s", 90 * time.Second},
+		{"Ptr", "7", intPtr(7)},
+		{"PtrDur", "2s", durationPtr(2 * time.Second)},
+		{"Bytes", "a,b", []byte("a,b")},
+		{"Ints", "1,2,3", []int{1, 2, 3}},
+		{"Durs", "1s,2m", []time.Duration{time.Second, 2 * time.Minute}},
+		{"Empty", " ", []string{}},
+		{"Map", "a:1,b:2", map[string]int{"a": 1, "b": 2}},
+		{"Size", "2KiB", ByteSize(2048)},
+		{"Decoder", "x", envTestDecoder{Value: "decoded:x"}},
+		{"DecPtr", "x", &envTestDecoder{Value: "decoded:x"}},
+		{"Setter", "a|b", envTestSetter{"a", "b"}},
+	} {
+		t.Run(tc.field, func(t *testing.T) {
+			var cfg envTestConfig
+			field := reflect.ValueOf(&cfg).Elem().FieldByName(tc.field)
+			if err := setFieldValue(tc.value, field); err != nil {
+				t.Fatalf("setFieldValue(%q) = %v", tc.value, err)
+			}
+			if got := field.Interface(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("setFieldValue(%q) = %#v, want %#v", tc.value, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSetFieldValueURL(t *testing.T) {
+	var cfg envTestConfig
+	// nil указатель создается до разбора, а не разбирается на nil
+	if err := setFieldValue("https://example.com/v1", reflect.ValueOf(&cfg).Elem().FieldByName("URL")); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.URL == nil || cfg.URL.String() != "https://example.com/v1" {
+		t.Errorf("URL = %v, want https://example.com/v1", cfg.URL)
+	}
+}
+
+func TestSetFieldValueErrors(t *testing.T) {
+	for _, tc := range []struct {
+		field string
+		value string
+		err   string
+	}{
+		{"Int", "abc", "invalid syntax"},
+		{"Int8", "300", "out of range"},
+		{"Uint", "-1", "invalid syntax"},
+		{"Bool", "maybe", "invalid syntax"},
+		{"Duration", "10", "missing unit"},
+		{"Ints", "1,x", "invalid syntax"},
+		{"Map", "a=1", `invalid map item: "a=1"`},
+		{"Map", "a:x", "invalid syntax"},
+		{"Size", "10 parsecs", "unknown size unit"},
+		{"Chan", "1", "unsupported type chan int"},
+		{"Struct", "1", "unsupported type struct"},
+	} {
+		t.Run(tc.field+"="+tc.value, func(t *testing.T) {
+			var cfg envTestConfig
+			err := setFieldValue(tc.value, reflect.ValueOf(&cfg).Elem().FieldByName(tc.field))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("setFieldValue(%q) = %v, want %q", tc.value, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestIsScalar(t *testing.T) {
+	var cfg envTestConfig
+	v := reflect.ValueOf(&cfg).Elem()
+	for field, want := range map[string]bool{
+		"Size":    true,
+		"URL":     true,
+		"Decoder": true,
+		"DecPtr":  true,
+		"Setter":  true,
+		"Struct":  false,
+		"Int":     false,
+	} {
+		if got := isScalar(v.FieldByName(field)); got != want {
+			t.Errorf("isScalar(%s) = %v, want %v", field, got, want)
+		}
+	}
+}
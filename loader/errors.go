@@ -0,0 +1,293 @@
+package loader
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/dig"
+	"go.uber.org/multierr"
+)
+
+// ErrBadConfig означает ошибку в конфиге.
+// Возвращать ошибку должен сервис или резолвер fx, который проверяет семантическую корректность значений.
+// Если известно, какие поля конфига плохие, они перечисляются в Fields, см. BadField.
+type ErrBadConfig struct {
+	Cause  error
+	Fields []FieldError
+}
+
+func (e ErrBadConfig) Error() string {
+	var msgs []string
+	if e.Cause != nil {
+		msgs = append(msgs, e.Cause.Error())
+	}
+	if len(e.Fields) > 0 {
+		msgs = append(msgs, ValidationErrors(e.Fields).Error())
+	}
+	return "bad config: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap возвращает Cause, чтобы errors.Is и errors.As проверяли и исходную ошибку
+func (e ErrBadConfig) Unwrap() error {
+	return e.Cause
+}
+
+// Is - errors.Is(err, ErrBadConfig{}) проверяет, что err - ошибка конфига, с любой причиной и полями
+func (e ErrBadConfig) Is(target error) bool {
+	switch t := target.(type) {
+	case ErrBadConfig:
+		return t.Cause == nil && len(t.Fields) == 0
+	case *ErrBadConfig:
+		return t != nil && t.Cause == nil && len(t.Fields) == 0
+	}
+	return false
+}
+
+// As находит ошибку конфига одинаково, вернули ее значением или указателем:
+// подходят и var badErr ErrBadConfig, и var badErr *ErrBadConfig
+func (e ErrBadConfig) As(target interface{}) bool {
+	switch t := target.(type) {
+	case *ErrBadConfig:
+		*t = e
+		return true
+	case **ErrBadConfig:
+		badErr := e
+		*t = &badErr
+		return true
+	}
+	return false
+}
+
+// классы ошибок, которые возвращают New, Reload и Rollback, чтобы не разбирать их текст.
+// Проверяются через errors.Is, исходная ошибка, например ErrBadConfig, остается в цепочке
+var (
+	// сохраненного рабочего конфига нет или его не удалось прочитать из хранилища
+	ErrFallbackUnavailable = errors.New("fallback config is unavailable")
+	// приложение уже работает на сохраненном конфиге и откатываться дальше не на что
+	ErrFallbackAlreadyApplied = errors.New("fallback config is already applied")
+	// откат выключен: LOADER_IGNORE_FALLBACK_CONFIG или строгий режим
+	ErrFallbackIgnored = errors.New("fallback config is ignored")
+	// конфиг не удалось прочитать из источника или разобрать
+	ErrConfigParse = errors.New("failed to parse config")
+	// fx граф приложения не собрался по причине, не связанной с конфигом
+	ErrGraphBuild = errors.New("failed to build app graph")
+)
+
+// classError относит err к классу class, не меняя ее текст
+type classError struct {
+	class error
+	err   error
+}
+
+func withClass(class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return classError{class: class, err: err}
+}
+
+func (e classError) Error() string {
+	return e.err.Error()
+}
+
+func (e classError) Is(target error) bool {
+	return target == e.class
+}
+
+func (e classError) Unwrap() error {
+	return e.err
+}
+
+func (e classError) Cause() error {
+	return e.err
+}
+
+// коды ошибок в полях конфига
+const (
+	// значение не задано
+	CodeRequired = "required"
+	// значение вне допустимого диапазона
+	CodeOutOfRange = "out_of_range"
+	// значение не из списка допустимых
+	CodeNotAllowed = "not_allowed"
+	// значение не соответствует формату
+	CodeInvalidFormat = "invalid_format"
+	// значение не удалось разобрать
+	CodeParse = "parse_error"
+	// значение не удалось получить из внешнего хранилища
+	CodeUnresolved = "unresolved"
+	// прочие ошибки значения
+	CodeInvalid = "invalid"
+	// переменная не соответствует ни одному полю конфига, см. LOADER_UNKNOWN_ENV
+	CodeUnknown = "unknown"
+	// слои LayeredSource задали полю разные значения, а тег merge или LOADER_MERGE_CONFLICT это запрещает
+	CodeConflict = "conflict"
+)
+
+// BadField возвращает ErrBadConfig для одного поля конфига field со значением value
+func BadField(field string, value interface{}, reason string) ErrBadConfig {
+	return BadFieldCode(field, value, CodeInvalid, reason)
+}
+
+// BadFieldCode - то же, что BadField, но с кодом ошибки code
+func BadFieldCode(field string, value interface{}, code, reason string) ErrBadConfig {
+	return ErrBadConfig{Fields: []FieldError{{Field: field, Value: value, Code: code, Reason: reason}}}
+}
+
+func unwrapBadConfigError(err error) (error, bool) {
+	if errors.As(err, new(ErrBadConfig)) {
+		return err, true
+	}
+	// fx врапает ошибки из резолверов в свои структуры, нужно получить исходную ошибку
+	if badErr, ok := findBadConfig(err); ok {
+		return badErr, true
+	}
+	return err, false
+}
+
+// findBadConfig ищет ErrBadConfig во всем дереве err: в цепочке Unwrap, под ошибками dig,
+// которые errors.As не разворачивает, и в каждой из ошибок multierr
+func findBadConfig(err error) (ErrBadConfig, bool) {
+	var badErr ErrBadConfig
+	if err == nil {
+		return badErr, false
+	}
+	if errors.As(err, &badErr) {
+		return badErr, true
+	}
+	// RootCause возвращает саму err, если это не ошибка dig. Сравниваются типы, а не ошибки:
+	// == на ошибке, внутри которой ErrBadConfig со слайсом Fields, паникует
+	if root := dig.RootCause(err); reflect.TypeOf(root) != reflect.TypeOf(err) {
+		if badErr, ok := findBadConfig(root); ok {
+			return badErr, true
+		}
+	}
+	if errs := multierr.Errors(err); len(errs) > 1 {
+		for _, e := range errs {
+			if badErr, ok := findBadConfig(e); ok {
+				return badErr, true
+			}
+		}
+	}
+	// на пути errors.As могла быть ошибка dig или multierr, под которую он не заглянул
+	return findBadConfig(errors.Unwrap(err))
+}
+
+// что считать ошибкой конфига, если под ошибкой сборки или старта несколько исходных ошибок,
+// например собранных через multierr (LOADER_BAD_CONFIG_MATCH)
+const (
+	// хотя бы одна исходная ошибка - ошибка конфига
+	BadConfigMatchAny = "any"
+	// все исходные ошибки - ошибки конфига, иначе откат не поможет и ошибка возвращается как есть
+	BadConfigMatchAll = "all"
+)
+
+func (l *AppLoader) initBadConfigMatch() error {
+	switch l.cfg.BadConfigMatch {
+	case "":
+		l.cfg.BadConfigMatch = BadConfigMatchAny
+	case BadConfigMatchAny, BadConfigMatchAll:
+	default:
+		return errors.Errorf("unknown bad config match %q", l.cfg.BadConfigMatch)
+	}
+	return nil
+}
+
+// classifyBadConfig проверяет каждую исходную ошибку из дерева err, см. errorLeaves: ошибка конфига ли она
+// или ее так классифицировал хук WithBadConfigClassifier. Если ошибки конфига есть, а с LOADER_BAD_CONFIG_MATCH=all
+// ими должны быть все, возвращает err, в которой ErrBadConfig не найдут.
+// Хуки получают и исходные ошибки, и, если исходная ошибка одна, саму ошибку: ошибки dig не разворачиваются
+// через errors.As
+func (l *AppLoader) classifyBadConfig(err error) error {
+	if err == nil || errors.As(err, new(mixedConfigError)) {
+		return err
+	}
+	leaves := errorLeaves(err)
+	bad := 0
+	for _, leaf := range leaves {
+		if l.isBadConfig(leaf) || (len(leaves) == 1 && l.isBadConfig(err)) {
+			bad++
+		}
+	}
+	switch {
+	case bad == 0:
+		return err
+	case bad < len(leaves) && l.cfg.BadConfigMatch == BadConfigMatchAll:
+		return mixedConfigError{err: err}
+	}
+	if _, ok := findBadConfig(err); ok {
+		return err
+	}
+	return ErrBadConfig{Cause: err}
+}
+
+// isBadConfig - err содержит ErrBadConfig или ее так классифицировал хук WithBadConfigClassifier
+func (l *AppLoader) isBadConfig(err error) bool {
+	if _, ok := findBadConfig(err); ok {
+		return true
+	}
+	for _, classify := range l.classifiers {
+		if classify(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorLeaves возвращает исходные ошибки из дерева err: ошибки multierr и исходные ошибки dig
+// по цепочке Unwrap. Ветка без них - одна исходная ошибка, ErrBadConfig дальше не разворачивается
+func errorLeaves(err error) []error {
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		switch e.(type) {
+		case ErrBadConfig, *ErrBadConfig:
+			return []error{err}
+		}
+		if errs := multierr.Errors(e); len(errs) > 1 {
+			var leaves []error
+			for _, e := range errs {
+				leaves = append(leaves, errorLeaves(e)...)
+			}
+			return leaves
+		}
+		if root := dig.RootCause(e); reflect.TypeOf(root) != reflect.TypeOf(e) {
+			return errorLeaves(root)
+		}
+	}
+	return []error{err}
+}
+
+// mixedConfigError - ошибка, в которой ошибки конфига смешаны с другими, когда LOADER_BAD_CONFIG_MATCH=all.
+// Откатываться на ней не нужно, поэтому ErrBadConfig под ней не находят ни errors.Is, ни errors.As,
+// остальные ошибки проверяются как обычно
+type mixedConfigError struct {
+	err error
+}
+
+func (e mixedConfigError) Error() string {
+	return e.err.Error()
+}
+
+func (e mixedConfigError) Is(target error) bool {
+	switch target.(type) {
+	case ErrBadConfig, *ErrBadConfig:
+		return false
+	}
+	return errors.Is(e.err, target)
+}
+
+func (e mixedConfigError) As(target interface{}) bool {
+	switch target.(type) {
+	case *ErrBadConfig, **ErrBadConfig:
+		return false
+	}
+	return errors.As(e.err, target)
+}
+
+// badConfigFields возвращает плохие поля из ошибки конфига, если они известны
+func badConfigFields(err error) []FieldError {
+	if badErr, ok := findBadConfig(err); ok {
+		return badErr.Fields
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package loader
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+	"go.uber.org/multierr"
+)
+
+var errInfra = errors.New("connection refused")
+
+func badPort() ErrBadConfig {
+	return BadFieldCode("port", 0, CodeRequired, "is required")
+}
+
+// fxError возвращает ошибку, которой fx.New оборачивает ошибку err из конструктора
+func fxError(t *testing.T, err error) error {
+	t.Helper()
+	app := fx.New(
+		fx.NopLogger,
+		fx.Provide(func() (int, error) { return 0, err }),
+		fx.Invoke(func(int) {}),
+	)
+	if app.Err() == nil {
+		t.Fatal("fx.New succeeded with failing constructor")
+	}
+	return app.Err()
+}
+
+func TestFindBadConfig(t *testing.T) {
+	bad := badPort()
+	for _, tc := range []struct {
+		name string
+		err  func(t *testing.T) error
+		bad  bool
+	}{
+		{"value", func(*testing.T) error { return bad }, true},
+		{"pointer", func(*testing.T) error { return &bad }, true},
+		{"wrapped value", func(*testing.T) error { return errors.Wrap(bad, "failed to load") }, true},
+		{"wrapped pointer", func(*testing.T) error { return errors.Wrap(&bad, "failed to load") }, true},
+		{"fmt wrapped pointer", func(*testing.T) error { return fmt.Errorf("load: %w", &bad) }, true},
+		{"fx value", func(t *testing.T) error { return fxError(t, bad) }, true},
+		{"fx pointer", func(t *testing.T) error { return fxError(t, &bad) }, true},
+		{"fx wrapped", func(t *testing.T) error { return fxError(t, errors.Wrap(bad, "dsn")) }, true},
+		{"multierr", func(*testing.T) error { return multierr.Combine(errInfra, bad) }, true},
+		{"wrapped multierr", func(*testing.T) error {
+			return errors.Wrap(multierr.Combine(errInfra, errors.Wrap(&bad, "hook")), "start")
+		}, true},
+		{"fx multierr", func(t *testing.T) error { return fxError(t, multierr.Combine(errInfra, bad)) }, true},
+		{"multierr of fx", func(t *testing.T) error { return multierr.Combine(errInfra, fxError(t, bad)) }, true},
+		{"plain", func(*testing.T) error { return errors.Wrap(errInfra, "failed to load") }, false},
+		{"multierr without bad config", func(*testing.T) error {
+			return multierr.Combine(errInfra, errors.New("timeout"))
+		}, false},
+		{"fx without bad config", func(t *testing.T) error { return fxError(t, errInfra) }, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.err(t)
+			found, ok := findBadConfig(err)
+			if ok != tc.bad {
+				t.Fatalf("findBadConfig(%v) = %v, want %v", err, ok, tc.bad)
+			}
+			unwrapped, ok := unwrapBadConfigError(err)
+			if ok != tc.bad {
+				t.Errorf("unwrapBadConfigError(%v) = %v, want %v", err, ok, tc.bad)
+			}
+			if !tc.bad {
+				if unwrapped != err {
+					t.Errorf("unwrapBadConfigError changed error without bad config: %v", unwrapped)
+				}
+				return
+			}
+			if len(found.Fields) != 1 || found.Fields[0].Field != "port" {
+				t.Errorf("findBadConfig fields = %v, want port", found.Fields)
+			}
+			if fields := badConfigFields(err); len(fields) != 1 || fields[0].Code != CodeRequired {
+				t.Errorf("badConfigFields = %v, want port required", fields)
+			}
+			if _, ok := findBadConfig(unwrapped); !ok {
+				t.Errorf("unwrapBadConfigError result %v has no bad config", unwrapped)
+			}
+		})
+	}
+}
+
+func TestBadConfigIsAs(t *testing.T) {
+	bad := badPort()
+	for name, err := range map[string]error{
+		"value":           errors.Wrap(bad, "failed to load"),
+		"pointer":         errors.Wrap(&bad, "failed to load"),
+		"fmt wrapped":     fmt.Errorf("load: %w", bad),
+		"with cause":      ErrBadConfig{Cause: errInfra},
+		"pointer w/cause": &ErrBadConfig{Cause: errInfra},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if !errors.Is(err, ErrBadConfig{}) || !errors.Is(err, &ErrBadConfig{}) {
+				t.Error("errors.Is does not find ErrBadConfig")
+			}
+			var value ErrBadConfig
+			var pointer *ErrBadConfig
+			if !errors.As(err, &value) || !errors.As(err, &pointer) {
+				t.Fatal("errors.As does not find ErrBadConfig")
+			}
+			if value.Error() != pointer.Error() {
+				t.Errorf("value %q and pointer %q differ", value, pointer)
+			}
+		})
+	}
+	if !errors.Is(ErrBadConfig{Cause: errInfra}, errInfra) {
+		t.Error("errors.Is does not find Cause of ErrBadConfig")
+	}
+	if errors.Is(errInfra, ErrBadConfig{}) {
+		t.Error("errors.Is finds ErrBadConfig in plain error")
+	}
+}
+
+func TestClassifyBadConfig(t *testing.T) {
+	bad := badPort()
+	classified := errors.New("dsn: invalid scheme")
+	for _, tc := range []struct {
+		name  string
+		err   error
+		match string
+		bad   bool
+	}{
+		{"single bad, any", errors.Wrap(bad, "start"), BadConfigMatchAny, true},
+		{"single bad, all", errors.Wrap(bad, "start"), BadConfigMatchAll, true},
+		{"mixed, any", multierr.Combine(errInfra, bad), BadConfigMatchAny, true},
+		{"mixed, all", multierr.Combine(errInfra, bad), BadConfigMatchAll, false},
+		{"wrapped mixed, all", errors.Wrap(multierr.Combine(bad, errors.Wrap(errInfra, "db")), "start"), BadConfigMatchAll, false},
+		{"all bad, all", multierr.Combine(bad, errors.Wrap(&bad, "hook")), BadConfigMatchAll, true},
+		{"classified and bad, all", multierr.Combine(classified, bad), BadConfigMatchAll, true},
+		{"classified and infra, any", multierr.Combine(classified, errInfra), BadConfigMatchAny, true},
+		{"classified and infra, all", multierr.Combine(classified, errInfra), BadConfigMatchAll, false},
+		{"no bad, any", multierr.Combine(errInfra, errors.New("timeout")), BadConfigMatchAny, false},
+		{"no bad, all", errInfra, BadConfigMatchAll, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &AppLoader{
+				cfg:         &Config{LoaderConfig: LoaderConfig{BadConfigMatch: tc.match}},
+				classifiers: []func(error) bool{func(err error) bool { return err == classified }},
+			}
+			err := l.classifyBadConfig(tc.err)
+			if _, ok := findBadConfig(err); ok != tc.bad {
+				t.Errorf("classifyBadConfig(%v) is bad config: %v, want %v", tc.err, ok, tc.bad)
+			}
+			if errors.Is(err, ErrBadConfig{}) != tc.bad {
+				t.Errorf("errors.Is(classifyBadConfig(%v), ErrBadConfig{}) != %v", tc.err, tc.bad)
+			}
+			if err.Error() != tc.err.Error() && !tc.bad {
+				t.Errorf("classifyBadConfig changed message: %q", err)
+			}
+			// остальные ошибки под смешанной ошибкой находятся как обычно
+			if errors.Is(tc.err, errInfra) && !errors.Is(err, errInfra) {
+				t.Errorf("classifyBadConfig hides %v", errInfra)
+			}
+		})
+	}
+}
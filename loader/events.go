@@ -0,0 +1,65 @@
+package loader
+
+import (
+	"time"
+)
+
+// Events получает события загрузчика, например чтобы отправить их в свою телеметрию
+// без привязки загрузчика к конкретному стеку метрик.
+// Методы вызываются синхронно, так что долгую работу в них лучше не делать.
+// Конфиг приложения в cfg общий с загрузчиком, менять его нельзя.
+// NopEvents можно встроить в свою структуру, чтобы реализовать только нужные методы.
+type Events interface {
+	// конфиг прочитан из источника source, err - ошибка чтения
+	OnConfigLoaded(source string, err error)
+	// приложение работает на сохраненном или предыдущем конфиге cfg, причина - cfg.ConfigError
+	OnFallbackApplied(cfg Config)
+	// закончилась проверка конфига cfg и сборка с ним приложения, err - ошибка проверки или сборки
+	OnAppBuilt(cfg Config, duration time.Duration, err error)
+	// собранное с конфигом cfg приложение не стартовало
+	OnAppStartFailed(cfg Config, err error)
+	// конфиг сохранен как рабочий, err - ошибка записи в хранилище
+	OnConfigSaved(err error)
+}
+
+// NopEvents игнорирует все события
+type NopEvents struct{}
+
+func (NopEvents) OnConfigLoaded(string, error)            {}
+func (NopEvents) OnFallbackApplied(Config)                {}
+func (NopEvents) OnAppBuilt(Config, time.Duration, error) {}
+func (NopEvents) OnAppStartFailed(Config, error)          {}
+func (NopEvents) OnConfigSaved(error)                     {}
+
+// events рассылает события всем обработчикам из WithEvents
+type events []Events
+
+func (e events) OnConfigLoaded(source string, err error) {
+	for _, h := range e {
+		h.OnConfigLoaded(source, err)
+	}
+}
+
+func (e events) OnFallbackApplied(cfg Config) {
+	for _, h := range e {
+		h.OnFallbackApplied(cfg)
+	}
+}
+
+func (e events) OnAppBuilt(cfg Config, duration time.Duration, err error) {
+	for _, h := range e {
+		h.OnAppBuilt(cfg, duration, err)
+	}
+}
+
+func (e events) OnAppStartFailed(cfg Config, err error) {
+	for _, h := range e {
+		h.OnAppStartFailed(cfg, err)
+	}
+}
+
+func (e events) OnConfigSaved(err error) {
+	for _, h := range e {
+		h.OnConfigSaved(err)
+	}
+}
@@ -0,0 +1,180 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// форматы примера конфига для WriteExample
+const (
+	ExampleFormatEnv  = "env"
+	ExampleFormatYAML = "yaml"
+)
+
+// WriteExample пишет в w пример конфига приложения в формате .env или yaml:
+// все настройки со значениями по умолчанию (или пустыми) и комментариями из тегов
+func (l *AppLoader) WriteExample(w io.Writer, format string) error {
+	return WriteExample(w, l.prefix, l.cfg.App, format)
+}
+
+// WriteExample пишет в w пример конфига cfg с префиксом env prefix, см. AppLoader.WriteExample
+func WriteExample(w io.Writer, prefix string, cfg interface{}, format string) error {
+	docs, err := Describe(prefix, cfg)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case ExampleFormatEnv:
+		return writeEnvExample(w, docs)
+	case ExampleFormatYAML:
+		return writeYAMLExample(w, docs)
+	default:
+		return errors.Errorf("unknown example format %q", format)
+	}
+}
+
+func writeEnvExample(w io.Writer, docs []FieldDoc) error {
+	for i, d := range docs {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if c := exampleComment(d); c != "" {
+			fmt.Fprintf(w, "# %s\n", c)
+		}
+		value := d.Default
+		if value == "" && !d.Secret {
+			value = exampleScalar(d.typ)
+		}
+		if strings.ContainsAny(value, " #\"'") {
+			value = fmt.Sprintf("%q", value)
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", d.Env, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeYAMLExample(w io.Writer, docs []FieldDoc) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, d := range docs {
+		if d.Field == "" {
+			continue
+		}
+		parts := strings.Split(d.Field, ".")
+		node := root
+		for _, key := range parts[:len(parts)-1] {
+			node = yamlChild(node, key)
+		}
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: parts[len(parts)-1], HeadComment: exampleComment(d)}
+		node.Content = append(node.Content, key, yamlExampleValue(d))
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// yamlChild возвращает вложенный mapping с ключом key, создавая его при необходимости
+func yamlChild(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, child)
+	return child
+}
+
+func yamlExampleValue(d FieldDoc) *yaml.Node {
+	t := d.typ
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t != nil && (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) {
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		if d.Default != "" {
+			for _, v := range strings.Split(d.Default, ",") {
+				seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: v})
+			}
+		}
+		return seq
+	}
+	if t != nil && t.Kind() == reflect.Map {
+		m := &yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle}
+		if d.Default != "" {
+			for _, pair := range strings.Split(d.Default, ",") {
+				kv := strings.SplitN(pair, ":", 2)
+				if len(kv) == 2 {
+					m.Content = append(m.Content,
+						&yaml.Node{Kind: yaml.ScalarNode, Value: kv[0]},
+						&yaml.Node{Kind: yaml.ScalarNode, Value: kv[1]})
+				}
+			}
+		}
+		return m
+	}
+
+	value := d.Default
+	if value == "" && !d.Secret {
+		value = exampleScalar(d.typ)
+	}
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	if t == nil || t.Kind() == reflect.String || value == "" {
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	return node
+}
+
+// exampleScalar - значение для настройки без значения по умолчанию
+func exampleScalar(t reflect.Type) string {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil {
+		return ""
+	}
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return "0s"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "false"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "0"
+	}
+	return ""
+}
+
+// exampleComment собирает комментарий к настройке из описания, типа и проверок
+func exampleComment(d FieldDoc) string {
+	var parts []string
+	if d.Desc != "" {
+		parts = append(parts, d.Desc)
+	}
+	notes := []string{d.Type}
+	if d.Required {
+		notes = append(notes, "required")
+	}
+	for _, rule := range strings.Split(d.Validate, ",") {
+		if rule = strings.TrimSpace(rule); rule != "" && rule != "required" {
+			notes = append(notes, rule)
+		}
+	}
+	if d.Secret {
+		notes = append(notes, "secret")
+	}
+	parts = append(parts, "("+strings.Join(notes, ", ")+")")
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,110 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExecSource загружает конфиг из вывода команды, например помощника секретов
+// (`chamber export --format json app`, `berglas access ...`). Команда запускается при каждой загрузке
+// без shell. Вывод, который начинается с {, разбирается как json так же, как в FileSource,
+// иначе - как .env файл: строки KEY=value с именами переменных конфига под префиксом prefix.
+//
+// Ненулевой код выхода, превышение timeout и неразобранный вывод возвращаются как ErrBadConfig,
+// и загрузчик откатывается на последний рабочий конфиг. Если команду не удалось запустить
+// (например, ее нет в PATH) или загрузку отменили, возвращается обычная ошибка.
+type ExecSource struct {
+	prefix  string
+	name    string
+	args    []string
+	timeout time.Duration
+	naming  EnvNaming
+}
+
+// NewExecSource создает источник, который запускает name с аргументами args не дольше timeout.
+// С нулевым timeout команду ограничивает только ctx загрузки, см. LOADER_LOAD_TIMEOUT
+func NewExecSource(prefix string, timeout time.Duration, name string, args ...string) *ExecSource {
+	return &ExecSource{prefix: prefix, name: name, args: args, timeout: timeout}
+}
+
+func (s *ExecSource) String() string {
+	return "exec " + s.name
+}
+
+func (s *ExecSource) Load(cfg interface{}) error {
+	return s.LoadContext(context.Background(), cfg)
+}
+
+// LoadContext прерывает команду, когда отменен ctx
+func (s *ExecSource) LoadContext(ctx context.Context, cfg interface{}) error {
+	return s.load(ctx, cfg, true)
+}
+
+func (s *ExecSource) loadLayerContext(ctx context.Context, cfg interface{}) error {
+	return s.load(ctx, cfg, false)
+}
+
+func (s *ExecSource) load(ctx context.Context, cfg interface{}, withDefaults bool) error {
+	out, err := s.run(ctx)
+	if err != nil {
+		return err
+	}
+	out = bytes.TrimSpace(out)
+	if bytes.HasPrefix(out, []byte("{")) {
+		values, err := parseConfigFile(out, FileFormatJSON)
+		if err != nil {
+			return ErrBadConfig{Cause: errors.Wrapf(err, "failed to parse output of %s", s.name)}
+		}
+		v := reflect.ValueOf(cfg)
+		if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+			return errors.New("config must be a struct pointer")
+		}
+		return decodeValues(values, v.Elem(), "")
+	}
+	vars, err := parseEnvFile(out)
+	if err != nil {
+		// в выводе могут быть секреты, поэтому без текста ошибки разбора
+		return ErrBadConfig{Cause: errors.Errorf("output of %s is neither json nor KEY=value lines", s.name)}
+	}
+	return loadEnv(s.prefix, cfg, func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}, s.naming, withDefaults)
+}
+
+// run запускает команду и различает ошибки конфига и инфраструктуры
+func (s *ExecSource) run(ctx context.Context) ([]byte, error) {
+	runCtx := ctx
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	out, err := runCommand(runCtx, s.name, s.args...)
+	var exitErr *commandError
+	switch {
+	case err == nil:
+		return out, nil
+	case ctx.Err() != nil:
+		return nil, errors.Wrap(ctx.Err(), "failed to load config from command")
+	case runCtx.Err() != nil:
+		return nil, ErrBadConfig{Cause: errors.Errorf("%s timed out after %s", s.name, s.timeout)}
+	case errors.As(err, &exitErr):
+		return nil, ErrBadConfig{Cause: errors.Wrap(err, "failed to load config from command")}
+	}
+	return nil, errors.Wrap(err, "failed to load config from command")
+}
+
+// parseCommandLine разбивает LOADER_CONFIG_EXEC на имя команды и аргументы по пробелам, без кавычек shell
+func parseCommandLine(line string) (string, []string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], fields[1:]
+}
@@ -0,0 +1,114 @@
+package loader
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// коды выхода процесса, которые возвращают Run и AppLoader.RunCode
+const (
+	// приложение остановлено сигналом или Stop и работало на новом конфиге
+	ExitOK = 0
+	// приложение не удалось остановить или загрузчик настроен неправильно
+	ExitError = 1
+	// не нашлось ни одного рабочего конфига: новый плохой, а откатиться не на что
+	ExitBadConfig = 2
+	// приложение собралось, но не запустилось
+	ExitStartFailed = 3
+	// приложение остановлено штатно, но работало на сохраненном конфиге, новый был плохим
+	ExitFallback = 4
+)
+
+// createError - ошибка сборки приложения в New, после нее откатиться было не на что
+type createError struct {
+	error
+}
+
+func (e createError) Unwrap() error {
+	return e.error
+}
+
+func (e createError) Cause() error {
+	return e.error
+}
+
+// Run создает загрузчик с опциями opts, запускает приложение и останавливает его по сигналу.
+// Ошибки пишутся в лог, а результат возвращается кодом выхода, так что main сводится к
+//
+//	os.Exit(loader.Run(loader.WithApp(ProvideApp()), loader.WithAppConfig(new(SomeAppConfig))))
+func Run(opts ...Option) int {
+	l, err := New(opts...)
+	if err != nil {
+		code := ExitError
+		if errors.As(err, new(createError)) {
+			code = ExitBadConfig
+		}
+		optionsLogger(opts).Error("failed to create app", "error", err, "exit_code", code)
+		return code
+	}
+	return l.RunCode()
+}
+
+// RunCode работает как Run, но вместо ошибки возвращает код выхода процесса, см. ExitOK и остальные
+func (l *AppLoader) RunCode() int {
+	startErr, stopErr := l.run()
+	code := l.exitCode(startErr, stopErr)
+	switch {
+	case startErr != nil:
+		l.log.Error("app failed", "error", startErr, "exit_code", code)
+	case stopErr != nil:
+		l.log.Error("failed to stop app", "error", stopErr, "exit_code", code)
+	default:
+		l.log.Info("app stopped", "exit_code", code)
+	}
+	return code
+}
+
+func (l *AppLoader) exitCode(startErr, stopErr error) int {
+	if startErr != nil {
+		// без приложения Start вернулся, так и не дождавшись рабочего конфига
+		if _, bad := unwrapBadConfigError(l.classifyBadConfig(startErr)); bad || l.currentApp() == nil {
+			return ExitBadConfig
+		}
+		return ExitStartFailed
+	}
+	if stopErr != nil {
+		return ExitError
+	}
+	if l.Config().UsesFallbackConfig {
+		return ExitFallback
+	}
+	return ExitOK
+}
+
+// run запускает приложение, ждет остановки и останавливает его, ошибки запуска и остановки возвращает отдельно
+func (l *AppLoader) run() (startErr, stopErr error) {
+	startCtx, cancel := context.WithTimeout(context.Background(), l.Config().StartTimeout)
+	defer cancel()
+
+	startErr = l.Start(startCtx)
+	if startErr == nil {
+		l.confirmStop()
+	}
+
+	// дожидаемся перезагрузки, которая могла начаться до сигнала, и останавливаем то, что в итоге работает.
+	// Если рабочий конфиг так и не появился или приложение уже остановил Stop, останавливать нечего
+	stopCtx, cancel := context.WithTimeout(context.Background(), l.Config().StopTimeout)
+	defer cancel()
+	stopErr = l.stopCurrent(stopCtx)
+	l.waitNotifications(stopCtx)
+	return startErr, stopErr
+}
+
+// optionsLogger возвращает логгер из WithLogger, когда сам загрузчик создать не удалось
+func optionsLogger(opts []Option) Logger {
+	l := AppLoader{cfg: &Config{}}
+	for _, opt := range opts {
+		opt(&l)
+	}
+	if l.log == nil {
+		return defaultLogger()
+	}
+	return l.log
+}
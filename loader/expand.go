@@ -0,0 +1,118 @@
+package loader
+
+import (
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// expandEnv раскрывает ${VAR} и ${VAR:-default} в строковых полях appCfg, см. LOADER_EXPAND_ENV.
+// Значения берутся из окружения процесса, $${ оставляет ${ как есть
+func (l *AppLoader) expandEnv(appCfg interface{}) error {
+	if !l.cfg.ExpandEnv {
+		return nil
+	}
+	var errs ValidationErrors
+	walkFields(appCfg, func(f configField) {
+		if err := expandValue(f.Value); err != nil {
+			code := CodeParse
+			if errors.Is(err, errUnsetVar) {
+				code = CodeUnresolved
+			}
+			errs = append(errs, FieldError{Field: f.Path, Code: code, Reason: err.Error()})
+		}
+	})
+	if len(errs) > 0 {
+		return ErrBadConfig{Fields: errs}
+	}
+	return nil
+}
+
+var errUnsetVar = errors.New("variable is not set")
+
+// expandValue раскрывает ссылки в строке, указателе на строку, срезе или мапе строк
+func expandValue(v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.String:
+		s, err := expandString(v.String(), os.LookupEnv)
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			s, err := expandString(v.Index(i).String(), os.LookupEnv)
+			if err != nil {
+				return err
+			}
+			v.Index(i).SetString(s)
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			s, err := expandString(iter.Value().String(), os.LookupEnv)
+			if err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), reflect.ValueOf(s).Convert(v.Type().Elem()))
+		}
+	}
+	return nil
+}
+
+// expandString заменяет ${VAR} значением переменной, а ${VAR:-default} - значением или default,
+// если переменная не задана или пустая. Незаданная переменная без default - ошибка, значение
+// в нее не попадает
+func expandString(s string, lookup func(string) (string, bool)) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", errors.New("unterminated ${ reference")
+		}
+		ref := s[i+2 : i+end]
+		s = s[i+end+1:]
+
+		name, def, hasDefault := strings.Cut(ref, ":-")
+		if name == "" {
+			return "", errors.New("empty ${} reference")
+		}
+		value, ok := lookup(name)
+		switch {
+		case value != "":
+		case hasDefault:
+			value = def
+		case !ok:
+			return "", errors.Wrap(errUnsetVar, name)
+		}
+		b.WriteString(value)
+	}
+}
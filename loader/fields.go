@@ -0,0 +1,74 @@
+package loader
+
+import (
+	"reflect"
+	"strings"
+)
+
+// configField - поле конфига, найденное при обходе структуры
+type configField struct {
+	// путь к полю от корня конфига, например server.port
+	Path  string
+	Field reflect.StructField
+	Value reflect.Value
+}
+
+// walkFields обходит все экспортируемые поля конфига, спускаясь во вложенные структуры.
+// fn вызывается и для самих вложенных структур, и для их полей.
+func walkFields(cfg interface{}, fn func(f configField)) {
+	v := reflect.ValueOf(cfg)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	walkStruct(v, "", fn)
+}
+
+func walkStruct(v reflect.Value, prefix string, fn func(f configField)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		fv := v.Field(i)
+
+		// поля встроенных структур без тегов считаются полями родителя
+		path := prefix
+		if !sf.Anonymous || sf.Tag != "" {
+			path = joinPath(prefix, fieldName(sf))
+			fn(configField{Path: path, Field: sf, Value: fv})
+		}
+
+		for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+			fv = fv.Elem()
+		}
+		if fv.Kind() == reflect.Struct && !isScalar(fv) {
+			walkStruct(fv, path, fn)
+		}
+	}
+}
+
+// fieldName возвращает имя поля так, как оно видно в сохраненном конфиге:
+// из тега json, затем envconfig, иначе имя поля в нижнем регистре
+func fieldName(sf reflect.StructField) string {
+	if name := strings.Split(sf.Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+		return name
+	}
+	if name := sf.Tag.Get("envconfig"); name != "" {
+		return name
+	}
+	return strings.ToLower(sf.Name)
+}
+
+func joinPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
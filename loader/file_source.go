@@ -0,0 +1,220 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// FileSource загружает конфиг из yaml, json или toml файла.
+// Ключи сопоставляются с полями так же, как в сохраненном конфиге: по тегу json,
+// затем envconfig, затем по имени поля без учета регистра. Значения разбираются
+// по тем же правилам, что и переменные окружения, так что длительности можно писать как 10s.
+// Поля, которых нет в файле, не трогаются. Файлы, зашифрованные sops, расшифровываются, см. SOPSSource.
+type FileSource struct {
+	path   string
+	format string
+	// файла может не быть, как у файла профиля LOADER_PROFILE
+	optional bool
+}
+
+const (
+	FileFormatYAML = "yaml"
+	FileFormatJSON = "json"
+	FileFormatTOML = "toml"
+)
+
+// NewFileSource создает источник, формат которого определяется по расширению файла
+func NewFileSource(path string) *FileSource {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if format == "yml" {
+		format = FileFormatYAML
+	}
+	return NewFileSourceFormat(path, format)
+}
+
+// NewFileSourceFormat создает источник с явно заданным форматом
+func NewFileSourceFormat(path, format string) *FileSource {
+	return &FileSource{path: path, format: format}
+}
+
+func (s *FileSource) String() string {
+	return "file " + s.path
+}
+
+func (s *FileSource) Load(cfg interface{}) error {
+	return s.LoadContext(context.Background(), cfg)
+}
+
+// LoadContext прерывает расшифровку файла sops, когда отменен ctx
+func (s *FileSource) LoadContext(ctx context.Context, cfg interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.path)
+	if s.optional && os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return ErrBadConfig{Cause: errors.Wrap(err, "failed to read config file")}
+	}
+	values, err := parseConfigFile(data, s.format)
+	if err != nil {
+		return ErrBadConfig{Cause: errors.Wrapf(err, "failed to parse config file %s", s.path)}
+	}
+	// файл зашифрован sops, см. SOPSSource
+	if isSOPSFile(values) {
+		if values, err = decryptSOPS(ctx, s.path, s.format); err != nil {
+			return err
+		}
+	}
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("config must be a struct pointer")
+	}
+	return decodeValues(values, v.Elem(), "")
+}
+
+func parseConfigFile(data []byte, format string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	switch format {
+	case FileFormatYAML:
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+	case FileFormatJSON:
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&values); err != nil {
+			return nil, err
+		}
+	case FileFormatTOML:
+		if err := toml.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("unknown config file format %q", format)
+	}
+	return values, nil
+}
+
+// decodeValues раскладывает разобранные значения файла по полям структуры v
+func decodeValues(values map[string]interface{}, v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" || isTrue(sf.Tag.Get("ignored")) {
+			continue
+		}
+		f := v.Field(i)
+
+		// поля встроенных структур без тегов лежат на уровне родителя
+		if sf.Anonymous && sf.Tag == "" {
+			f = allocPtr(f)
+			if f.Kind() == reflect.Struct {
+				if err := decodeValues(values, f, prefix); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		value, ok := lookupKey(values, sf)
+		if !ok {
+			continue
+		}
+		path := joinPath(prefix, fieldName(sf))
+		if err := decodeValue(value, f, path); err != nil {
+			// в описании ошибки может быть само значение, поэтому для секретов оставляем только путь
+			if isSecretField(sf) {
+				return BadFieldCode(path, nil, CodeParse, "invalid value")
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeValue(value interface{}, f reflect.Value, path string) error {
+	if value == nil {
+		return nil
+	}
+	if nested, ok := value.(map[string]interface{}); ok {
+		target := allocPtr(f)
+		switch {
+		case target.Kind() == reflect.Struct && !isScalar(target):
+			return decodeValues(nested, target, path)
+		case target.Kind() == reflect.Map:
+			return decodeMap(nested, target, path)
+		}
+		return BadFieldCode(path, value, CodeParse, "unexpected table")
+	}
+	if list, ok := value.([]interface{}); ok {
+		target := allocPtr(f)
+		if target.Kind() == reflect.Slice && !isScalar(target) {
+			out := reflect.MakeSlice(target.Type(), len(list), len(list))
+			for i, item := range list {
+				if err := decodeValue(item, out.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			target.Set(out)
+			return nil
+		}
+		return BadFieldCode(path, value, CodeParse, "unexpected list")
+	}
+	if err := setFieldValue(fmt.Sprint(value), f); err != nil {
+		return BadFieldCode(path, value, CodeParse, err.Error())
+	}
+	return nil
+}
+
+func decodeMap(values map[string]interface{}, m reflect.Value, path string) error {
+	out := reflect.MakeMapWithSize(m.Type(), len(values))
+	for k, value := range values {
+		key := reflect.New(m.Type().Key()).Elem()
+		if err := setFieldValue(k, key); err != nil {
+			return BadFieldCode(path, k, CodeParse, err.Error())
+		}
+		elem := reflect.New(m.Type().Elem()).Elem()
+		if err := decodeValue(value, elem, joinPath(path, k)); err != nil {
+			return err
+		}
+		out.SetMapIndex(key, elem)
+	}
+	m.Set(out)
+	return nil
+}
+
+// ищет значение поля по имени из тегов или по имени поля без учета регистра
+func lookupKey(values map[string]interface{}, sf reflect.StructField) (interface{}, bool) {
+	if value, ok := values[fieldName(sf)]; ok {
+		return value, true
+	}
+	for k, value := range values {
+		if strings.EqualFold(k, fieldName(sf)) || strings.EqualFold(k, sf.Name) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// allocPtr создает значения для пустых указателей и возвращает то, на что они указывают
+func allocPtr(f reflect.Value) reflect.Value {
+	for f.Kind() == reflect.Ptr {
+		if f.IsNil() {
+			f.Set(reflect.New(f.Type().Elem()))
+		}
+		f = f.Elem()
+	}
+	return f
+}
@@ -0,0 +1,131 @@
+package loader
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FlagSource загружает конфиг из флагов командной строки.
+// Имена флагов строятся так же, как имена переменных окружения, только без префикса,
+// в нижнем регистре и через дефис: поле Server.Port с тегом envconfig:"port" станет --server-port.
+// Описание флага берется из тега desc, значение по умолчанию - из тега default.
+// На --help печатается список флагов и процесс завершается.
+type FlagSource struct {
+	name   string
+	args   []string
+	output io.Writer
+}
+
+// NewFlagSource создает источник, который разбирает args, обычно os.Args[1:]
+func NewFlagSource(args []string) *FlagSource {
+	return &FlagSource{name: os.Args[0], args: args, output: os.Stderr}
+}
+
+func (s *FlagSource) String() string {
+	return "flags"
+}
+
+func (s *FlagSource) Load(cfg interface{}) error {
+	if err := applyDefaults(cfg); err != nil {
+		return ErrBadConfig{Cause: err}
+	}
+	return s.load(cfg, true)
+}
+
+func (s *FlagSource) loadLayer(cfg interface{}) error {
+	return s.load(cfg, false)
+}
+
+func (s *FlagSource) load(cfg interface{}, checkRequired bool) error {
+	vars, err := gatherEnvVars("", cfg, EnvNaming{})
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet(s.name, flag.ContinueOnError)
+	fs.SetOutput(s.output)
+	values := make(map[string]*flagValue, len(vars))
+	for _, v := range vars {
+		fv := &flagValue{isBool: v.Field.Kind() == reflect.Bool}
+		name := flagName(v)
+		values[name] = fv
+		fs.Var(fv, name, v.Tags.Get("desc"))
+	}
+	// файл настроек загрузчика разбирает сам загрузчик
+	fs.String(loaderConfigFlag, "", "")
+	fs.Usage = func() { s.usage(vars) }
+
+	if err := fs.Parse(s.args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		return ErrBadConfig{Cause: err}
+	}
+
+	for _, v := range vars {
+		name := flagName(v)
+		fv := values[name]
+		if !fv.set {
+			if checkRequired && isTrue(v.Tags.Get("required")) && v.Field.IsZero() {
+				return BadFieldCode("--"+name, nil, CodeRequired, "missing value")
+			}
+			continue
+		}
+		if err := setFieldValue(fv.value, v.Field); err != nil {
+			if isSecretTag(v.Tags) {
+				return BadFieldCode("--"+name, nil, CodeParse, redactError(err, fv.value).Error())
+			}
+			return BadFieldCode("--"+name, fv.value, CodeParse, err.Error())
+		}
+	}
+	return nil
+}
+
+func (s *FlagSource) usage(vars []envVar) {
+	fmt.Fprintf(s.output, "Usage of %s:\n", s.name)
+	for _, v := range vars {
+		line := fmt.Sprintf("  --%s %s", flagName(v), v.Field.Type())
+		if v.Field.Kind() == reflect.Bool {
+			line = "  --" + flagName(v)
+		}
+		fmt.Fprintln(s.output, line)
+		desc := v.Tags.Get("desc")
+		if def := v.Tags.Get("default"); def != "" {
+			desc = strings.TrimSpace(fmt.Sprintf("%s (default %s)", desc, def))
+		}
+		if desc != "" {
+			fmt.Fprintf(s.output, "    \t%s\n", desc)
+		}
+	}
+}
+
+func flagName(v envVar) string {
+	return strings.ReplaceAll(strings.ToLower(v.Key), "_", "-")
+}
+
+// flagValue запоминает значение флага как есть, разбирается оно потом через setFieldValue
+type flagValue struct {
+	value  string
+	set    bool
+	isBool bool
+}
+
+func (f *flagValue) String() string {
+	return f.value
+}
+
+func (f *flagValue) Set(value string) error {
+	f.value = value
+	f.set = true
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
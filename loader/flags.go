@@ -0,0 +1,348 @@
+package loader
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Флаги - bool поля конфига с тегом flag:"name". Их можно переключить на ходу из админки
+// или удаленного источника (см. WithRemoteFlags и LOADER_FLAGS_URL) без пересборки приложения:
+// новое значение попадает в Config и приходит подписчикам Subscribe, а в графе остается конфиг,
+// с которым приложение собрано. Поэтому флаги читаются через ConfigProvider, см. FlagEnabled.
+// Значение из админки перекрывает удаленное, а удаленное - значение из конфига.
+// Переключенные флаги подставляются и в каждый новый и сохраненный конфиг, так что при перезагрузке
+// и откате выключатель остается выключенным
+
+// ErrUnknownFlag - в конфиге нет поля с таким тегом flag
+var ErrUnknownFlag = errors.New("unknown flag")
+
+const defaultFlagsInterval = time.Second * 10
+
+// откуда взято значение флага, FlagState.From
+const (
+	FlagFromConfig = "config"
+	FlagFromRemote = "remote"
+	FlagFromAdmin  = "admin"
+)
+
+// FlagState - флаг и его текущее значение, которое отдает /loader/flags
+type FlagState struct {
+	Name    string `json:"name"`
+	Field   string `json:"field"`
+	Enabled bool   `json:"enabled"`
+	From    string `json:"from"`
+}
+
+// RemoteFlagSource отдает флаги, которые перекрывают значения из конфига, например общий
+// выключатель функциональности. Флаги, которых нет в ответе, возвращаются к значениям из конфига
+type RemoteFlagSource interface {
+	Flags(ctx context.Context) (map[string]bool, error)
+}
+
+// WithRemoteFlags задает удаленный источник флагов, он опрашивается раз в LOADER_FLAGS_INTERVAL
+func WithRemoteFlags(source RemoteFlagSource) Option {
+	return func(l *AppLoader) {
+		l.flags.source = source
+	}
+}
+
+// HTTPFlagSource читает флаги из json объекта {"name": true} по GET запросу на url
+type HTTPFlagSource struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPFlagSource(url string, timeout time.Duration) *HTTPFlagSource {
+	return &HTTPFlagSource{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *HTTPFlagSource) Flags(ctx context.Context) (map[string]bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch flags")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to fetch flags from %s: %s", s.url, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read flags")
+	}
+	var flags map[string]bool
+	if err := json.Unmarshal(body, &flags); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse flags from %s", s.url)
+	}
+	return flags, nil
+}
+
+// FlagEnabled возвращает значение флага name из cfg, false, если такого флага нет
+func FlagEnabled(cfg Config, name string) bool {
+	enabled := false
+	walkFields(cfg.App, func(f configField) {
+		if f.Field.Tag.Get("flag") == name && f.Value.Kind() == reflect.Bool {
+			enabled = f.Value.Bool()
+		}
+	})
+	return enabled
+}
+
+// flagSet - флаги конфига и их переключенные значения
+type flagSet struct {
+	mu sync.Mutex
+	// путь к полю по имени флага
+	fields map[string]string
+	// значения из источника при последнем чтении конфига, к ним флаг возвращается без переопределений
+	configured map[string]bool
+	remote     map[string]bool
+	admin      map[string]bool
+	source     RemoteFlagSource
+	// неизвестные удаленные флаги, о которых уже написали в лог
+	unknown map[string]bool
+}
+
+// value возвращает значение флага с учетом переопределений, вызывается под mu
+func (s *flagSet) value(name string) (bool, string) {
+	if v, ok := s.admin[name]; ok {
+		return v, FlagFromAdmin
+	}
+	if v, ok := s.remote[name]; ok {
+		return v, FlagFromRemote
+	}
+	return s.configured[name], FlagFromConfig
+}
+
+// initFlags находит флаги в конфиге приложения и читает удаленные флаги,
+// чтобы выключатель действовал уже на первый собранный конфиг
+func (l *AppLoader) initFlags(ctx context.Context) error {
+	fields := map[string]string{}
+	var err error
+	walkFields(l.cfg.App, func(f configField) {
+		name, ok := f.Field.Tag.Lookup("flag")
+		if !ok || err != nil {
+			return
+		}
+		switch {
+		case name == "":
+			err = errors.Errorf("field %s: empty flag name", f.Path)
+		case f.Value.Kind() != reflect.Bool:
+			err = errors.Errorf("field %s: flag %q must be bool, got %s", f.Path, name, f.Value.Type())
+		case fields[name] != "":
+			err = errors.Errorf("flag %q is set on both %s and %s", name, fields[name], f.Path)
+		}
+		fields[name] = f.Path
+	})
+	if err != nil {
+		return err
+	}
+	l.flags.mu.Lock()
+	l.flags.fields = fields
+	l.flags.configured = map[string]bool{}
+	l.flags.admin = map[string]bool{}
+	l.flags.mu.Unlock()
+
+	if l.flags.source == nil && l.cfg.FlagsURL != "" {
+		l.flags.source = NewHTTPFlagSource(l.cfg.FlagsURL, l.cfg.HTTPTimeout)
+	}
+	if l.flags.source == nil {
+		return nil
+	}
+	if len(fields) == 0 {
+		l.log.Error("remote flags are set, but config has no flag fields")
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, l.cfg.HTTPTimeout)
+	defer cancel()
+	if remote, err := l.fetchFlags(fetchCtx); err != nil {
+		// удаленный источник недоступен - работаем на значениях из конфига, он опрашивается дальше в Start
+		l.log.Error("failed to fetch remote flags", "error", err)
+	} else {
+		l.flags.mu.Lock()
+		l.flags.remote = remote
+		l.flags.mu.Unlock()
+	}
+	return nil
+}
+
+// fetchFlags читает удаленные флаги, неизвестные флаги пропускаются и один раз попадают в лог
+func (l *AppLoader) fetchFlags(ctx context.Context) (map[string]bool, error) {
+	flags, err := l.flags.source.Flags(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l.flags.mu.Lock()
+	defer l.flags.mu.Unlock()
+	for name := range flags {
+		if _, ok := l.flags.fields[name]; ok {
+			continue
+		}
+		delete(flags, name)
+		if !l.flags.unknown[name] {
+			l.log.Error("unknown remote flag, ignoring", "flag", name)
+			if l.flags.unknown == nil {
+				l.flags.unknown = map[string]bool{}
+			}
+			l.flags.unknown[name] = true
+		}
+	}
+	return flags, nil
+}
+
+// applyFlags запоминает значения флагов из только что прочитанного из источника appCfg и подставляет
+// переключенные, чтобы перечитанный конфиг не отличался от работающего одними флагами
+func (l *AppLoader) applyFlags(appCfg interface{}) {
+	l.setFlags(appCfg, true)
+}
+
+// overrideFlags подставляет переключенные флаги в сохраненный конфиг. В нем значения флагов
+// могли сохраниться уже переключенными, поэтому значениями из конфига они не считаются
+func (l *AppLoader) overrideFlags(appCfg interface{}) {
+	l.setFlags(appCfg, false)
+}
+
+func (l *AppLoader) setFlags(appCfg interface{}, fromSource bool) {
+	l.flags.mu.Lock()
+	defer l.flags.mu.Unlock()
+	if len(l.flags.fields) == 0 {
+		return
+	}
+	walkFields(appCfg, func(f configField) {
+		name := f.Field.Tag.Get("flag")
+		if l.flags.fields[name] != f.Path {
+			return
+		}
+		if fromSource {
+			l.flags.configured[name] = f.Value.Bool()
+		}
+		if v, from := l.flags.value(name); from != FlagFromConfig {
+			f.Value.SetBool(v)
+		}
+	})
+}
+
+// Flags возвращает флаги конфига с текущими значениями, по имени
+func (l *AppLoader) Flags() []FlagState {
+	cfg := l.Config()
+	l.flags.mu.Lock()
+	defer l.flags.mu.Unlock()
+	states := make([]FlagState, 0, len(l.flags.fields))
+	walkFields(cfg.App, func(f configField) {
+		name := f.Field.Tag.Get("flag")
+		if name == "" || l.flags.fields[name] != f.Path {
+			return
+		}
+		_, from := l.flags.value(name)
+		states = append(states, FlagState{Name: name, Field: f.Path, Enabled: f.Value.Bool(), From: from})
+	})
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// SetFlag переключает флаг name до перезапуска процесса или ClearFlag, перекрывая
+// и конфиг, и удаленный источник
+func (l *AppLoader) SetFlag(name string, enabled bool) error {
+	l.flags.mu.Lock()
+	if _, ok := l.flags.fields[name]; !ok {
+		l.flags.mu.Unlock()
+		return errors.Wrap(ErrUnknownFlag, name)
+	}
+	l.flags.admin[name] = enabled
+	l.flags.mu.Unlock()
+	l.updateFlags(FlagFromAdmin)
+	return nil
+}
+
+// ClearFlag снимает переключение флага name из SetFlag, флаг возвращается
+// к удаленному значению или значению из конфига
+func (l *AppLoader) ClearFlag(name string) error {
+	l.flags.mu.Lock()
+	if _, ok := l.flags.fields[name]; !ok {
+		l.flags.mu.Unlock()
+		return errors.Wrap(ErrUnknownFlag, name)
+	}
+	delete(l.flags.admin, name)
+	l.flags.mu.Unlock()
+	l.updateFlags(FlagFromAdmin)
+	return nil
+}
+
+// watchFlags опрашивает удаленный источник флагов, пока не отменен ctx.
+// Если источник недоступен, остаются последние полученные значения
+func (l *AppLoader) watchFlags(ctx context.Context) {
+	ticker := time.NewTicker(l.Config().FlagsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, l.Config().HTTPTimeout)
+		remote, err := l.fetchFlags(fetchCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil {
+				l.log.Error("failed to fetch remote flags", "error", err)
+			}
+			continue
+		}
+		l.flags.mu.Lock()
+		changed := !reflect.DeepEqual(remote, l.flags.remote)
+		l.flags.remote = remote
+		l.flags.mu.Unlock()
+		if changed {
+			l.updateFlags(FlagFromRemote)
+		}
+	}
+}
+
+// updateFlags подставляет значения флагов в текущий конфиг без пересборки приложения
+// и рассылает его подписчикам, если какой-то флаг поменялся
+func (l *AppLoader) updateFlags(from string) {
+	// перезагрузка подставляет флаги в свой конфиг сама, а подмена конфига посреди нее потерялась бы
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+
+	cfg := l.Config()
+	var changed []string
+	l.flags.mu.Lock()
+	walkFields(cfg.App, func(f configField) {
+		name := f.Field.Tag.Get("flag")
+		if name == "" || l.flags.fields[name] != f.Path {
+			return
+		}
+		v, from := l.flags.value(name)
+		if _, ok := l.flags.configured[name]; from == FlagFromConfig && !ok {
+			// конфиг из источника еще не читался, например приложение запущено на сохраненном
+			return
+		}
+		if v != f.Value.Bool() {
+			f.Value.SetBool(v)
+			changed = append(changed, name)
+		}
+	})
+	l.flags.mu.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+
+	cfg.ConfigHash = l.configHash(cfg.App)
+	l.mu.Lock()
+	l.storeConfig(&cfg)
+	l.mu.Unlock()
+	l.log.Info("flags changed", "flags", changed, "from", from)
+	l.subs.notify(cfg)
+}
@@ -0,0 +1,68 @@
+package loader
+
+import (
+	"regexp"
+	"strings"
+
+	"go.uber.org/dig"
+)
+
+// GraphError - ошибка сборки fx графа с тем, где она случилась.
+// Вместо цепочки dig из всех конструкторов по пути в Error остаются только
+// самая глубокая функция и исходная ошибка, которую возвращает Unwrap
+type GraphError struct {
+	// функция, в которой возникла ошибка: упавший конструктор или функция, которой не хватило зависимостей
+	Function string
+	// файл и строка, где объявлена Function, для invoke неизвестны
+	Location string
+	// модуль WithModule, в котором объявлена Function
+	Module string
+	// поля или секции конфига, к которым относится ошибка, если их удалось определить
+	ConfigFields []string
+	// исходная ошибка dig целиком
+	Err error
+}
+
+func (e *GraphError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Function)
+	if e.Location != "" {
+		b.WriteString(" (" + e.Location + ")")
+	}
+	if e.Module != "" {
+		b.WriteString(" in module " + e.Module)
+	}
+	root := e.Unwrap()
+	// ErrBadConfig сама перечисляет плохие поля
+	if _, bad := findBadConfig(root); !bad && len(e.ConfigFields) > 0 {
+		b.WriteString(", config " + strings.Join(e.ConfigFields, ", "))
+	}
+	return b.String() + ": " + root.Error()
+}
+
+func (e *GraphError) Unwrap() error {
+	return dig.RootCause(e.Err)
+}
+
+// dig пишет функции как "pkg/path".Name (file:line), вложенные ошибки идут следом
+var graphFunctionRegexp = regexp.MustCompile(`function "([^"]+)"\.(\S+) \(([^)]*)\)`)
+
+// annotateGraphError оборачивает ошибку сборки err в GraphError, если в исходной ошибке dig raw
+// или в событиях fx удалось найти функцию. err может отличаться от raw после attributeToModule
+func (l *AppLoader) annotateGraphError(err, raw error, modules *moduleTracker) error {
+	graphErr := &GraphError{Module: modules.module(raw), Err: err}
+	if m := graphFunctionRegexp.FindAllStringSubmatch(raw.Error(), -1); len(m) > 0 {
+		last := m[len(m)-1]
+		graphErr.Function, graphErr.Location = last[1]+"."+last[2], last[3]
+	} else if graphErr.Function = modules.invokeFunction(); graphErr.Function == "" {
+		// ни dig, ни события fx не назвали функцию
+		return err
+	}
+	for _, fe := range badConfigFields(err) {
+		graphErr.ConfigFields = append(graphErr.ConfigFields, fe.Field)
+	}
+	if _, ok := l.fieldSection(graphErr.Module); ok && len(graphErr.ConfigFields) == 0 {
+		graphErr.ConfigFields = []string{graphErr.Module}
+	}
+	return graphErr
+}
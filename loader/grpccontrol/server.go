@@ -0,0 +1,221 @@
+// Package grpccontrol отдает управление загрузчиком по gRPC: ControlService из control.proto
+// повторяет HTTP админку loader.AdminHandler, чтобы оркестрация управляла загрузчиками
+// всего парка через типизированный клиент и свои интерсепторы авторизации, см. TokenAuth.
+package grpccontrol
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative control.proto
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sgrishanin/fx-rollback-proto/loader"
+)
+
+// Controller - то, чем управляет сервис, его реализует *loader.AppLoader
+type Controller interface {
+	Status() loader.AdminStatus
+	Config() loader.Config
+	Reload() error
+	Rollback() error
+	PromoteCurrentConfig() error
+}
+
+var _ Controller = (*loader.AppLoader)(nil)
+
+// callRecorder - Controller, который пишет вызовы управления в журнал, как *loader.AppLoader
+type callRecorder interface {
+	RecordAdminCall(ctx context.Context, call string, err error)
+}
+
+var _ callRecorder = (*loader.AppLoader)(nil)
+
+// Server реализует ControlServiceServer поверх загрузчика
+type Server struct {
+	UnimplementedControlServiceServer
+	loader Controller
+}
+
+func NewServer(l Controller) *Server {
+	return &Server{loader: l}
+}
+
+// Register регистрирует ControlService для l на сервере s, например:
+//
+//	srv := grpc.NewServer(grpc.UnaryInterceptor(grpccontrol.TokenAuth(token)))
+//	grpccontrol.Register(srv, appLoader)
+func Register(s grpc.ServiceRegistrar, l Controller) {
+	RegisterControlServiceServer(s, NewServer(l))
+}
+
+func (s *Server) GetStatus(context.Context, *GetStatusRequest) (*Status, error) {
+	return s.status()
+}
+
+func (s *Server) GetConfig(context.Context, *GetConfigRequest) (*GetConfigResponse, error) {
+	cfg, err := toStruct(s.loader.Config().Redacted())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &GetConfigResponse{Config: cfg}, nil
+}
+
+func (s *Server) Reload(ctx context.Context, _ *ReloadRequest) (*Status, error) {
+	err := s.loader.Reload()
+	s.record(ctx, "Reload", err)
+	if err != nil {
+		return nil, statusError(err, codes.FailedPrecondition)
+	}
+	return s.status()
+}
+
+func (s *Server) Rollback(ctx context.Context, _ *RollbackRequest) (*Status, error) {
+	err := s.loader.Rollback()
+	s.record(ctx, "Rollback", err)
+	if err != nil {
+		return nil, statusError(err, codes.FailedPrecondition)
+	}
+	return s.status()
+}
+
+func (s *Server) Promote(ctx context.Context, _ *PromoteRequest) (*Status, error) {
+	err := s.loader.PromoteCurrentConfig()
+	s.record(ctx, "Promote", err)
+	if err != nil {
+		return nil, statusError(err, codes.Internal)
+	}
+	return s.status()
+}
+
+// record пишет вызов method в журнал загрузчика. Если интерсепторы не назвали вызывающего
+// через loader.ContextWithAdminCaller, им становится CN клиентского сертификата при mTLS
+func (s *Server) record(ctx context.Context, method string, err error) {
+	rec, ok := s.loader.(callRecorder)
+	if !ok {
+		return
+	}
+	if loader.AdminCaller(ctx) == "" {
+		if caller := peerCertName(ctx); caller != "" {
+			ctx = loader.ContextWithAdminCaller(ctx, caller)
+		}
+	}
+	rec.RecordAdminCall(ctx, ControlService_ServiceDesc.ServiceName+"/"+method, err)
+}
+
+func peerCertName(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 {
+		return ""
+	}
+	return info.State.VerifiedChains[0][0].Subject.CommonName
+}
+
+func (s *Server) status() (*Status, error) {
+	st := s.loader.Status()
+	res := &Status{
+		Source:              st.Source,
+		ConfigHash:          st.ConfigHash,
+		UsesFallbackConfig:  st.UsesFallbackConfig,
+		FallbackIndex:       int32(st.FallbackIndex),
+		FallbackAge:         st.FallbackAge,
+		FallbackSections:    st.FallbackSections,
+		DefaultedFields:     st.DefaultedFields,
+		ConfigError:         st.ConfigError,
+		CanaryHeld:          st.CanaryHeld,
+		PendingConfirmation: st.PendingConfirmation,
+		Schema:              st.Schema,
+	}
+	if st.FallbackSavedAt != nil {
+		res.FallbackSavedAt = timestamppb.New(*st.FallbackSavedAt)
+	}
+	for _, d := range st.FallbackDiff {
+		oldValue, err := toValue(d.Old)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		newValue, err := toValue(d.New)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		res.FallbackDiff = append(res.FallbackDiff, &FieldDiff{Field: d.Field, Old: oldValue, New: newValue})
+	}
+	for _, fe := range st.ConfigErrorFields {
+		value, err := toValue(fe.Value)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		res.ConfigErrorFields = append(res.ConfigErrorFields, &FieldError{Field: fe.Field, Value: value, Code: fe.Code, Reason: fe.Reason})
+	}
+	return res, nil
+}
+
+// statusError переводит ошибку загрузчика в статус gRPC, code - для ошибок конфига и откатов
+func statusError(err error, code codes.Code) error {
+	switch {
+	case errors.Is(err, loader.ErrConfigParse):
+		code = codes.InvalidArgument
+	case errors.Is(err, loader.ErrFallbackUnavailable):
+		code = codes.NotFound
+	case errors.Is(err, loader.ErrGraphBuild):
+		code = codes.Internal
+	}
+	return status.Error(code, err.Error())
+}
+
+// toValue переводит значение поля в google.protobuf.Value через его JSON представление,
+// как оно выглядит в HTTP админке
+func toValue(v interface{}) (*structpb.Value, error) {
+	var decoded interface{}
+	if err := jsonRoundTrip(v, &decoded); err != nil {
+		return nil, err
+	}
+	return structpb.NewValue(decoded)
+}
+
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	decoded := map[string]interface{}{}
+	if err := jsonRoundTrip(v, &decoded); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(decoded)
+}
+
+func jsonRoundTrip(v, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode value")
+	}
+	return errors.Wrap(json.Unmarshal(data, out), "failed to decode value")
+}
+
+// TokenAuth пропускает только запросы с метаданными authorization: Bearer <token>,
+// в журнал вызовов они пишутся от имени token. Для mTLS или своей схемы авторизации
+// подключите вместо него свой интерсептор, он может назвать вызывающего через loader.ContextWithAdminCaller
+func TokenAuth(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		for _, auth := range md.Get("authorization") {
+			got := strings.TrimPrefix(auth, "Bearer ")
+			if token != "" && got != auth && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1 {
+				return handler(loader.ContextWithAdminCaller(ctx, "token"), req)
+			}
+		}
+		return nil, status.Error(codes.Unauthenticated, "invalid or missing token")
+	}
+}
@@ -0,0 +1,175 @@
+package loader
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+
+	"go.uber.org/fx"
+)
+
+type HealthStatus string
+
+const (
+	// приложение работает на текущем конфиге
+	HealthOK HealthStatus = "ok"
+	// приложение работает на откаченном конфиге
+	HealthDegraded HealthStatus = "degraded"
+	// приложение не запущено, перезапускается или не прошла одна из проверок
+	HealthUnavailable HealthStatus = "unavailable"
+)
+
+// Health - состояние приложения с точки зрения загрузчика
+type Health struct {
+	Status HealthStatus `json:"status"`
+	// готово ли приложение принимать запросы
+	Ready bool `json:"ready"`
+	// ошибки проверок, добавленных через HealthReporter.AddCheck
+	Failed map[string]string `json:"failed,omitempty"`
+	// почему не применился последний конфиг
+	ConfigError string `json:"config_error,omitempty"`
+}
+
+// HealthReporter предоставляется в fx граф загрузчиком.
+// Приложение может добавить в него свои проверки, которые учитываются, пока это приложение работает:
+// после перезагрузки действуют уже проверки, добавленные новым приложением.
+type HealthReporter interface {
+	Health() Health
+	// AddCheck добавляет проверку с именем name, при ошибке приложение считается неготовым.
+	// Проверка с тем же именем заменяется.
+	AddCheck(name string, check func() error)
+}
+
+// состояние, из которого считается Health
+type healthState struct {
+	mu sync.Mutex
+	// приложение запущено через Start
+	running bool
+	// текущее приложение остановлено и запускается новое
+	restarting bool
+	// проверки работающего приложения
+	checks *healthChecks
+}
+
+func (s *healthState) setRunning(running bool) {
+	s.mu.Lock()
+	s.running = running
+	s.mu.Unlock()
+}
+
+func (s *healthState) setRestarting(restarting bool) {
+	s.mu.Lock()
+	s.restarting = restarting
+	s.mu.Unlock()
+}
+
+// проверки одного собранного приложения
+type healthChecks struct {
+	l *AppLoader
+
+	mu     sync.Mutex
+	checks map[string]func() error
+}
+
+func (c *healthChecks) Health() Health {
+	return c.l.Health()
+}
+
+func (c *healthChecks) AddCheck(name string, check func() error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+func (c *healthChecks) run() map[string]string {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	checks := c.checks
+	c.mu.Unlock()
+
+	sort.Strings(names)
+	failed := map[string]string{}
+	for _, name := range names {
+		if err := checks[name](); err != nil {
+			failed[name] = err.Error()
+		}
+	}
+	return failed
+}
+
+// healthOptions отдает HealthReporter в граф приложения,
+// а его проверки начинают учитываться, когда приложение стартует
+func (l *AppLoader) healthOptions() fx.Option {
+	checks := &healthChecks{l: l, checks: map[string]func() error{}}
+	return fx.Options(
+		fx.Provide(func() HealthReporter { return checks }),
+		fx.Invoke(func(lc fx.Lifecycle) {
+			lc.Append(fx.Hook{OnStart: func(context.Context) error {
+				l.health.mu.Lock()
+				l.health.checks = checks
+				l.health.mu.Unlock()
+				return nil
+			}})
+		}),
+	)
+}
+
+// Health возвращает состояние приложения: оно не готово, пока не запущено или перезапускается
+// с новым конфигом, а на откаченном конфиге считается деградировавшим
+func (l *AppLoader) Health() Health {
+	cfg := l.Config().Redacted()
+
+	l.health.mu.Lock()
+	ready := l.health.running && !l.health.restarting
+	checks := l.health.checks
+	l.health.mu.Unlock()
+
+	h := Health{Status: HealthOK, Ready: ready}
+	if checks != nil && ready {
+		if failed := checks.run(); len(failed) > 0 {
+			h.Failed = failed
+			h.Ready = false
+		}
+	}
+	switch {
+	case !h.Ready:
+		h.Status = HealthUnavailable
+	case cfg.UsesFallbackConfig:
+		h.Status = HealthDegraded
+	}
+	h.ConfigError = cfg.ConfigError
+	return h
+}
+
+func (l *AppLoader) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	h := l.Health()
+	code := http.StatusOK
+	if h.Status == HealthUnavailable {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, h)
+}
+
+func (l *AppLoader) handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	ready := l.Health().Ready
+	if isTrue(r.FormValue("promoted")) {
+		ready = l.Promoted()
+	}
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,102 @@
+package loader
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+)
+
+// freshConfig возвращает копию cfg с пустым конфигом приложения и без следов отката
+func freshConfig(cfg *Config) *Config {
+	fresh := *cfg
+	fresh.App = newAppConfig(cfg.App)
+	fresh.UsesFallbackConfig = false
+	fresh.FallbackIndex = 0
+	fresh.FallbackSavedAt = nil
+	fresh.FallbackDiff = nil
+	fresh.FallbackSections = nil
+	fresh.ConfigError = ""
+	fresh.ConfigErrorFields = nil
+	fresh.ConfigHash = ""
+	fresh.CanaryHeld = false
+	return &fresh
+}
+
+// heldConfig возвращает конфиг, с которым загрузчик ждет рабочего конфига:
+// без приложения и с ошибкой последней попытки cfg. Если откатиться было не на что,
+// в ошибку попадают и плохие поля конфига из источника
+func heldConfig(cfg *Config, err error) *Config {
+	held := freshConfig(cfg)
+	held.ConfigError = err.Error()
+	held.ConfigErrorFields = badConfigFields(err)
+	if cfg.ConfigError != "" && !strings.Contains(held.ConfigError, cfg.ConfigError) {
+		held.ConfigError = cfg.ConfigError + "; " + held.ConfigError
+	}
+	if held.ConfigErrorFields == nil {
+		held.ConfigErrorFields = cfg.ConfigErrorFields
+	}
+	return held
+}
+
+// hold перечитывает конфиг с экспоненциальной задержкой от LOADER_RETRY_MIN_INTERVAL
+// до LOADER_RETRY_MAX_INTERVAL, пока с ним или с сохраненными конфигами не соберется приложение.
+// Прерывается по SIGINT/SIGTERM и Stop.
+// С LOADER_DIAGNOSTICS_ADDR на это время поднимается сервер с ошибкой конфига, см. diagnosticsHandler.
+func (l *AppLoader) hold() (*fx.App, error) {
+	if addr := l.Config().DiagnosticsAddr; addr != "" {
+		diag, err := serveHTTP(addr, l.diagnosticsHandler(), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to start diagnostics server")
+		}
+		// адрес диагностики обычно совпадает с адресом приложения, поэтому он освобождается до старта приложения
+		defer diag.Close()
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	delay := l.Config().RetryMinInterval
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case s := <-sig:
+			timer.Stop()
+			l.shutdown.notify(s)
+			return nil, errors.Errorf("received %s while waiting for valid config", s)
+		case <-l.shutdown.requested():
+			timer.Stop()
+			return nil, errors.New("loader stopped while waiting for valid config")
+		case <-l.retry:
+			timer.Stop()
+			l.log.Info("config reload requested while waiting for valid config")
+		case <-timer.C:
+		}
+
+		cur := l.Config()
+		cfg := freshConfig(&cur)
+		ctx, cancel := context.WithTimeout(context.Background(), cur.LoadTimeout)
+		app, err := l.buildFromSources(ctx, cfg)
+		cancel()
+		if err == nil {
+			l.log.Info("valid config appeared, starting app", "fallback", cfg.UsesFallbackConfig)
+			l.setCurrent(cfg, app)
+			l.subs.notify(*cfg)
+			return app, nil
+		}
+
+		delay *= 2
+		if delay > cur.RetryMaxInterval {
+			delay = cur.RetryMaxInterval
+		}
+		l.log.Error("still no valid config", "retry_in", delay.String(), "error", err)
+		l.mu.Lock()
+		l.storeConfig(heldConfig(cfg, err))
+		l.mu.Unlock()
+	}
+}
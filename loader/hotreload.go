@@ -0,0 +1,77 @@
+package loader
+
+import (
+	"context"
+	"reflect"
+)
+
+// значения тега reload
+const (
+	// поле применяется без пересборки приложения: новый конфиг только попадает в Config и Subscribe
+	reloadTagHot = "hot"
+	// при изменении поля приложение пересобирается, так работают и поля без тега
+	reloadTagRestart = "restart"
+)
+
+// onlyHotChanged проверяет, что next отличается от prev только полями с тегом reload:"hot".
+// Тег на вложенной структуре относится ко всем ее полям, кроме помеченных reload:"restart"
+func onlyHotChanged(prev, next interface{}) bool {
+	if reflect.DeepEqual(prev, next) {
+		return false
+	}
+	prevFields := map[string]reflect.Value{}
+	walkFields(prev, func(f configField) {
+		prevFields[f.Path] = f.Value
+	})
+	nextFields := map[string]reflect.Value{}
+	walkFields(next, func(f configField) {
+		nextFields[f.Path] = f.Value
+	})
+
+	// в копии next возвращаем hot поля к значениям prev: если копия совпала с prev,
+	// остальные поля не менялись. Родитель обходится раньше вложенных полей,
+	// поэтому restart поле внутри hot структуры получает обратно значение из next
+	merged := DeepCopy(next)
+	hot := false
+	walkFields(merged, func(f configField) {
+		from := prevFields
+		switch f.Field.Tag.Get("reload") {
+		case reloadTagHot:
+			hot = true
+		case reloadTagRestart:
+			from = nextFields
+		default:
+			return
+		}
+		v, ok := from[f.Path]
+		if !ok {
+			// на этом месте nil указатель, поле сравнится вместе с родителем
+			return
+		}
+		f.Value.Set(copyDeep(v))
+	})
+	return hot && reflect.DeepEqual(merged, prev)
+}
+
+// hotReload применяет next, в котором поменялись только hot поля, не пересобирая приложение.
+// Конфиг проверяется так же, как перед сборкой; false - конфиг не прошел проверку,
+// и его нужно применять обычной перезагрузкой, которая и отклонит его с ошибкой
+func (l *AppLoader) hotReload(ctx context.Context, prev, next *Config) (bool, error) {
+	next.DefaultedFields = l.defaultedFields(next.App)
+	next.ConfigHash = l.configHash(next.App)
+	validateCtx, span := l.startSpan(ctx, spanValidate)
+	err := l.validate(validateCtx, next.App)
+	endSpan(span, err)
+	if err != nil {
+		return false, nil
+	}
+
+	app := l.currentApp()
+	l.mu.Lock()
+	l.storeConfig(next)
+	l.mu.Unlock()
+	l.log.Info("config applied without restart", "diff", diffConfigs(flattenConfig(prev.App), flattenConfig(next.App)), "config_hash", next.ConfigHash)
+	l.audit(auditAction(next), next, prev, nil)
+	l.subs.notify(*next)
+	return true, l.confirmConfig(next, app)
+}
@@ -0,0 +1,99 @@
+package loader
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPSource загружает конфиг приложения в json по HTTP(S).
+// Ответ запоминается вместе с ETag, и следующие запросы идут с If-None-Match,
+// так что на 304 конфиг берется из запомненного ответа.
+// Если сервер недоступен или вернул невалидный ответ, Load возвращает ErrBadConfig,
+// и загрузчик откатывается на последний рабочий конфиг.
+// Ключи и значения разбираются так же, как в FileSource.
+type HTTPSource struct {
+	url    string
+	client *http.Client
+
+	mu   sync.Mutex
+	etag string
+	body []byte
+}
+
+// NewHTTPSource создает источник, ограничивающий каждый запрос таймаутом timeout
+func NewHTTPSource(url string, timeout time.Duration) *HTTPSource {
+	return &HTTPSource{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+func (s *HTTPSource) String() string {
+	return "http " + s.url
+}
+
+func (s *HTTPSource) Load(cfg interface{}) error {
+	return s.LoadContext(context.Background(), cfg)
+}
+
+// LoadContext прерывает запрос, когда отменен ctx
+func (s *HTTPSource) LoadContext(ctx context.Context, cfg interface{}) error {
+	body, err := s.fetch(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return errors.Wrap(ctx.Err(), "failed to fetch config")
+		}
+		return ErrBadConfig{Cause: err}
+	}
+	values, err := parseConfigFile(body, FileFormatJSON)
+	if err != nil {
+		return ErrBadConfig{Cause: errors.Wrapf(err, "failed to parse config from %s", s.url)}
+	}
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("config must be a struct pointer")
+	}
+	return decodeValues(values, v.Elem(), "")
+}
+
+// fetch возвращает тело ответа, на 304 - запомненное с прошлого раза
+func (s *HTTPSource) fetch(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch config")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && s.body != nil:
+		return s.body, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, errors.Errorf("failed to fetch config from %s: %s", s.url, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read config")
+	}
+	// запоминаем только разобравшийся ответ, чтобы на 304 не отдавать битый
+	if _, err := parseConfigFile(body, FileFormatJSON); err == nil {
+		s.etag = resp.Header.Get("ETag")
+		s.body = body
+	}
+	return body, nil
+}
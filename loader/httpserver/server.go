@@ -0,0 +1,193 @@
+// Package httpserver - HTTP сервер для приложений, которые запускает loader.AppLoader:
+// адрес, таймауты и TLS берутся из конфига, порт слушается через loader.ListenerProvider,
+// а при остановке сервер дожидается активных запросов через http.Server.Shutdown.
+package httpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+
+	"github.com/sgrishanin/fx-rollback-proto/loader"
+)
+
+// Config - настройки сервера. Подходит и как секция loader.WithModule, и как вложенная структура
+// конфига приложения: значения по умолчанию задаются тегами default, а Validate проверяет TLS
+type Config struct {
+	Addr              loader.HostPort `envconfig:"addr" json:"addr" default:":8080" validate:"required" desc:"адрес, на котором слушает сервер"`
+	ReadTimeout       time.Duration   `envconfig:"read_timeout" json:"read_timeout" default:"30s"`
+	ReadHeaderTimeout time.Duration   `envconfig:"read_header_timeout" json:"read_header_timeout" default:"10s"`
+	WriteTimeout      time.Duration   `envconfig:"write_timeout" json:"write_timeout" default:"30s"`
+	IdleTimeout       time.Duration   `envconfig:"idle_timeout" json:"idle_timeout" default:"2m"`
+	// сколько ждать активные запросы при остановке, потом соединения закрываются.
+	// Ожидание ограничено и таймаутом остановки приложения LOADER_STOP_TIMEOUT
+	ShutdownTimeout time.Duration   `envconfig:"shutdown_timeout" json:"shutdown_timeout" default:"15s"`
+	MaxHeaderBytes  loader.ByteSize `envconfig:"max_header_bytes" json:"max_header_bytes" default:"1MiB"`
+	TLSCert         string          `envconfig:"tls_cert" json:"tls_cert,omitempty" desc:"сертификат для HTTPS"`
+	TLSKey          string          `envconfig:"tls_key" json:"tls_key,omitempty" desc:"ключ сертификата для HTTPS"`
+}
+
+// DefaultConfig возвращает Config со значениями из тегов default, если приложение собирает Config само,
+// а не читает его загрузчиком
+func DefaultConfig() Config {
+	var cfg Config
+	_ = loader.ResolveTags(&cfg, "default", func(value string) (string, error) { return value, nil })
+	return cfg
+}
+
+// Validate проверяет, что сертификат и ключ заданы вместе и читаются, чтобы конфиг с ними
+// откатывался до сборки приложения, а не падал при старте сервера
+func (c Config) Validate() error {
+	if c.TLSCert == "" && c.TLSKey == "" {
+		return nil
+	}
+	if c.TLSCert == "" || c.TLSKey == "" {
+		return loader.BadFieldCode("tls_cert", nil, loader.CodeRequired, "tls_cert and tls_key must be set together")
+	}
+	if _, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey); err != nil {
+		return loader.BadField("tls_cert", c.TLSCert, err.Error())
+	}
+	return nil
+}
+
+// Server - HTTP сервер, который запускается и останавливается вместе с приложением
+type Server struct {
+	cfg       Config
+	listeners loader.ListenerProvider
+	srv       *http.Server
+
+	mu       sync.Mutex
+	lis      net.Listener
+	err      error
+	draining bool
+}
+
+func New(cfg Config, handler http.Handler, listeners loader.ListenerProvider) *Server {
+	return &Server{
+		cfg:       cfg,
+		listeners: listeners,
+		srv: &http.Server{
+			Handler:           handler,
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+			MaxHeaderBytes:    int(cfg.MaxHeaderBytes),
+		},
+	}
+}
+
+// Module собирает Server из Config и http.Handler в графе и запускает его вместе с приложением:
+//
+//	loader.WithModule("http", new(httpserver.Config), httpserver.Module(), fx.Provide(NewHandler))
+//
+// Ошибки адреса и TLS относятся к секции модуля, так что откатывается только она.
+// fx.Module не прячет свои конструкторы, поэтому Module подключается в приложение один раз,
+// для нескольких серверов используйте New и Register
+func Module() fx.Option {
+	return fx.Options(
+		fx.Provide(New),
+		fx.Invoke(Register),
+	)
+}
+
+// Register запускает s в OnStart приложения, останавливает в OnStop и добавляет его проверку
+// в готовность приложения
+func Register(lifecycle fx.Lifecycle, s *Server, health loader.HealthReporter) {
+	lifecycle.Append(fx.Hook{
+		OnStart: s.Start,
+		OnStop:  s.Stop,
+	})
+	health.AddCheck("http_server", s.Check)
+}
+
+// Start занимает порт и начинает принимать запросы. Порт занимается при старте, а не в New,
+// чтобы при hot reload новое приложение можно было собрать, пока старое еще держит порт,
+// а через loader.ListenerProvider с LOADER_RELOAD_STRATEGY=bluegreen оба слушают один сокет.
+// Занятый порт и нечитаемый сертификат - ошибки конфига, тогда загрузчик запустит приложение
+// на сохраненном конфиге
+func (s *Server) Start(_ context.Context) error {
+	var tlsCfg *tls.Config
+	if s.cfg.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(s.cfg.TLSCert, s.cfg.TLSKey)
+		if err != nil {
+			return loader.BadField("tls_cert", s.cfg.TLSCert, err.Error())
+		}
+		tlsCfg = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	lis, err := s.listeners.Listen("tcp", s.cfg.Addr.String())
+	if err != nil {
+		return loader.BadField("addr", s.cfg.Addr.String(), err.Error())
+	}
+	if tlsCfg != nil {
+		lis = tls.NewListener(lis, tlsCfg)
+	}
+	s.mu.Lock()
+	s.lis = lis
+	s.mu.Unlock()
+
+	go func() {
+		err := s.srv.Serve(lis)
+		if errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+	}()
+	return nil
+}
+
+// Stop перестает принимать соединения и ждет, пока завершатся активные запросы,
+// не дольше ShutdownTimeout и таймаута ctx. Оставшиеся соединения закрываются.
+// Пока запросы дожидаются, Check возвращает ошибку, так что приложение не готово к новым
+func (s *Server) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+
+	if s.cfg.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.ShutdownTimeout)
+		defer cancel()
+	}
+	if err := s.srv.Shutdown(ctx); err != nil {
+		// не дождались: обрываем оставшиеся соединения, чтобы не держать их после остановки приложения
+		_ = s.srv.Close()
+		return errors.Wrap(err, "http server shutdown dropped active requests")
+	}
+	return nil
+}
+
+// Check учитывается в готовности приложения, см. loader.HealthReporter
+func (s *Server) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lis == nil {
+		return errors.New("server is not listening")
+	}
+	if s.draining {
+		return errors.New("server is shutting down")
+	}
+	if s.err != nil {
+		return errors.Wrap(s.err, "server stopped")
+	}
+	return nil
+}
+
+// Addr - адрес, на котором слушает сервер, например с портом, выбранным для :0.
+// nil, пока сервер не запущен
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lis == nil {
+		return nil
+	}
+	return s.lis.Addr()
+}
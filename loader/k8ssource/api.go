@@ -0,0 +1,277 @@
+package k8ssource
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sgrishanin/fx-rollback-proto/loader"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Ref указывает на ConfigMap или Secret в namespace источника
+type Ref struct {
+	// configmaps или secrets
+	Resource string
+	Name     string
+}
+
+func ConfigMap(name string) Ref {
+	return Ref{Resource: "configmaps", Name: name}
+}
+
+func Secret(name string) Ref {
+	return Ref{Resource: "secrets", Name: name}
+}
+
+// API читает ConfigMap и Secret напрямую из Kubernetes API и следит за ними через watch.
+// Если ключ есть в нескольких объектах, побеждает последний из refs.
+// Поду нужны права get и watch на эти объекты.
+type API struct {
+	prefix    string
+	namespace string
+	refs      []Ref
+	options
+
+	mu sync.Mutex
+	// resourceVersion объектов с последней загрузки, с них начинается watch
+	versions map[Ref]string
+}
+
+// NewAPI создает источник для объектов refs в namespace.
+// Пустой namespace означает namespace пода. Вне кластера нужно задать WithAPIServer и WithToken.
+func NewAPI(prefix, namespace string, refs []Ref, opts ...Option) (*API, error) {
+	a := &API{prefix: prefix, namespace: namespace, refs: refs, options: defaultOptions(), versions: map[Ref]string{}}
+	for _, opt := range opts {
+		opt(&a.options)
+	}
+
+	if a.namespace == "" {
+		ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to detect namespace")
+		}
+		a.namespace = strings.TrimSpace(string(ns))
+	}
+	if a.apiServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("not running in kubernetes, KUBERNETES_SERVICE_HOST is not set")
+		}
+		a.apiServer = "https://" + net.JoinHostPort(host, port)
+	}
+	a.apiServer = strings.TrimSuffix(a.apiServer, "/")
+	if a.token == "" {
+		token, err := os.ReadFile(serviceAccountDir + "/token")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read service account token")
+		}
+		a.token = strings.TrimSpace(string(token))
+	}
+	if a.client == nil {
+		client, err := inClusterClient()
+		if err != nil {
+			return nil, err
+		}
+		a.client = client
+	}
+	return a, nil
+}
+
+// клиент без общего таймаута, чтобы watch мог висеть долго; запросы ограничиваются через ctx
+func inClusterClient() (*http.Client, error) {
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read cluster CA")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("failed to parse cluster CA")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
+
+var _ loader.NotifyingSource = (*API)(nil)
+
+func (a *API) String() string {
+	names := make([]string, 0, len(a.refs))
+	for _, ref := range a.refs {
+		names = append(names, ref.Resource+"/"+ref.Name)
+	}
+	return "k8s " + a.namespace + " " + strings.Join(names, ", ")
+}
+
+func (a *API) Load(cfg interface{}) error {
+	return a.LoadContext(context.Background(), cfg)
+}
+
+// LoadContext прерывает запросы к API, когда отменен ctx
+func (a *API) LoadContext(ctx context.Context, cfg interface{}) error {
+	values := map[string]string{}
+	for _, ref := range a.refs {
+		obj, err := a.get(ctx, ref)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return loader.ErrBadConfig{Cause: err}
+		}
+		data, err := obj.values(ref)
+		if err != nil {
+			return loader.ErrBadConfig{Cause: err}
+		}
+		for k, v := range data {
+			values[k] = v
+		}
+		a.mu.Lock()
+		a.versions[ref] = obj.Metadata.ResourceVersion
+		a.mu.Unlock()
+	}
+	return loader.NewLookupSource(a.prefix, lookupMap(values)).Load(cfg)
+}
+
+// Watch следит за всеми объектами и сообщает о каждом их изменении.
+// Оборвавшийся watch переподключается.
+func (a *API) Watch(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	for _, ref := range a.refs {
+		wg.Add(1)
+		go func(ref Ref) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				// ошибка watch не страшна, переподключаемся с последней известной версии
+				_ = a.watch(ctx, ref, ch)
+				select {
+				case <-ctx.Done():
+				case <-time.After(defaultWatchRetry):
+				}
+			}
+		}(ref)
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
+
+type object struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+// values возвращает ключи объекта, у Secret значения хранятся в base64
+func (o *object) values(ref Ref) (map[string]string, error) {
+	if ref.Resource != "secrets" {
+		return o.Data, nil
+	}
+	values := make(map[string]string, len(o.Data))
+	for k, v := range o.Data {
+		decoded, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode key %s of secret %s", k, ref.Name)
+		}
+		values[k] = string(decoded)
+	}
+	return values, nil
+}
+
+func (a *API) get(ctx context.Context, ref Ref) (*object, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	resp, err := a.do(ctx, fmt.Sprintf("/api/v1/namespaces/%s/%s/%s", a.namespace, ref.Resource, ref.Name))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var obj object
+	if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode %s/%s", ref.Resource, ref.Name)
+	}
+	return &obj, nil
+}
+
+type watchEvent struct {
+	Type   string `json:"type"`
+	Object object `json:"object"`
+}
+
+func (a *API) watch(ctx context.Context, ref Ref, ch chan struct{}) error {
+	query := url.Values{
+		"watch":         {"true"},
+		"fieldSelector": {"metadata.name=" + ref.Name},
+	}
+	a.mu.Lock()
+	if rv := a.versions[ref]; rv != "" {
+		query.Set("resourceVersion", rv)
+	}
+	a.mu.Unlock()
+
+	resp, err := a.do(ctx, fmt.Sprintf("/api/v1/namespaces/%s/%s?%s", a.namespace, ref.Resource, query.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	d := json.NewDecoder(resp.Body)
+	for {
+		var e watchEvent
+		if err := d.Decode(&e); err != nil {
+			return err
+		}
+		switch e.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			a.mu.Lock()
+			changed := a.versions[ref] != e.Object.Metadata.ResourceVersion
+			a.versions[ref] = e.Object.Metadata.ResourceVersion
+			a.mu.Unlock()
+			if changed {
+				notify(ch)
+			}
+		case "ERROR":
+			// обычно это 410 Gone: версия устарела, начинаем watch заново с текущего состояния
+			a.mu.Lock()
+			delete(a.versions, ref)
+			a.mu.Unlock()
+			return errors.Errorf("watch %s/%s failed", ref.Resource, ref.Name)
+		}
+	}
+}
+
+func (a *API) do(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.apiServer+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "kubernetes api request failed")
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("kubernetes api returned %s for %s", resp.Status, path)
+	}
+	return resp, nil
+}
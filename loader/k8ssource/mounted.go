@@ -0,0 +1,140 @@
+// Package k8ssource загружает конфиг loader из Kubernetes ConfigMap и Secret:
+// из смонтированных в под томов или напрямую через Kubernetes API.
+// Ключи ConfigMap и Secret разбираются как переменные окружения, например APP_SERVER_PORT.
+package k8ssource
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sgrishanin/fx-rollback-proto/loader"
+)
+
+// Mounted читает ConfigMap и Secret, смонтированные в под как каталоги, где каждый ключ - отдельный файл.
+// Kubernetes обновляет такие каталоги атомарно, подменяя ссылку ..data, поэтому Watch
+// сравнивает содержимое файлов раз в интервал опроса.
+// Если ключ есть в нескольких каталогах, побеждает последний.
+type Mounted struct {
+	prefix string
+	dirs   []string
+	options
+}
+
+// NewMounted создает источник для каталогов dirs, ключи ищутся с префиксом prefix, как в loader.NewEnvSource
+func NewMounted(prefix string, dirs []string, opts ...Option) *Mounted {
+	m := &Mounted{prefix: prefix, dirs: dirs, options: defaultOptions()}
+	for _, opt := range opts {
+		opt(&m.options)
+	}
+	return m
+}
+
+var _ loader.NotifyingSource = (*Mounted)(nil)
+
+func (m *Mounted) String() string {
+	return "k8s mounted " + strings.Join(m.dirs, ", ")
+}
+
+func (m *Mounted) Load(cfg interface{}) error {
+	values, err := m.read()
+	if err != nil {
+		return loader.ErrBadConfig{Cause: err}
+	}
+	return loader.NewLookupSource(m.prefix, lookupMap(values)).Load(cfg)
+}
+
+func (m *Mounted) Watch(ctx context.Context) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		last := m.fingerprint()
+
+		ticker := time.NewTicker(m.pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if cur := m.fingerprint(); !bytes.Equal(cur, last) {
+				last = cur
+				notify(ch)
+			}
+		}
+	}()
+	return ch
+}
+
+// read собирает ключи из всех каталогов
+func (m *Mounted) read() (map[string]string, error) {
+	values := map[string]string{}
+	for _, dir := range m.dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read mounted config")
+		}
+		for _, e := range entries {
+			// служебные ..data и ..<timestamp> и прочие скрытые файлы пропускаем
+			if strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read mounted config key %s", e.Name())
+			}
+			values[e.Name()] = strings.TrimSuffix(string(data), "\n")
+		}
+	}
+	return values, nil
+}
+
+// fingerprint - хеш содержимого всех ключей, ошибки чтения тоже дают свой хеш
+func (m *Mounted) fingerprint() []byte {
+	values, err := m.read()
+	h := sha256.New()
+	if err != nil {
+		h.Write([]byte(err.Error()))
+		return h.Sum(nil)
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(values[k]))
+		h.Write([]byte{0})
+	}
+	return h.Sum(nil)
+}
+
+func lookupMap(values map[string]string) func(key string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+}
+
+// notify не блокируется: если прошлое событие еще не обработано, нового не нужно
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,54 @@
+package k8ssource
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	defaultPollInterval = time.Second * 5
+	defaultTimeout      = time.Second * 10
+	// через сколько переподключаться, если watch в API оборвался
+	defaultWatchRetry = time.Second * 5
+)
+
+type options struct {
+	pollInterval time.Duration
+	client       *http.Client
+	apiServer    string
+	token        string
+}
+
+func defaultOptions() options {
+	return options{pollInterval: defaultPollInterval}
+}
+
+type Option func(o *options)
+
+// WithPollInterval задает, как часто Mounted проверяет файлы на изменения
+func WithPollInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = interval
+	}
+}
+
+// WithHTTPClient задает клиент для запросов в API, по умолчанию он доверяет CA кластера из service account
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithAPIServer задает адрес API, по умолчанию берется из KUBERNETES_SERVICE_HOST и KUBERNETES_SERVICE_PORT
+func WithAPIServer(addr string) Option {
+	return func(o *options) {
+		o.apiServer = addr
+	}
+}
+
+// WithToken задает токен для API, по умолчанию читается токен service account пода
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
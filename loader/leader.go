@@ -0,0 +1,36 @@
+package loader
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// LeaderElector выбирает среди реплик с общим хранилищем одну, которая записывает в него рабочий конфиг,
+// см. WithLeaderElector. Без него каждая реплика перезаписывает общий ключ своим конфигом
+type LeaderElector interface {
+	// IsLeader сообщает, может ли экземпляр сейчас писать в хранилище.
+	// Вызывается перед каждым сохранением конфига
+	IsLeader(ctx context.Context) (bool, error)
+}
+
+type LeaderElectorFunc func(ctx context.Context) (bool, error)
+
+func (f LeaderElectorFunc) IsLeader(ctx context.Context) (bool, error) {
+	return f(ctx)
+}
+
+// canSave проверяет, что экземпляр может записать рабочий конфиг в хранилище
+func (l *AppLoader) canSave(ctx context.Context) (bool, error) {
+	if l.leader == nil {
+		return true, nil
+	}
+	leader, err := l.leader.IsLeader(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to check fallback store leadership")
+	}
+	if !leader {
+		l.log.Info("not a leader, config is not saved to shared fallback store")
+	}
+	return leader, nil
+}
@@ -0,0 +1,109 @@
+package loader
+
+import (
+	"context"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// errStopped возвращают перезагрузки, которые начались после сигнала остановки
+var errStopped = errors.New("loader is stopped")
+
+// shutdown рассылает сигнал остановки в каналы из Done.
+// Сигнал запоминается, так что Done, вызванный после него, тоже его получит
+type shutdown struct {
+	mu    sync.Mutex
+	sig   os.Signal
+	chans []chan os.Signal
+	// закрывается вместе с первым сигналом
+	stop chan struct{}
+}
+
+func (s *shutdown) init() {
+	if s.stop == nil {
+		s.stop = make(chan struct{})
+	}
+}
+
+func (s *shutdown) done() <-chan os.Signal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	c := make(chan os.Signal, 1)
+	if s.sig != nil {
+		c <- s.sig
+		return c
+	}
+	s.chans = append(s.chans, c)
+	return c
+}
+
+func (s *shutdown) notify(sig os.Signal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	if s.sig != nil {
+		return
+	}
+	s.sig = sig
+	close(s.stop)
+	for _, c := range s.chans {
+		c <- sig
+	}
+	s.chans = nil
+}
+
+// requested возвращает канал, который закрывается, когда пришел сигнал остановки
+func (s *shutdown) requested() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	return s.stop
+}
+
+func (s *shutdown) isRequested() bool {
+	select {
+	case <-s.requested():
+		return true
+	default:
+		return false
+	}
+}
+
+// Done возвращает канал, в который придет сигнал остановки: SIGINT/SIGTERM процессу,
+// fx.Shutdowner работающего приложения (пока работает Start) или вызов Stop.
+// В отличие от fx.App.Done канал не теряется при hot reload, когда подменяется приложение
+func (l *AppLoader) Done() <-chan os.Signal {
+	return l.shutdown.done()
+}
+
+// Wait блокируется, пока не придет сигнал остановки, см. Done
+func (l *AppLoader) Wait() {
+	<-l.Done()
+}
+
+// Stop останавливает работающее приложение: Start и Run возвращают nil, а каналы Done получают SIGTERM.
+// Если идет перезагрузка, Stop сначала дожидается ее, а новые после него уже не начинаются
+func (l *AppLoader) Stop(ctx context.Context) error {
+	l.shutdown.notify(syscall.SIGTERM)
+	return l.stopCurrent(ctx)
+}
+
+// stopCurrent останавливает текущее приложение, если его еще не остановили
+func (l *AppLoader) stopCurrent(ctx context.Context) error {
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+
+	l.mu.Lock()
+	app := l.app
+	already := app == l.stopped
+	l.stopped = app
+	l.mu.Unlock()
+	if app == nil || already {
+		return nil
+	}
+	return l.stopApp(ctx, app)
+}
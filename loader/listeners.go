@@ -0,0 +1,226 @@
+package loader
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ListenerProvider предоставляется в fx граф загрузчиком. Порты приложению лучше слушать через него,
+// а не через net.Listen: сокеты принадлежат загрузчику, а не поколению приложения.
+//   - С LOADER_RELOAD_STRATEGY=bluegreen новое приложение получает тот же сокет, что и старое,
+//     и оба принимают соединения, пока старое не закроет свой listener.
+//   - Сокеты, переданные systemd (socket activation, LISTEN_FDS), выдаются по адресу
+//     или по имени из FileDescriptorName и не закрываются между перезагрузками.
+type ListenerProvider interface {
+	// Listen работает как net.Listen. Свой сокет загрузчик закрывает, когда закрыты
+	// все выданные на него listener, сокеты systemd остаются открытыми до конца процесса
+	Listen(network, addr string) (net.Listener, error)
+}
+
+// listenerPool раздает приложениям общие сокеты по network и addr
+type listenerPool struct {
+	mu     sync.Mutex
+	shared map[string]*sharedListener
+	// сокеты от systemd, которые еще никто не запросил
+	inherited []*sharedListener
+	once      sync.Once
+}
+
+func (p *listenerPool) Listen(network, addr string) (net.Listener, error) {
+	p.once.Do(p.inherit)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := network + "/" + addr
+	s, ok := p.shared[key]
+	if ok {
+		select {
+		case <-s.done:
+			// сокет сломался, открываем новый
+			ok = false
+		default:
+		}
+	}
+	if !ok {
+		if s = p.takeInherited(network, addr); s == nil {
+			lis, err := net.Listen(network, addr)
+			if err != nil {
+				return nil, err
+			}
+			s = newSharedListener(lis, "", false)
+		}
+		if p.shared == nil {
+			p.shared = map[string]*sharedListener{}
+		}
+		p.shared[key] = s
+	}
+	s.refs++
+	return &listenerRef{pool: p, key: key, shared: s, closed: make(chan struct{})}, nil
+}
+
+func (p *listenerPool) release(key string, s *sharedListener) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.refs--
+	if s.refs > 0 || s.inherited {
+		// соединения на сокет systemd ждут в очереди, пока его не запросит следующее приложение
+		return
+	}
+	if p.shared[key] == s {
+		delete(p.shared, key)
+	}
+	close(s.stop)
+	_ = s.lis.Close()
+}
+
+// inherit забирает сокеты, которые systemd передал процессу (sd_listen_fds)
+func (p *listenerPool) inherit() {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// дочерние процессы не должны считать эти сокеты своими
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	const firstFD = 3
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(firstFD+i), name)
+		lis, err := net.FileListener(f)
+		// FileListener сделал свою копию дескриптора
+		f.Close()
+		if err != nil {
+			// например, это UDP сокет
+			continue
+		}
+		p.inherited = append(p.inherited, newSharedListener(lis, name, true))
+	}
+}
+
+// takeInherited возвращает сокет systemd с именем addr или тем же адресом, вызывается под mu
+func (p *listenerPool) takeInherited(network, addr string) *sharedListener {
+	for i, s := range p.inherited {
+		if s.name == addr || sameAddr(s.lis.Addr(), network, addr) {
+			p.inherited = append(p.inherited[:i], p.inherited[i+1:]...)
+			return s
+		}
+	}
+	return nil
+}
+
+// sameAddr сравнивает адрес сокета с адресом из конфига, который может быть
+// записан по-другому: localhost вместо 127.0.0.1 или без хоста
+func sameAddr(a net.Addr, network, addr string) bool {
+	switch want := a.(type) {
+	case *net.TCPAddr:
+		if !strings.HasPrefix(network, "tcp") {
+			return false
+		}
+		resolved, err := net.ResolveTCPAddr(network, addr)
+		if err != nil || resolved.Port != want.Port {
+			return false
+		}
+		if resolved.IP == nil || resolved.IP.IsUnspecified() {
+			return want.IP == nil || want.IP.IsUnspecified()
+		}
+		return resolved.IP.Equal(want.IP)
+	case *net.UnixAddr:
+		return strings.HasPrefix(network, "unix") && want.Name == addr
+	}
+	return false
+}
+
+// sharedListener принимает соединения на сокете и отдает их тому listenerRef, который первым их ждет
+type sharedListener struct {
+	lis net.Listener
+	// имя сокета systemd
+	name string
+	// сокет передан systemd и не закрывается
+	inherited bool
+	conns     chan net.Conn
+	// закрывается, когда сокет перестал принимать соединения, причина в err
+	done chan struct{}
+	err  error
+	// закрывается, когда закрыт последний listenerRef
+	stop chan struct{}
+	// сколько listenerRef открыто, читать под mu пула
+	refs int
+}
+
+func newSharedListener(lis net.Listener, name string, inherited bool) *sharedListener {
+	s := &sharedListener{
+		lis:       lis,
+		name:      name,
+		inherited: inherited,
+		conns:     make(chan net.Conn),
+		done:      make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+	go s.acceptLoop()
+	return s
+}
+
+func (s *sharedListener) acceptLoop() {
+	for {
+		conn, err := s.lis.Accept()
+		if err != nil {
+			s.err = err
+			close(s.done)
+			return
+		}
+		select {
+		case s.conns <- conn:
+		case <-s.stop:
+			conn.Close()
+		}
+	}
+}
+
+// listenerRef - listener одного приложения на общем сокете
+type listenerRef struct {
+	pool   *listenerPool
+	key    string
+	shared *sharedListener
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (r *listenerRef) Accept() (net.Conn, error) {
+	select {
+	case <-r.closed:
+		return nil, net.ErrClosed
+	default:
+	}
+	select {
+	case conn := <-r.shared.conns:
+		return conn, nil
+	case <-r.closed:
+		return nil, net.ErrClosed
+	case <-r.shared.done:
+		return nil, r.shared.err
+	}
+}
+
+func (r *listenerRef) Close() error {
+	r.once.Do(func() {
+		close(r.closed)
+		r.pool.release(r.key, r.shared)
+	})
+	return nil
+}
+
+func (r *listenerRef) Addr() net.Addr {
+	return r.shared.lis.Addr()
+}
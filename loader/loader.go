@@ -0,0 +1,926 @@
+// Package loader собирает fx приложение из конфига и, если конфиг оказался плохим,
+// откатывается на последний известный рабочий конфиг.
+package loader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxevent"
+	"go.uber.org/multierr"
+)
+
+type AppLoader struct {
+	// cfg и app меняются при hot reload, читать их нужно под mu
+	mu  sync.RWMutex
+	cfg *Config
+	app *fx.App
+	// неизменяемая копия cfg для Config, обновляется вместе с cfg через storeConfig
+	snapshot atomic.Pointer[Config]
+
+	provider fx.Option
+	// префикс переменных окружения конфига приложения
+	prefix string
+	// правила имен переменных конфига приложения, см. WithEnvNaming
+	envNaming EnvNaming
+	// префикс переменных окружения самого загрузчика, см. WithLoaderEnvPrefix
+	loaderPrefix string
+	// таймауты из настроек загрузчика, к ним возвращается новый конфиг после отката
+	// на сохраненные таймауты, см. LOADER_FALLBACK_LOADER_CONFIG
+	timeouts loaderTimeouts
+	// файл настроек загрузчика, см. WithLoaderConfigFile
+	loaderConfigFile string
+	// отдельные конфиги модулей, см. WithConfig
+	sections []section
+	// модули приложения со своими секциями, см. WithModule
+	modules []moduleSpec
+	source  ConfigSource
+	store   FallbackStore
+	codec   ConfigCodec
+	// кто из реплик пишет в общее хранилище, см. WithLeaderElector
+	leader LeaderElector
+	// экземпляр не из канареек и применяет новый конфиг только после них, см. LOADER_CANARY_PERCENT
+	canaryHoldback bool
+	// неизвестные переменные окружения, о которых уже написали в лог, см. LOADER_UNKNOWN_ENV
+	unknownEnv atomic.Pointer[string]
+	// причина последнего отката, в том числе из прошлых запусков, см. LastRollback
+	lastRollback atomic.Pointer[RollbackReason]
+	// отчет о запуске, nil без LOADER_STARTUP_REPORT
+	startupReport *startupReport
+
+	schema     string
+	migrate    SchemaMigration
+	validators []Validator
+	// хуки перед сохранением рабочего конфига, см. WithBeforeSave
+	saveHooks []saveHook
+	// хуки после чтения конфига, см. WithAfterLoad
+	loadHooks []loadHook
+	metrics   metrics
+	events    events
+	// провайдер спанов загрузки, см. WithTracerProvider
+	tracerProvider trace.TracerProvider
+	auditLog       auditLog
+	notifiers      []Notifier
+	notify         *notifyEvents
+	// хуки, которые относят сторонние ошибки к ошибкам конфига, см. WithBadConfigClassifier
+	classifiers []func(error) bool
+	// ключ шифрования сохраненного конфига, см. WithFallbackKey
+	fallbackKey []byte
+	log         Logger
+	health      healthState
+	healthProbe HealthProbe
+	subs        subscribers
+	// флаги конфига и их переключения, см. SetFlag
+	flags     flagSet
+	listeners listenerPool
+	disposers appDisposers
+	graphs    appGraphs
+
+	// reloadMu не дает нескольким перезагрузкам идти одновременно
+	reloadMu sync.Mutex
+	// сюда пишется после подмены приложения, чтобы Start начал ждать уже новое
+	swapped chan struct{}
+	// сюда пишется, если после неудачной перезагрузки не осталось работающего приложения
+	failed chan error
+	// TLS админки из LOADER_ADMIN_TLS_CERT, nil - админка без TLS
+	adminTLS *tls.Config
+	// свои проверки доступа к админке, см. WithAdminMiddleware
+	adminMiddleware []func(http.Handler) http.Handler
+	// сюда пишется, чтобы hold перечитал конфиг, не дожидаясь задержки
+	retry chan struct{}
+	// отменяется, когда завершается Start, читать под mu
+	runCtx context.Context
+	// приложение, конфиг которого уже сохранен как рабочий, читать под mu
+	confirmed *fx.App
+	// конфиг процесса хотя бы раз сохранен как рабочий, см. Promoted
+	promoted atomic.Bool
+	// приложение, которое уже остановлено через Stop или Run, читать под mu
+	stopped  *fx.App
+	shutdown shutdown
+}
+
+// LoadApp загружает конфиг приложения из env с префиксом cfgPrefix в appConfigPtr
+// и собирает с ним приложение из appProvider.
+// То же самое, что New с WithEnvPrefix, WithApp и WithAppConfig.
+func LoadApp(cfgPrefix string, appProvider fx.Option, appConfigPtr interface{}, opts ...Option) (*AppLoader, error) {
+	return LoadAppContext(context.Background(), cfgPrefix, appProvider, appConfigPtr, opts...)
+}
+
+// LoadAppContext - то же, что LoadApp, но загрузку конфига можно прервать через ctx
+func LoadAppContext(ctx context.Context, cfgPrefix string, appProvider fx.Option, appConfigPtr interface{}, opts ...Option) (*AppLoader, error) {
+	return NewContext(ctx, append([]Option{
+		WithEnvPrefix(cfgPrefix),
+		WithApp(appProvider),
+		WithAppConfig(appConfigPtr),
+	}, opts...)...)
+}
+
+// New создает загрузчик и собирает приложение.
+// Обязательны WithApp и WithAppConfig, остальное имеет значения по умолчанию.
+func New(opts ...Option) (*AppLoader, error) {
+	return NewContext(context.Background(), opts...)
+}
+
+// NewContext - то же, что New, но чтение конфига из источника, работа с хранилищем
+// и валидаторы прерываются, когда отменен ctx. Помимо этого загрузка ограничена LOADER_LOAD_TIMEOUT.
+func NewContext(ctx context.Context, opts ...Option) (*AppLoader, error) {
+	l := AppLoader{
+		cfg:     &Config{},
+		swapped: make(chan struct{}, 1),
+		failed:  make(chan error, 1),
+		retry:   make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		opt(&l)
+	}
+	if len(l.sections) > 0 {
+		if l.cfg.App != nil {
+			return nil, errors.New("use either WithAppConfig or WithConfig")
+		}
+		var err error
+		if l.cfg.App, err = sectionsConfig(l.sections); err != nil {
+			return nil, err
+		}
+	}
+	if l.provider == nil {
+		return nil, errors.New("app is not set, use WithApp")
+	}
+	if t := reflect.TypeOf(l.cfg.App); t == nil || t.Kind() != reflect.Ptr {
+		return nil, errors.New("app config must be a pointer, use WithAppConfig")
+	}
+
+	ctx, span := l.startSpan(ctx, spanStartup)
+	err := l.createApp(ctx, l.prefix)
+	span.SetAttributes(fallbackAttrs(l.cfg)...)
+	endSpan(span, err)
+	if err != nil {
+		err = createError{errors.Wrap(err, "failed to create app")}
+		l.writeStartupReport(nil, err)
+		return nil, err
+	}
+	l.snapshot.Store(l.cfg)
+
+	return &l, nil
+}
+
+// здесь содержится основная магия с попытками сборки приложения на разных конфигах
+func (l *AppLoader) createApp(ctx context.Context, cfgPrefix string) (err error) {
+	if l.log == nil {
+		l.log = defaultLogger()
+	}
+	// сначала грузим конфиги самого загрузчика
+	err = l.initLoaderConfigFromEnv()
+	if err != nil {
+		return errors.Wrap(err, "failed to init loader config")
+	}
+	if err := l.printConfigDoc(); err != nil {
+		return errors.Wrap(err, "failed to print config doc")
+	}
+	if err := l.initMergeConflict(); err != nil {
+		return errors.Wrap(err, "failed to init loader config")
+	}
+	if l.source == nil {
+		if l.source, err = l.defaultSource(cfgPrefix); err != nil {
+			return errors.Wrap(err, "failed to init loader config")
+		}
+	}
+	if l.store == nil {
+		switch l.cfg.FallbackStore {
+		case "", FallbackStoreFile:
+			file := NewFileHistoryStore(l.cfg.FallbackPath, l.cfg.FallbackHistory)
+			l.store = newDegradingStore(file, l.cfg.FallbackHistory, l.log)
+		case FallbackStoreMemory:
+			l.store = NewMemoryStore(l.cfg.FallbackHistory)
+			if l.cfg.CrashLoopThreshold > 0 {
+				// журналу запусков нужно пережить перезапуск, а в этом режиме на диск ничего не пишется
+				l.log.Error("crash loop detection is disabled with memory fallback store")
+				l.cfg.CrashLoopThreshold = 0
+			}
+		default:
+			return errors.Errorf("failed to init loader config: unknown fallback store %q", l.cfg.FallbackStore)
+		}
+	}
+	if l.cfg.FallbackKey != "" && l.fallbackKey == nil {
+		if l.fallbackKey, err = parseFallbackKey(l.cfg.FallbackKey); err != nil {
+			return errors.Wrap(err, "failed to init loader config")
+		}
+	}
+	if l.fallbackKey != nil {
+		if l.store, err = NewEncryptedStore(l.store, l.fallbackKey); err != nil {
+			return errors.Wrap(err, "failed to init loader config")
+		}
+	}
+	if l.codec == nil {
+		if l.codec, err = codecByName(l.cfg.FallbackCodec); err != nil {
+			return errors.Wrap(err, "failed to init loader config")
+		}
+	}
+	if err := l.initSchema(); err != nil {
+		return errors.Wrap(err, "failed to init loader config")
+	}
+	if err := l.initStalePolicy(); err != nil {
+		return errors.Wrap(err, "failed to init loader config")
+	}
+	if err := l.initReloadStrategy(); err != nil {
+		return errors.Wrap(err, "failed to init loader config")
+	}
+	if err := l.initCanary(); err != nil {
+		return errors.Wrap(err, "failed to init loader config")
+	}
+	if err := l.initUnknownEnvPolicy(); err != nil {
+		return errors.Wrap(err, "failed to init loader config")
+	}
+	if err := l.initBadConfigMatch(); err != nil {
+		return errors.Wrap(err, "failed to init loader config")
+	}
+	if err := l.initFlags(ctx); err != nil {
+		return errors.Wrap(err, "failed to init flags")
+	}
+	l.initDiagnostics()
+	if err := l.initAdminTLS(); err != nil {
+		return errors.Wrap(err, "failed to init loader config")
+	}
+	l.initNotifiers()
+	l.initRollbackReason()
+	l.initStartupReport()
+	if l.cfg.AuditLog != "" {
+		l.auditLog.logs = append(l.auditLog.logs, NewFileAuditLog(l.cfg.AuditLog))
+	}
+	if err := l.initMetrics(); err != nil {
+		return errors.Wrap(err, "failed to register metrics")
+	}
+
+	loadCtx, cancel := context.WithTimeout(ctx, l.cfg.LoadTimeout)
+	defer cancel()
+	l.app, err = l.buildFromSources(loadCtx, l.cfg)
+	// если загрузку отменил вызывающий, ждать рабочего конфига незачем
+	if err != nil && l.cfg.HoldOnFailure && ctx.Err() == nil {
+		// процесс остается жить, а Start будет ждать, пока не появится рабочий конфиг
+		l.log.Error("no valid config, holding until one appears", "error", err)
+		l.app = nil
+		l.storeConfig(heldConfig(l.cfg, err))
+		return nil
+	}
+	return err
+}
+
+// buildFromSources собирает приложение с конфигом из источника,
+// а если он плохой - с сохраненными рабочими конфигами. Конфиг приложения читается в cfg.App.
+func (l *AppLoader) buildFromSources(ctx context.Context, cfg *Config) (app *fx.App, err error) {
+	// потом делаем попытку загрузить текущий конфиг.
+	// на этом этапе может быть либо ошибка парсинга конфига
+	loaded := false
+	configError := l.loadSource(ctx, cfg.App)
+	l.events.OnConfigLoaded(sourceName(l.source), configError)
+	if configError != nil {
+		l.metrics.loadFailures.WithLabelValues(failureStageLoad).Inc()
+		l.log.Error("failed to load config", "source", sourceName(l.source), "error", configError)
+	} else if configError = l.checkCrashLoop(cfg); configError != nil {
+		// конфиг прочитался, но процесс с ним раз за разом падает
+		l.log.Error("config rejected after repeated crashes", "error", configError)
+		loaded = true
+	}
+	if configError == nil {
+		loaded = true
+		// имея какой-то конфиг, который мы смогли распарсить,
+		// проверяем его и пытаемся собрать с ним приложение в fx.
+		// Экземпляр не из канареек вместо нового конфига собирается на последнем рабочем
+		restore, held := l.holdCanary(ctx, cfg)
+		app, configError = l.buildApp(ctx, cfg)
+		if configError != nil && held {
+			l.log.Error("failed to build app with known-good config, applying new config", "error", configError)
+			restore()
+			app, configError = l.buildApp(ctx, cfg)
+		}
+
+		// если ошибки нет, можем спокойно выходить.
+		// рабочим конфиг станет, только когда приложение с ним стартует, см. Start
+		if configError == nil {
+			l.log.Info("app built with current config", "source", sourceName(l.source), "config_hash", cfg.ConfigHash)
+			return app, nil
+		}
+	}
+
+	configError, ok := unwrapBadConfigError(l.classifyBadConfig(configError))
+	if !ok {
+		if !loaded {
+			return nil, errors.Wrap(configError, "failed to load current config")
+		}
+		return nil, errors.Wrap(configError, "failed to create app with current config")
+	}
+
+	// в строгом режиме не откатываемся, а сразу отдаем ошибку конфига
+	if cfg.Strict {
+		return nil, withClass(ErrFallbackIgnored, errors.Wrap(configError, "bad config in strict mode"))
+	}
+
+	traceRollback(ctx, configError)
+	app, err = l.buildFallback(ctx, cfg, configError)
+	if err != nil && !loaded {
+		// откатиться не вышло, а текущий конфиг даже не прочитался
+		err = withClass(ErrConfigParse, err)
+	}
+	return app, err
+}
+
+// buildFallback собирает приложение с сохраненными рабочими конфигами вместо плохого конфига из cfg.App,
+// перебирая их от нового к старому
+func (l *AppLoader) buildFallback(ctx context.Context, cfg *Config, configError error) (app *fx.App, err error) {
+	history, err := l.loadFallbackHistory(ctx, cfg)
+	if err != nil {
+		l.log.Error("failed to load fallback config", "error", err)
+		// приложению не на чем работать, а ошибка конфига из источника нужна, пока ждем рабочего, см. heldConfig
+		cfg.ConfigError = configError.Error()
+		cfg.ConfigErrorFields = badConfigFields(configError)
+		return nil, errors.Wrap(err, "failed to load fallback config")
+	}
+	rejected := flattenConfig(cfg.App)
+	timeouts := timeoutsOf(&cfg.LoaderConfig)
+	// если плохи только некоторые секции (см. WithConfig), сначала откатываем только их
+	if app := l.buildSectionFallback(ctx, cfg, history, configError, rejected); app != nil {
+		return app, nil
+	}
+	// каждый сохраненный конфиг читается заново от отвергнутого (поверх него читает политика merge),
+	// а не от того, с которым не собралось приложение; если не подошел ни один, в cfg.App
+	// возвращается отвергнутый конфиг
+	rejectedApp := DeepCopy(cfg.App)
+	defer func() {
+		if app == nil {
+			copyInto(cfg.App, rejectedApp)
+		}
+	}()
+	for i, data := range history {
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(phaseError(ctx, phaseFallback, ctx.Err()), "failed to load fallback config")
+		}
+		candidate := DeepCopy(rejectedApp)
+		var header snapshotHeader
+		if header, err = l.applyFallbackConfig(ctx, data, candidate); err != nil {
+			l.log.Error("failed to load fallback config", "index", i, "error", err)
+			err = withClass(ErrFallbackUnavailable, errors.Wrap(err, "failed to load fallback config"))
+			continue
+		}
+		copyInto(cfg.App, candidate)
+		cfg.UsesFallbackConfig = true
+		cfg.FallbackIndex = i
+		cfg.FallbackSavedAt = header.SavedAt
+		timeouts.apply(&cfg.LoaderConfig)
+		l.applySnapshotTimeouts(cfg, header)
+		cfg.FallbackDiff = diffConfigs(rejected, flattenConfig(cfg.App))
+		cfg.ConfigError = configError.Error()
+		cfg.ConfigErrorFields = badConfigFields(configError)
+
+		app, err = l.buildApp(ctx, cfg)
+		if err == nil {
+			l.log.Info("app built with fallback config", "index", i, "diff", cfg.FallbackDiff, "config_hash", cfg.ConfigHash)
+			l.events.OnFallbackApplied(*cfg)
+			return app, nil
+		}
+		if _, ok := unwrapBadConfigError(err); !ok {
+			return nil, errors.Wrap(err, "failed to create app with fallback config")
+		}
+		err = errors.Wrap(err, "failed to create app with fallback config")
+	}
+
+	// если же даже с откатом не получилось запустить приложение - все, приехали
+	return nil, err
+}
+
+// buildApp проверяет конфиг валидаторами и собирает с ним приложение.
+// если какой-то из резолверов кинул ошибку, она вернется вместе с приложением
+func (l *AppLoader) buildApp(ctx context.Context, cfg *Config) (app *fx.App, err error) {
+	start := time.Now()
+	ctx, span := l.startSpan(ctx, spanBuild, fallbackAttrs(cfg)...)
+	defer func() {
+		endSpan(span, err)
+		l.metrics.observeBuild(start, err)
+		l.events.OnAppBuilt(*cfg, time.Since(start), err)
+		if err != nil {
+			l.log.Error("failed to build app", "fallback", cfg.UsesFallbackConfig, "error", err)
+		}
+	}()
+
+	cfg.DefaultedFields = l.defaultedFields(cfg.App)
+	cfg.ConfigHash = l.configHash(cfg.App)
+	validateCtx, validateSpan := l.startSpan(ctx, spanValidate)
+	err = l.validate(validateCtx, cfg.App)
+	endSpan(validateSpan, err)
+	if err != nil {
+		return nil, err
+	}
+	// сначала проверяем, что граф собирается, не вызывая конструкторы,
+	// чтобы конструкторы с побочными эффектами не запускались на заведомо несобираемом графе
+	if err := fx.ValidateApp(l.appOptions(cfg, nil, nil), fx.NopLogger); err != nil {
+		return nil, withClass(ErrGraphBuild, errors.Wrap(l.annotateGraphError(err, err, nil), "invalid app graph"))
+	}
+	// fx.New не принимает ctx, поэтому зависший конструктор прерывается только по таймауту загрузки
+	modules := newModuleTracker()
+	disposer := &disposer{}
+	var built *fx.App
+	var graph fx.DotGraph
+	if err := withinPhase(ctx, phaseGraph, func() error {
+		built = fx.New(l.appOptions(cfg, modules, disposer), fx.Populate(&graph))
+		return nil
+	}); err != nil {
+		l.disposeGraph(disposer)
+		return nil, err
+	}
+	if raw := built.Err(); raw != nil {
+		err := l.classifyBadConfig(raw)
+		// конструкторы, которые успели выполниться, могли занять ресурсы, нужные следующей сборке
+		l.disposeGraph(disposer)
+		err = l.annotateGraphError(l.attributeToModule(err, modules), raw, modules)
+		if _, bad := unwrapBadConfigError(err); !bad {
+			err = withClass(ErrGraphBuild, err)
+		}
+		return built, err
+	}
+	l.disposers.add(built, disposer)
+	l.graphs.add(built, graph)
+	return built, nil
+}
+
+// provideAppConfig кладет в граф конфиг приложения под его собственным типом:
+// для new(SomeAppConfig) это SomeAppConfig и *SomeAppConfig.
+// С WithConfig так в граф попадает конфиг каждой секции.
+func (l *AppLoader) provideAppConfig(appCfg interface{}) fx.Option {
+	ptrs := []reflect.Value{reflect.ValueOf(appCfg)}
+	if len(l.sections) > 0 {
+		ptrs = sectionValues(appCfg)
+	}
+	provide := func(v reflect.Value) interface{} {
+		fn := reflect.FuncOf(nil, []reflect.Type{v.Type()}, false)
+		return reflect.MakeFunc(fn, func([]reflect.Value) []reflect.Value {
+			return []reflect.Value{v}
+		}).Interface()
+	}
+	var constructors []interface{}
+	for _, ptr := range ptrs {
+		constructors = append(constructors, provide(ptr), provide(ptr.Elem()))
+	}
+	return fx.Provide(constructors...)
+}
+
+// AppOptions возвращает опции fx, с которыми загрузчик собирает приложение на текущем конфиге:
+// граф приложения вместе со всем, что кладет в него загрузчик. С ними то же приложение можно
+// собрать самому, например через fxtest.New, см. loadertest.NewApp
+func (l *AppLoader) AppOptions() fx.Option {
+	cfg := l.Config()
+	return l.appOptions(&cfg, nil, nil)
+}
+
+// собирает опции fx приложения для конфига cfg.
+// modules, если задан, запоминает, в каких модулях объявлены конструкторы,
+// disposer, если задан, отдается в граф как Disposer
+func (l *AppLoader) appOptions(cfg *Config, modules *moduleTracker, disposer *disposer) fx.Option {
+	return fx.Options(
+		fx.StartTimeout(cfg.StartTimeout),
+		fx.StopTimeout(cfg.StopTimeout),
+		fx.WithLogger(func() fxevent.Logger { return fxLogger{log: l.log, modules: modules} }),
+		fx.Provide(
+			func() Config { return *cfg },
+			func() ConfigProvider { return l },
+			func() ListenerProvider { return &l.listeners },
+			provideDisposer(disposer),
+		),
+		l.provideAppConfig(cfg.App),
+		l.healthOptions(),
+		l.provider,
+		l.moduleOptions(),
+	)
+}
+
+const (
+	loaderConfigPrefix = "LOADER"
+
+	defaultLoaderStartTimeout = time.Second * 60
+	defaultLoaderLoadTimeout  = time.Second * 60
+	defaultLoaderStopTimeout  = time.Second * 60
+	defaultWatchInterval      = time.Second * 10
+	defaultRetryMinInterval   = time.Second
+	defaultRetryMaxInterval   = time.Minute
+	defaultHTTPTimeout        = time.Second * 10
+	defaultConfigExecTimeout  = time.Second * 10
+)
+
+// загружает конфиги самого AppLoader и проставляет дефолтные значения.
+// значения из env перекрывают значения из файла --loader-config, а те - заданные через опции
+func (l *AppLoader) initLoaderConfigFromEnv() error {
+	if err := l.loadLoaderConfigFile(); err != nil {
+		return err
+	}
+	if err := processEnvLayer("", &l.cfg.LoaderConfig, l.lookupLoaderEnv, EnvNaming{}, true); err != nil {
+		return err
+	}
+	// 0 означает значение по умолчанию, а отрицательный таймаут - явная ошибка
+	if err := checkTimeouts(timeoutsOf(&l.cfg.LoaderConfig)); err != nil {
+		return err
+	}
+
+	if l.cfg.LoaderConfig.StartTimeout == 0 {
+		l.cfg.LoaderConfig.StartTimeout = defaultLoaderStartTimeout
+	}
+	if l.cfg.LoaderConfig.LoadTimeout <= 0 {
+		l.cfg.LoaderConfig.LoadTimeout = defaultLoaderLoadTimeout
+	}
+	if l.cfg.LoaderConfig.StopTimeout == 0 {
+		l.cfg.LoaderConfig.StopTimeout = defaultLoaderStopTimeout
+	}
+	if l.cfg.LoaderConfig.WatchInterval <= 0 {
+		l.cfg.LoaderConfig.WatchInterval = defaultWatchInterval
+	}
+	if l.cfg.LoaderConfig.RetryMinInterval <= 0 {
+		l.cfg.LoaderConfig.RetryMinInterval = defaultRetryMinInterval
+	}
+	if l.cfg.LoaderConfig.RetryMaxInterval <= 0 {
+		l.cfg.LoaderConfig.RetryMaxInterval = defaultRetryMaxInterval
+	}
+	if l.cfg.LoaderConfig.RetryMaxInterval < l.cfg.LoaderConfig.RetryMinInterval {
+		l.cfg.LoaderConfig.RetryMaxInterval = l.cfg.LoaderConfig.RetryMinInterval
+	}
+	if l.cfg.LoaderConfig.CrashLoopWindow <= 0 {
+		l.cfg.LoaderConfig.CrashLoopWindow = defaultCrashLoopWindow
+	}
+	if l.cfg.LoaderConfig.ProbationInterval <= 0 {
+		l.cfg.LoaderConfig.ProbationInterval = defaultProbationInterval
+	}
+	if l.cfg.LoaderConfig.FlagsInterval <= 0 {
+		l.cfg.LoaderConfig.FlagsInterval = defaultFlagsInterval
+	}
+	if l.cfg.LoaderConfig.HTTPTimeout <= 0 {
+		l.cfg.LoaderConfig.HTTPTimeout = defaultHTTPTimeout
+	}
+	if l.cfg.LoaderConfig.ConfigExecTimeout <= 0 {
+		l.cfg.LoaderConfig.ConfigExecTimeout = defaultConfigExecTimeout
+	}
+	if l.cfg.LoaderConfig.FallbackHistory <= 0 {
+		l.cfg.LoaderConfig.FallbackHistory = 1
+	}
+	if l.cfg.LoaderConfig.FallbackPath == "" {
+		l.cfg.LoaderConfig.FallbackPath = defaultFallbackPath
+		// в памяти файловую систему незачем даже проверять
+		if l.cfg.LoaderConfig.FallbackStore != FallbackStoreMemory {
+			l.cfg.LoaderConfig.FallbackPath = defaultFallbackFile()
+		}
+	}
+	l.timeouts = timeoutsOf(&l.cfg.LoaderConfig)
+	l.clampTimeouts(&l.timeouts)
+	l.timeouts.apply(&l.cfg.LoaderConfig)
+
+	return nil
+}
+
+// загружает известные рабочие конфиги, от самого нового к самому старому
+func (l *AppLoader) loadFallbackHistory(ctx context.Context, cfg *Config) (history [][]byte, err error) {
+	ctx, span := l.startSpan(ctx, spanFallback)
+	defer func() {
+		span.SetAttributes(attribute.Int("fallback_count", len(history)))
+		endSpan(span, err)
+	}()
+
+	if cfg.IgnoreFallbackConfig {
+		return nil, ErrFallbackIgnored
+	}
+
+	if cfg.Strict {
+		return nil, withClass(ErrFallbackIgnored, errors.New("fallback config is disabled in strict mode"))
+	}
+
+	if cfg.UsesFallbackConfig {
+		return nil, ErrFallbackAlreadyApplied
+	}
+
+	if hs, ok := l.store.(HistoryStore); ok {
+		history, err := loadHistoryFromStore(ctx, hs, cfg.FallbackHistory)
+		if err != nil {
+			return nil, withClass(ErrFallbackUnavailable, phaseError(ctx, phaseFallback, err))
+		}
+		if len(history) == 0 {
+			return nil, withClass(ErrFallbackUnavailable, ErrFallbackNotFound)
+		}
+		return history, nil
+	}
+
+	data, err := loadFromStore(ctx, l.store)
+	if err != nil {
+		return nil, withClass(ErrFallbackUnavailable, phaseError(ctx, phaseFallback, err))
+	}
+	return [][]byte{data}, nil
+}
+
+// применяет сохраненный рабочий конфиг к конфигу приложения appCfg
+// и возвращает его заголовок
+func (l *AppLoader) applyFallbackConfig(ctx context.Context, data []byte, appCfg interface{}) (snapshotHeader, error) {
+	if isEncrypted(data) {
+		return snapshotHeader{}, ErrFallbackEncrypted
+	}
+	header, payload, versioned, err := decodeSnapshot(data)
+	if err != nil {
+		return header, err
+	}
+	if err := l.checkFallbackAge(header); err != nil {
+		return header, err
+	}
+	// читаем в новый экземпляр и подменяем appCfg только целиком прочитанным конфигом: декодер,
+	// упавший на середине, не оставит в appCfg половины сохраненного, а gob, который не пишет
+	// нулевые значения, - значений, оставшихся от текущего
+	decoded := newAppConfig(appCfg)
+	if l.mergesFallback(header, versioned) {
+		copyInto(decoded, appCfg)
+	}
+	if err := l.decodeFallback(header, versioned, payload, decoded); err != nil {
+		return header, errors.Wrap(err, "failed to decode fallback config")
+	}
+	// секреты из внешних хранилищ не сохраняются, запрашиваем их заново
+	if err := l.resolveSecrets(ctx, decoded); err != nil {
+		return header, errors.Wrap(err, "failed to resolve secrets of fallback config")
+	}
+	l.overrideFlags(decoded)
+	// производные поля могли не сохраниться, заполняем их так же, как у конфига из источника
+	if err := l.afterLoad(decoded); err != nil {
+		return header, errors.Wrap(err, "failed to prepare fallback config")
+	}
+	copyInto(appCfg, decoded)
+	return header, nil
+}
+
+// сохраняет конфиг cfg как рабочий
+func (l *AppLoader) saveConfig(ctx context.Context, cfg *Config) error {
+	// сдерживающий новый конфиг экземпляр не должен затереть в хранилище конфиг, сохраненный канарейками
+	if cfg.UsesFallbackConfig || cfg.CanaryHeld {
+		return nil
+	}
+	leader, err := l.canSave(ctx)
+	if err != nil {
+		l.log.Error("failed to save config", "error", err)
+		l.events.OnConfigSaved(err)
+		return phaseError(ctx, phaseSave, err)
+	}
+	if !leader {
+		return nil
+	}
+	saved, err := l.savedForm(cfg.App)
+	if errors.Is(err, ErrSkipSave) {
+		l.log.Info("config is not saved: skipped by before save hook")
+		return nil
+	}
+	if err != nil {
+		err = errors.Wrap(err, "before save hook failed")
+		l.log.Error("failed to save config", "error", err)
+		l.events.OnConfigSaved(err)
+		return phaseError(ctx, phaseSave, err)
+	}
+	payload, err := l.codec.Encode(saved)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode config")
+	}
+	// не перезаписываем конфиг, если он не поменялся с прошлого запуска,
+	// иначе одинаковые записи вытеснят из истории более старые рабочие конфиги.
+	// Исключение - время сохранения подходит к LOADER_FALLBACK_MAX_AGE
+	var timeouts *loaderTimeouts
+	if cfg.FallbackLoaderConfig {
+		t := timeoutsOf(&cfg.LoaderConfig)
+		timeouts = &t
+	}
+	if last, err := loadFromStore(ctx, l.store); err == nil {
+		header, lastPayload, versioned, err := decodeSnapshot(last)
+		if err == nil && versioned && header.Schema == l.schema && bytes.Equal(lastPayload, payload) &&
+			reflect.DeepEqual(header.Timeouts, timeouts) && !l.needsRefresh(header) {
+			return nil
+		}
+	}
+
+	now := time.Now().UTC()
+	data, err := encodeSnapshot(snapshotHeader{Schema: l.schema, SavedAt: &now, Timeouts: timeouts}, payload)
+	if err != nil {
+		return err
+	}
+	if err := saveToStore(ctx, l.store, data); err != nil {
+		l.log.Error("failed to save config", "error", err)
+		l.events.OnConfigSaved(err)
+		return phaseError(ctx, phaseSave, err)
+	}
+	l.events.OnConfigSaved(nil)
+	l.metrics.saves.Inc()
+	l.log.Info("config saved", "schema", l.schema)
+	return nil
+}
+
+// реализация ConfigProvider
+// Возвращается копия: конфиг приложения в ней можно менять, не задевая текущий,
+// и во время hot reload она не может оказаться наполовину обновленной.
+func (l *AppLoader) Config() Config {
+	cfg := l.snapshot.Load()
+	if cfg == nil {
+		// загрузчик еще собирает приложение (например, Config вызван из конструктора)
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		cfg = l.cfg
+	}
+	return DeepCopy(*cfg)
+}
+
+// storeConfig подменяет текущий конфиг, вызывается под mu.
+// Переданный cfg после этого менять нельзя.
+func (l *AppLoader) storeConfig(cfg *Config) {
+	l.cfg = cfg
+	l.snapshot.Store(cfg)
+}
+
+func (l *AppLoader) currentApp() *fx.App {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.app
+}
+
+// Start запускает собранное приложение и ждет его завершения.
+// После старта при включенном LOADER_WATCH начинает следить за изменениями конфига,
+// а при LOADER_RELOAD_ON_SIGHUP - перечитывать конфиг по SIGHUP.
+// При заданном LOADER_ADMIN_ADDR на это время поднимается админка загрузчика (см. AdminHandler).
+// Если LoadApp не нашел рабочего конфига и включен LOADER_HOLD_ON_FAILURE, Start сначала ждет,
+// пока такой конфиг появится, и запускает приложение уже со своим таймаутом LOADER_START_TIMEOUT вместо ctx.
+// Возвращает nil по сигналу остановки (см. Done), а само приложение после этого останавливает Stop или Run.
+func (l *AppLoader) Start(ctx context.Context) error {
+	if l.shutdown.isRequested() {
+		return nil
+	}
+	if addr := l.Config().AdminAddr; addr != "" {
+		admin, err := serveHTTP(addr, l.AdminHandler(), l.adminTLS)
+		if err != nil {
+			return errors.Wrap(err, "failed to start admin server")
+		}
+		defer admin.Close()
+	}
+
+	app := l.currentApp()
+	if app == nil {
+		var err error
+		if app, err = l.hold(); err != nil {
+			l.writeStartupReport(nil, err)
+			return err
+		}
+		// ctx мог истечь, пока ждали конфиг
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), l.Config().StartTimeout)
+		defer cancel()
+	}
+	startErr := make(chan error, 1)
+
+	cur := l.Config()
+	l.beginStart(&cur, app)
+	ctx, span := l.startSpan(ctx, spanStart, fallbackAttrs(&cur)...)
+	// спан закрывается, когда приложение стартовало, или вместе с Start, если тот вернулся раньше
+	spanEnded := false
+	defer func() {
+		if !spanEnded {
+			span.End()
+		}
+	}()
+	go func(app *fx.App) {
+		startErr <- app.Start(ctx)
+	}(app)
+
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	l.mu.Lock()
+	l.runCtx = watchCtx
+	l.mu.Unlock()
+	defer l.health.setRunning(false)
+
+	done := app.Done()
+	for {
+		select {
+		case err := <-startErr:
+			if err != nil {
+				failed := l.Config()
+				l.events.OnAppStartFailed(failed, err)
+				l.audit(auditAction(&failed), &failed, nil, err)
+				span.RecordError(err)
+				if app, err = l.startOnFallback(app, err); err != nil {
+					endSpan(span, err)
+					spanEnded = true
+					l.writeStartupReport(nil, err)
+					return err
+				}
+				done = app.Done()
+			}
+			startErr = nil
+			l.health.setRunning(true)
+			cfg := l.Config()
+			l.audit(auditAction(&cfg), &cfg, nil, nil)
+			span.SetAttributes(fallbackAttrs(&cfg)...)
+			endSpan(span, nil)
+			spanEnded = true
+			// конфиг становится рабочим, только когда приложение с ним стартовало
+			if err := l.confirmConfig(&cfg, app); err != nil {
+				l.writeStartupReport(app, err)
+				return err
+			}
+			l.writeStartupReport(app, nil)
+			if cfg.Watch {
+				if ns, ok := l.source.(NotifyingSource); ok {
+					go l.watchNotifications(watchCtx, ns)
+				} else {
+					go l.watch(watchCtx)
+				}
+			}
+			if cfg.ReloadOnSighup {
+				go l.reloadOnSighup(watchCtx)
+			}
+			if cfg.SDNotify {
+				go l.notifySystemd(watchCtx)
+			}
+			if l.canaryHoldback {
+				go l.watchCanary(watchCtx)
+			}
+			if l.flags.source != nil {
+				go l.watchFlags(watchCtx)
+			}
+		case sig := <-done:
+			l.shutdown.notify(sig)
+			return nil
+		case <-l.shutdown.requested():
+			return nil
+		case <-l.swapped:
+			// приложение пересобрано, дальше ждем завершения нового
+			app = l.currentApp()
+			done = app.Done()
+		case err := <-l.failed:
+			return err
+		}
+	}
+}
+
+// startOnFallback вызывается, когда app не стартовало. Если OnStart хук вернул ErrBadConfig
+// (например, порт из конфига занят), останавливает app, собирает приложение на сохраненном
+// рабочем конфиге и запускает его. Иначе возвращает исходную ошибку старта.
+func (l *AppLoader) startOnFallback(app *fx.App, startErr error) (*fx.App, error) {
+	badErr, ok := unwrapBadConfigError(l.classifyBadConfig(startErr))
+	cur := l.Config()
+	if !ok || cur.UsesFallbackConfig {
+		return nil, startErr
+	}
+	if cur.Strict {
+		return nil, withClass(ErrFallbackIgnored, errors.Wrap(badErr, "bad config in strict mode"))
+	}
+	l.log.Error("app failed to start with bad config, trying fallback config", "error", badErr)
+
+	// fx уже откатил выполненные OnStart хуки, Stop освобождает остальное
+	stopCtx, cancel := context.WithTimeout(context.Background(), cur.StopTimeout)
+	_ = l.stopApp(stopCtx, app)
+	cancel()
+
+	loadCtx, cancel := context.WithTimeout(context.Background(), cur.LoadTimeout)
+	defer cancel()
+	cfg := cur
+	fallback, err := l.buildFallback(loadCtx, &cfg, badErr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "app failed to start with bad config: %s", badErr)
+	}
+	l.setCurrent(&cfg, fallback)
+
+	startCtx, cancel := context.WithTimeout(context.Background(), cfg.StartTimeout)
+	defer cancel()
+	if err := fallback.Start(startCtx); err != nil {
+		l.events.OnAppStartFailed(cfg, err)
+		return nil, errors.Wrap(err, "failed to start app with fallback config")
+	}
+	l.log.Info("app started with fallback config", "index", cfg.FallbackIndex)
+	l.subs.notify(cfg)
+	return fallback, nil
+}
+
+// saveStarted сохраняет конфиг cfg, с которым стартовало app, как рабочий
+func (l *AppLoader) saveStarted(cfg *Config, app *fx.App) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.LoadTimeout)
+	defer cancel()
+	if err := l.saveConfig(ctx, cfg); err != nil {
+		return errors.Wrap(err, "failed to save current config")
+	}
+	l.mu.Lock()
+	l.confirmed = app
+	l.mu.Unlock()
+	l.promoted.Store(true)
+	return nil
+}
+
+// Run запускает приложение, ждет SIGINT/SIGTERM (или вызова fx.Shutdowner, Stop)
+// и останавливает приложение за LOADER_STOP_TIMEOUT, чтобы отработали OnStop хуки.
+// Возвращает ошибки запуска, работы и остановки вместе, код выхода процесса вместо них возвращает RunCode.
+func (l *AppLoader) Run() error {
+	err, stopErr := l.run()
+	if stopErr != nil {
+		err = multierr.Append(err, errors.Wrap(stopErr, "failed to stop app"))
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package loader
+
+import (
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// флаг командной строки с файлом настроек загрузчика, см. WithLoaderConfigFile
+const loaderConfigFlag = "loader-config"
+
+// loaderConfigFileFromArgs ищет в args --loader-config=path или --loader-config path
+func loaderConfigFileFromArgs(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if name == loaderConfigFlag && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, loaderConfigFlag+"=") {
+			return strings.TrimPrefix(name, loaderConfigFlag+"=")
+		}
+	}
+	return ""
+}
+
+// lookupLoaderEnv ищет переменную загрузчика key, например LOADER_STRICT, с префиксом из WithLoaderEnvPrefix.
+// Поля без тега envconfig читаются, как у envconfig, из LOADER_<ИМЯ ПОЛЯ>
+func (l *AppLoader) lookupLoaderEnv(key string) (string, bool) {
+	prefix := l.loaderPrefix
+	if prefix == "" {
+		prefix = loaderConfigPrefix
+	}
+	name := strings.TrimPrefix(key, loaderConfigPrefix+"_")
+	return os.LookupEnv(strings.ToUpper(prefix) + "_" + name)
+}
+
+// loadLoaderConfigFile читает настройки загрузчика из файла --loader-config или WithLoaderConfigFile.
+// Ключи - имена переменных LOADER_* в нижнем регистре, префикс loader_ можно не писать.
+// Поля, которых нет в файле, остаются как были заданы опциями
+func (l *AppLoader) loadLoaderConfigFile() error {
+	path := loaderConfigFileFromArgs(os.Args[1:])
+	if path == "" {
+		path = l.loaderConfigFile
+	}
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read loader config file")
+	}
+	values, err := parseConfigFile(data, NewFileSource(path).format)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse loader config file %s", path)
+	}
+	for key, value := range values {
+		if !strings.HasPrefix(strings.ToLower(key), "loader_") {
+			delete(values, key)
+			values["loader_"+key] = value
+		}
+	}
+	if err := decodeValues(values, reflect.ValueOf(&l.cfg.LoaderConfig).Elem(), ""); err != nil {
+		return errors.Wrapf(err, "invalid loader config file %s", path)
+	}
+	return nil
+}
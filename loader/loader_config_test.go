@@ -0,0 +1,34 @@
+package loader
+
+import "testing"
+
+func TestLoaderEnvIgnoresRuntimeFields(t *testing.T) {
+	for _, prefix := range []string{"", "MYAPP"} {
+		t.Run("prefix "+prefix, func(t *testing.T) {
+			strict := "LOADER_STRICT"
+			if prefix != "" {
+				strict = prefix + "_STRICT"
+			}
+			t.Setenv(strict, "true")
+			// состояние загрузчика не задается ни голыми переменными, ни переменными с префиксом
+			for _, key := range []string{"USESFALLBACKCONFIG", "FALLBACKINDEX", "CONFIGERROR", "CONFIGERRORFIELDS"} {
+				t.Setenv(key, "1")
+				t.Setenv("LOADER_"+key, "1")
+				t.Setenv("MYAPP_"+key, "1")
+			}
+
+			l := &AppLoader{cfg: &Config{}, loaderPrefix: prefix}
+			if err := l.initLoaderConfigFromEnv(); err != nil {
+				t.Fatal(err)
+			}
+			cfg := l.cfg.LoaderConfig
+			if !cfg.Strict {
+				t.Errorf("%s is not read", strict)
+			}
+			if cfg.UsesFallbackConfig || cfg.FallbackIndex != 0 || cfg.ConfigError != "" || cfg.ConfigErrorFields != nil {
+				t.Errorf("runtime fields read from env: fallback %v, index %d, error %q, fields %v",
+					cfg.UsesFallbackConfig, cfg.FallbackIndex, cfg.ConfigError, cfg.ConfigErrorFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type fallbackTestConfig struct {
+	Host    string            `json:"host"`
+	Port    int               `json:"port"`
+	Tags    []string          `json:"tags"`
+	Limits  map[string]int    `json:"limits"`
+	Backend *fallbackTestNode `json:"backend"`
+}
+
+type fallbackTestNode struct {
+	Addr string `json:"addr"`
+}
+
+// halfCodec читает первую половину полей сохраненного конфига и падает, как декодер на поврежденных данных
+type halfCodec struct {
+	JSONCodec
+}
+
+func (halfCodec) Decode(data []byte, v interface{}) error {
+	cfg := v.(*fallbackTestConfig)
+	cfg.Host = "saved"
+	cfg.Tags = append(cfg.Tags, "saved")
+	if cfg.Limits == nil {
+		cfg.Limits = map[string]int{}
+	}
+	cfg.Limits["saved"] = 1
+	return errors.New("unexpected end of input")
+}
+
+func TestApplyFallbackConfigPartialDecode(t *testing.T) {
+	for _, tc := range []struct {
+		mismatch string
+		schema   string
+	}{
+		{SchemaMismatchReject, "current"},
+		// сохраненный конфиг другой схемы читается поверх текущего
+		{SchemaMismatchMerge, "previous"},
+	} {
+		tc := tc
+		t.Run(tc.mismatch, func(t *testing.T) {
+			l := &AppLoader{
+				cfg:    &Config{LoaderConfig: LoaderConfig{SchemaMismatch: tc.mismatch}},
+				codec:  halfCodec{},
+				log:    NopLogger(),
+				schema: "current",
+			}
+			live := &fallbackTestConfig{
+				Host:    "live",
+				Port:    8080,
+				Tags:    []string{"live"},
+				Limits:  map[string]int{"live": 1},
+				Backend: &fallbackTestNode{Addr: "live:80"},
+			}
+			want := DeepCopy(live)
+
+			payload, err := JSONCodec{}.Encode(&fallbackTestConfig{Host: "saved", Port: 9090})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := encodeSnapshot(snapshotHeader{Schema: tc.schema}, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := l.applyFallbackConfig(context.Background(), data, live); err == nil {
+				t.Fatal("applyFallbackConfig succeeded with failed decode")
+			}
+			if !reflect.DeepEqual(live, want) {
+				t.Errorf("live config changed by failed decode: %+v, want %+v", live, want)
+			}
+		})
+	}
+}
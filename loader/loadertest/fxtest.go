@@ -0,0 +1,26 @@
+package loadertest
+
+import (
+	"testing"
+
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+
+	"github.com/sgrishanin/fx-rollback-proto/loader"
+)
+
+// NewApp выбирает конфиг для app так же, как загрузчик при старте процесса, и собирает
+// приложение на нем через fxtest.New. OnStart и OnStop хуки запускаются через RequireStart
+// и RequireStop с таймаутами теста, а ошибки сборки и хуков валят тест.
+// cfg - указатель на конфиг приложения; если он плохой, приложение собирается на сохраненном
+// конфиге из хранилища, которое можно передать в opts через loader.WithFallbackStore.
+// Конструкторы приложения вызываются дважды: загрузчиком при выборе конфига и fxtest
+func NewApp(tb testing.TB, app fx.Option, cfg interface{}, opts ...loader.Option) *fxtest.App {
+	tb.Helper()
+	base := baseOptions(app, cfg, NewSource(cfg), loader.NewMemoryStore(1), newTestLogger(tb))
+	l, err := loader.New(append(base, opts...)...)
+	if err != nil {
+		tb.Fatalf("loadertest: failed to load app: %v", err)
+	}
+	return fxtest.New(tb, l.AppOptions())
+}
@@ -0,0 +1,338 @@
+// Package loadertest помогает тестировать приложения, которые запускаются через loader,
+// без переменных окружения и файлов: конфиг приходит из Source, рабочие конфиги хранятся
+// в памяти, а события загрузчика записываются в Recorder.
+//
+//	h := loadertest.New(t, ProvideApp(), &SomeAppConfig{Server: ServerConfig{Host: "localhost", Port: 8080}})
+//	h.Reload(&SomeAppConfig{Server: ServerConfig{Port: 1}})
+//	h.AssertFallback(true)
+//	h.AssertApplied(&SomeAppConfig{Server: ServerConfig{Host: "localhost", Port: 8080}})
+package loadertest
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+	"go.uber.org/multierr"
+
+	"github.com/sgrishanin/fx-rollback-proto/loader"
+)
+
+// сколько Harness ждет, пока приложение стартует и его конфиг станет рабочим
+const defaultWaitTimeout = time.Second * 10
+
+// Source - источник конфига для тестов, отдает то, что в него положили через Set или Fail
+type Source struct {
+	mu  sync.Mutex
+	cfg interface{}
+	err error
+}
+
+// NewSource создает источник, который отдает cfg - указатель на конфиг того же типа, что и у приложения
+func NewSource(cfg interface{}) *Source {
+	return &Source{cfg: cfg}
+}
+
+func (s *Source) String() string {
+	return "loadertest"
+}
+
+// Set подменяет конфиг, который вернет следующий Load
+func (s *Source) Set(cfg interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+	s.err = nil
+}
+
+// Fail заставляет следующие Load возвращать err. Чтобы загрузчик счел конфиг плохим
+// и откатился, err должна быть loader.ErrBadConfig, например loader.BadField
+func (s *Source) Fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *Source) Load(dst interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	src := reflect.ValueOf(s.cfg)
+	to := reflect.ValueOf(dst)
+	if src.Kind() != reflect.Ptr || to.Kind() != reflect.Ptr || src.Type() != to.Type() {
+		return errors.Errorf("loadertest: source config is %T, app config is %T", s.cfg, dst)
+	}
+	to.Elem().Set(src.Elem())
+	return nil
+}
+
+// события загрузчика, которые записывает Recorder
+const (
+	EventConfigLoaded   = "config_loaded"
+	EventFallback       = "fallback_applied"
+	EventAppBuilt       = "app_built"
+	EventAppStartFailed = "app_start_failed"
+	EventConfigSaved    = "config_saved"
+)
+
+// Event - одно событие загрузчика, см. loader.Events
+type Event struct {
+	Name string
+	// источник для EventConfigLoaded
+	Source string
+	// конфиг для EventFallback, EventAppBuilt и EventAppStartFailed
+	Config loader.Config
+	Err    error
+}
+
+// Recorder записывает события загрузчика по порядку
+type Recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+var _ loader.Events = (*Recorder)(nil)
+
+func (r *Recorder) add(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *Recorder) OnConfigLoaded(source string, err error) {
+	r.add(Event{Name: EventConfigLoaded, Source: source, Err: err})
+}
+
+func (r *Recorder) OnFallbackApplied(cfg loader.Config) {
+	r.add(Event{Name: EventFallback, Config: cfg})
+}
+
+func (r *Recorder) OnAppBuilt(cfg loader.Config, _ time.Duration, err error) {
+	r.add(Event{Name: EventAppBuilt, Config: cfg, Err: err})
+}
+
+func (r *Recorder) OnAppStartFailed(cfg loader.Config, err error) {
+	r.add(Event{Name: EventAppStartFailed, Config: cfg, Err: err})
+}
+
+func (r *Recorder) OnConfigSaved(err error) {
+	r.add(Event{Name: EventConfigSaved, Err: err})
+}
+
+// Events возвращает записанные события
+func (r *Recorder) Events() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event{}, r.events...)
+}
+
+// Names возвращает имена записанных событий, удобно сравнивать с ожидаемой последовательностью
+func (r *Recorder) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.events))
+	for _, e := range r.events {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+// Reset забывает записанные события
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = nil
+}
+
+// Harness запускает приложение через loader на Source и хранилище в памяти.
+// Хранилище переживает Restart, так что можно проверить, на каком конфиге поднимется
+// следующий запуск процесса. Приложение останавливается, когда закончился тест
+type Harness struct {
+	tb   testing.TB
+	app  fx.Option
+	opts []loader.Option
+
+	Source *Source
+	Store  *loader.MemoryStore
+	Events *Recorder
+	// загрузчик текущего запуска, меняется при Restart
+	Loader *loader.AppLoader
+
+	log  *testLogger
+	mu   sync.Mutex
+	done chan error
+}
+
+// New запускает приложение app с конфигом cfg и ждет, пока конфиг станет рабочим.
+// cfg - указатель на конфиг приложения, как в loader.WithAppConfig.
+// opts добавляются к опциям загрузчика, например loader.WithValidator
+func New(tb testing.TB, app fx.Option, cfg interface{}, opts ...loader.Option) *Harness {
+	tb.Helper()
+	h := &Harness{
+		tb:     tb,
+		app:    app,
+		opts:   opts,
+		Source: NewSource(cfg),
+		Store:  loader.NewMemoryStore(1),
+		Events: &Recorder{},
+		log:    newTestLogger(tb),
+	}
+	tb.Cleanup(func() {
+		if err := h.Stop(); err != nil {
+			tb.Errorf("loadertest: failed to stop app: %v", err)
+		}
+	})
+	if err := h.Restart(cfg); err != nil {
+		tb.Fatalf("loadertest: failed to start app: %v", err)
+	}
+	return h
+}
+
+// Restart останавливает приложение и запускает его заново с конфигом cfg, как новый процесс.
+// Если cfg плохой, приложение поднимется на сохраненном рабочем конфиге
+func (h *Harness) Restart(cfg interface{}) error {
+	h.tb.Helper()
+	if err := h.Stop(); err != nil {
+		return err
+	}
+	h.Source.Set(cfg)
+	opts := append(baseOptions(h.app, cfg, h.Source, h.Store, h.log),
+		loader.WithEvents(h.Events))
+	l, err := loader.New(append(opts, h.opts...)...)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	h.mu.Lock()
+	h.Loader = l
+	h.done = done
+	h.mu.Unlock()
+	go func() {
+		done <- l.Run()
+	}()
+	return h.waitConfirmed(l, done)
+}
+
+// waitConfirmed ждет, пока приложение стартует и его конфиг станет рабочим
+func (h *Harness) waitConfirmed(l *loader.AppLoader, done chan error) error {
+	deadline := time.Now().Add(defaultWaitTimeout)
+	for {
+		select {
+		case err := <-done:
+			// Run закончился раньше, чем приложение стартовало
+			done <- err
+			if err == nil {
+				return errors.New("app stopped before its config became known-good")
+			}
+			return err
+		default:
+		}
+		if l.Health().Status != loader.HealthUnavailable && !l.Status().PendingConfirmation {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf("app did not start in %s: %+v", defaultWaitTimeout, l.Health())
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// Stop останавливает приложение и возвращает ошибку его работы
+func (h *Harness) Stop() error {
+	h.mu.Lock()
+	done, l := h.done, h.Loader
+	h.done = nil
+	h.mu.Unlock()
+	if done == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultWaitTimeout)
+	defer cancel()
+	stopErr := l.Stop(ctx)
+	return multierr.Append(<-done, stopErr)
+}
+
+// Reload кладет в источник конфиг cfg и перезагружает с ним приложение
+func (h *Harness) Reload(cfg interface{}) error {
+	h.Source.Set(cfg)
+	return h.Loader.Reload()
+}
+
+// Rollback откатывает приложение на сохраненный рабочий конфиг, см. AppLoader.Rollback
+func (h *Harness) Rollback() error {
+	return h.Loader.Rollback()
+}
+
+// Applied возвращает конфиг, с которым сейчас работает приложение
+func (h *Harness) Applied() loader.Config {
+	return h.Loader.Config()
+}
+
+// AssertApplied проверяет, что приложение работает с конфигом приложения want
+func (h *Harness) AssertApplied(want interface{}) {
+	h.tb.Helper()
+	if got := h.Applied().App; !reflect.DeepEqual(got, want) {
+		h.tb.Errorf("loadertest: applied config is %+v, want %+v", got, want)
+	}
+}
+
+// AssertFallback проверяет, работает ли приложение на откаченном конфиге
+func (h *Harness) AssertFallback(want bool) {
+	h.tb.Helper()
+	if cfg := h.Applied(); cfg.UsesFallbackConfig != want {
+		h.tb.Errorf("loadertest: uses fallback config is %v, want %v (config error: %q)", cfg.UsesFallbackConfig, want, cfg.ConfigError)
+	}
+}
+
+// baseOptions - опции загрузчика, общие для Harness и NewApp
+func baseOptions(app fx.Option, cfg interface{}, source *Source, store loader.FallbackStore, log loader.Logger) []loader.Option {
+	return []loader.Option{
+		loader.WithApp(app),
+		loader.WithAppConfig(reflect.New(reflect.TypeOf(cfg).Elem()).Interface()),
+		loader.WithConfigSource(source),
+		loader.WithFallbackStore(store),
+		loader.WithLogger(log),
+		loader.WithTimeouts(defaultWaitTimeout, defaultWaitTimeout),
+	}
+}
+
+// testLogger пишет логи загрузчика в лог теста. Фоновые проверки загрузчика могут
+// написать что-то и после конца теста, а testing на это паникует, поэтому такие логи теряются
+type testLogger struct {
+	tb       testing.TB
+	mu       sync.Mutex
+	finished bool
+}
+
+func newTestLogger(tb testing.TB) *testLogger {
+	l := &testLogger{tb: tb}
+	tb.Cleanup(func() {
+		l.mu.Lock()
+		l.finished = true
+		l.mu.Unlock()
+	})
+	return l
+}
+
+func (l *testLogger) Info(msg string, kv ...interface{}) {
+	l.log(append([]interface{}{msg}, kv...))
+}
+
+func (l *testLogger) Error(msg string, kv ...interface{}) {
+	l.log(append([]interface{}{"ERROR", msg}, kv...))
+}
+
+func (l *testLogger) log(args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.finished {
+		l.tb.Log(args...)
+	}
+}
@@ -0,0 +1,103 @@
+package loader
+
+import (
+	"strings"
+
+	"go.uber.org/fx/fxevent"
+	"go.uber.org/zap"
+)
+
+// Logger - логгер загрузчика, kv - пары ключ-значение.
+// *slog.Logger подходит под интерфейс как есть, для zap есть ZapLogger.
+// Через этот же логгер пишутся события fx.
+type Logger interface {
+	Info(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+}
+
+// ZapLogger оборачивает zap логгер в Logger
+func ZapLogger(l *zap.Logger) Logger {
+	return zapLogger{l.WithOptions(zap.AddCallerSkip(1)).Sugar()}
+}
+
+type zapLogger struct {
+	s *zap.SugaredLogger
+}
+
+func (l zapLogger) Info(msg string, kv ...interface{})  { l.s.Infow(msg, kv...) }
+func (l zapLogger) Error(msg string, kv ...interface{}) { l.s.Errorw(msg, kv...) }
+
+// NopLogger ничего не пишет
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// по умолчанию логи пишутся в stderr в json
+func defaultLogger() Logger {
+	cfg := zap.NewProductionConfig()
+	cfg.DisableStacktrace = true
+	l, err := cfg.Build()
+	if err != nil {
+		return NopLogger()
+	}
+	return ZapLogger(l)
+}
+
+// fxLogger пишет события fx в Logger загрузчика
+type fxLogger struct {
+	log     Logger
+	modules *moduleTracker
+}
+
+func (l fxLogger) LogEvent(event fxevent.Event) {
+	l.modules.LogEvent(event)
+	switch e := event.(type) {
+	case *fxevent.OnStartExecuted:
+		if e.Err != nil {
+			l.log.Error("OnStart hook failed", "callee", e.FunctionName, "caller", e.CallerName, "error", e.Err)
+		} else {
+			l.log.Info("OnStart hook executed", "callee", e.FunctionName, "caller", e.CallerName, "runtime", e.Runtime.String())
+		}
+	case *fxevent.OnStopExecuted:
+		if e.Err != nil {
+			l.log.Error("OnStop hook failed", "callee", e.FunctionName, "caller", e.CallerName, "error", e.Err)
+		} else {
+			l.log.Info("OnStop hook executed", "callee", e.FunctionName, "caller", e.CallerName, "runtime", e.Runtime.String())
+		}
+	case *fxevent.Supplied:
+		if e.Err != nil {
+			l.log.Error("supply failed", "type", e.TypeName, "error", e.Err)
+		}
+	case *fxevent.Provided:
+		if e.Err != nil {
+			l.log.Error("provide failed", "constructor", e.ConstructorName, "error", e.Err)
+		}
+	case *fxevent.Invoked:
+		if e.Err != nil {
+			l.log.Error("invoke failed", "function", e.FunctionName, "error", e.Err)
+		}
+	case *fxevent.Stopping:
+		l.log.Info("received signal", "signal", strings.ToUpper(e.Signal.String()))
+	case *fxevent.Stopped:
+		if e.Err != nil {
+			l.log.Error("stop failed", "error", e.Err)
+		}
+	case *fxevent.RollingBack:
+		l.log.Error("start failed, rolling back", "error", e.StartErr)
+	case *fxevent.RolledBack:
+		if e.Err != nil {
+			l.log.Error("rollback failed", "error", e.Err)
+		}
+	case *fxevent.Started:
+		if e.Err != nil {
+			l.log.Error("start failed", "error", e.Err)
+		} else {
+			l.log.Info("started")
+		}
+	}
+}
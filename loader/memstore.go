@@ -0,0 +1,148 @@
+package loader
+
+import (
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// хранилища, из которых выбирает LOADER_FALLBACK_STORE
+const (
+	// файл LOADER_FALLBACK_PATH
+	FallbackStoreFile = "file"
+	// память процесса, см. MemoryStore
+	FallbackStoreMemory = "memory"
+)
+
+// MemoryStore хранит depth последних конфигов в памяти процесса.
+// Откат при hot reload с ним работает, но после перезапуска процесса сохраненного конфига нет
+type MemoryStore struct {
+	mu      sync.Mutex
+	depth   int
+	history [][]byte
+}
+
+func NewMemoryStore(depth int) *MemoryStore {
+	if depth < 1 {
+		depth = 1
+	}
+	return &MemoryStore{depth: depth}
+}
+
+var (
+	_ HistoryStore = (*MemoryStore)(nil)
+	_ DeleteStore  = (*MemoryStore)(nil)
+)
+
+func (s *MemoryStore) Save(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = append([][]byte{append([]byte{}, data...)}, s.history...)
+	if len(s.history) > s.depth {
+		s.history = s.history[:s.depth]
+	}
+	return nil
+}
+
+func (s *MemoryStore) Load() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.history) == 0 {
+		return nil, ErrFallbackNotFound
+	}
+	return s.history[0], nil
+}
+
+func (s *MemoryStore) LoadHistory(n int) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n > len(s.history) {
+		n = len(s.history)
+	}
+	return append([][]byte{}, s.history[:n]...), nil
+}
+
+func (s *MemoryStore) Delete() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.history) == 0 {
+		return ErrFallbackNotFound
+	}
+	s.history = s.history[1:]
+	return nil
+}
+
+// degradingStore - файловое хранилище по умолчанию, которое переходит на память,
+// если файл записать нельзя (read-only файловая система, нет прав).
+// Конфиги, которые уже лежат в файле, например положенные в образ, по-прежнему читаются
+type degradingStore struct {
+	file   *FileStore
+	memory *MemoryStore
+	log    Logger
+
+	mu       sync.Mutex
+	degraded bool
+}
+
+func newDegradingStore(file *FileStore, depth int, log Logger) *degradingStore {
+	return &degradingStore{file: file, memory: NewMemoryStore(depth), log: log}
+}
+
+var (
+	_ HistoryStore = (*degradingStore)(nil)
+	_ DeleteStore  = (*degradingStore)(nil)
+)
+
+func (s *degradingStore) isDegraded() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.degraded
+}
+
+func (s *degradingStore) Save(data []byte) error {
+	if !s.isDegraded() {
+		err := s.file.Save(data)
+		if err == nil || !isReadOnly(err) {
+			return err
+		}
+		s.mu.Lock()
+		s.degraded = true
+		s.mu.Unlock()
+		s.log.Error("fallback path is not writable, fallback config is kept in memory only", "path", s.file.path, "error", err)
+	}
+	return s.memory.Save(data)
+}
+
+func (s *degradingStore) Load() ([]byte, error) {
+	if data, err := s.memory.Load(); err == nil {
+		return data, nil
+	}
+	return s.file.Load()
+}
+
+// LoadHistory возвращает сначала конфиги из памяти, затем из файла
+func (s *degradingStore) LoadHistory(n int) ([][]byte, error) {
+	history, _ := s.memory.LoadHistory(n)
+	if len(history) >= n {
+		return history, nil
+	}
+	fromFile, err := s.file.LoadHistory(n - len(history))
+	if err != nil {
+		return nil, err
+	}
+	return append(history, fromFile...), nil
+}
+
+func (s *degradingStore) Delete() error {
+	if err := s.memory.Delete(); err != ErrFallbackNotFound {
+		return err
+	}
+	return s.file.Delete()
+}
+
+// isReadOnly сообщает, что ошибка записи вызвана read-only файловой системой или правами
+func isReadOnly(err error) bool {
+	return errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EROFS)
+}
@@ -0,0 +1,86 @@
+package loader
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// что делает LayeredSource, если поле задали несколько слоев (LOADER_MERGE_CONFLICT, тег merge)
+const (
+	// значение берется из последнего слоя, задавшего поле
+	MergeLastWins = "last_wins"
+	// разные значения в разных слоях - плохой конфиг; одинаковые значения разрешены
+	MergeError = "error"
+)
+
+// mergeTag переопределяет политику для поля и всех вложенных в него полей
+const mergeTag = "merge"
+
+func (l *AppLoader) initMergeConflict() error {
+	switch l.cfg.MergeConflict {
+	case "":
+		l.cfg.MergeConflict = MergeLastWins
+	case MergeLastWins, MergeError:
+	default:
+		return errors.Errorf("unknown merge conflict policy %q", l.cfg.MergeConflict)
+	}
+	return nil
+}
+
+// mergePolicies возвращает политику каждого поля cfg: из тега merge поля или родителя, иначе policy
+func mergePolicies(cfg interface{}, policy string) (map[string]string, error) {
+	switch policy {
+	case "", MergeLastWins:
+		policy = MergeLastWins
+	case MergeError:
+	default:
+		return nil, errors.Errorf("unknown merge conflict policy %q", policy)
+	}
+	policies := map[string]string{}
+	var err error
+	walkFields(cfg, func(f configField) {
+		p, ok := policies[parentPath(f.Path)]
+		if !ok {
+			p = policy
+		}
+		switch tag := f.Field.Tag.Get(mergeTag); tag {
+		case "":
+		case MergeLastWins, MergeError:
+			p = tag
+		default:
+			if err == nil {
+				err = errors.Errorf("field %s: unknown merge policy %q", f.Path, tag)
+			}
+		}
+		policies[f.Path] = p
+	})
+	return policies, err
+}
+
+// layerFields возвращает поля, которые задал слой src: те, что он изменил, и те, что он задал
+// тем же значением, если источник сообщает об этом через originSource
+func layerFields(src ConfigSource, before, after map[string]flatValue, cfg interface{}) []string {
+	var set map[string]ValueOrigin
+	if s, ok := src.(originSource); ok {
+		set = s.origins(cfg)
+	}
+	var paths []string
+	for path, v := range after {
+		if _, ok := set[path]; ok || !reflect.DeepEqual(before[path].value, v.value) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// mergeConflict - поле path, заданное слоем prev, слой src задал другим значением.
+// Значения в ошибку не попадают: в разных слоях могут лежать разные секреты
+func mergeConflict(path, prev, src string) FieldError {
+	return FieldError{
+		Field:  path,
+		Code:   CodeConflict,
+		Reason: fmt.Sprintf("set to different values by %s and %s", prev, src),
+	}
+}
@@ -0,0 +1,81 @@
+package loader
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// метрики загрузчика. Регистрируются в prometheus.Registerer из WithMetrics,
+// а без него - в собственном реестре, который отдает /metrics админки
+type metrics struct {
+	registerer prometheus.Registerer
+
+	loadFailures  *prometheus.CounterVec
+	buildDuration prometheus.Histogram
+	saves         prometheus.Counter
+}
+
+const (
+	// конфиг не удалось прочитать из источника
+	failureStageLoad = "load"
+	// с конфигом не удалось собрать приложение
+	failureStageBuild = "build"
+)
+
+func (l *AppLoader) initMetrics() error {
+	if l.metrics.registerer == nil {
+		l.metrics.registerer = prometheus.NewRegistry()
+	}
+	l.metrics.loadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "loader_config_load_failures_total",
+		Help: "Number of configs that could not be loaded (stage=load) or used to build the app (stage=build).",
+	}, []string{"stage"})
+	l.metrics.buildDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "loader_app_build_duration_seconds",
+		Help:    "Time spent validating config and building the fx app.",
+		Buckets: prometheus.DefBuckets,
+	})
+	l.metrics.saves = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "loader_config_saves_total",
+		Help: "Number of known-good configs written to the fallback store.",
+	})
+	fallbackInUse := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "loader_fallback_in_use",
+		Help: "1 if the app runs on a fallback config, 0 otherwise.",
+	}, func() float64 {
+		if l.Config().UsesFallbackConfig {
+			return 1
+		}
+		return 0
+	})
+	lastRollback := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "loader_last_rollback_timestamp_seconds",
+		Help: "Unix time of the last rollback to a fallback config, including previous process runs, 0 if none.",
+	}, func() float64 {
+		if reason, ok := l.LastRollback(); ok {
+			return float64(reason.At.Unix())
+		}
+		return 0
+	})
+
+	for _, c := range []prometheus.Collector{l.metrics.loadFailures, l.metrics.buildDuration, l.metrics.saves, fallbackInUse, lastRollback} {
+		if err := l.metrics.registerer.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *metrics) observeBuild(start time.Time, err error) {
+	m.buildDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.loadFailures.WithLabelValues(failureStageBuild).Inc()
+	}
+}
+
+// gatherer возвращает реестр, из которого можно отдать метрики, если он есть
+func (m *metrics) gatherer() (prometheus.Gatherer, bool) {
+	g, ok := m.registerer.(prometheus.Gatherer)
+	return g, ok
+}
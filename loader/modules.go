@@ -0,0 +1,165 @@
+package loader
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxevent"
+)
+
+// moduleTracker запоминает по событиям fx, в каком модуле объявлены конструкторы и invoke,
+// чтобы ошибку из конструктора можно было отнести к секции конфига, см. WithModule
+type moduleTracker struct {
+	mu sync.Mutex
+	// имя конструктора в формате fx -> имя модуля
+	constructors map[string]string
+	// модуль, в котором упал invoke
+	failedInvoke string
+	// сама упавшая функция invoke
+	failedFunction string
+}
+
+func newModuleTracker() *moduleTracker {
+	return &moduleTracker{constructors: map[string]string{}}
+}
+
+func (t *moduleTracker) LogEvent(event fxevent.Event) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch e := event.(type) {
+	case *fxevent.Provided:
+		if e.ModuleName != "" {
+			t.constructors[strings.TrimSuffix(e.ConstructorName, "()")] = e.ModuleName
+		}
+	case *fxevent.Invoked:
+		if e.Err != nil && t.failedFunction == "" {
+			t.failedInvoke = e.ModuleName
+			t.failedFunction = strings.TrimSuffix(e.FunctionName, "()")
+		}
+	}
+}
+
+// dig пишет упавший конструктор как "pkg/path".Name (file:line)
+var failedConstructorRegexp = regexp.MustCompile(`received non-nil error from function "([^"]+)"\.(\S+) \(`)
+
+// module возвращает модуль, в котором возникла ошибка сборки err
+func (t *moduleTracker) module(err error) string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// ошибка самого глубокого конструктора идет последней
+	if m := failedConstructorRegexp.FindAllStringSubmatch(err.Error(), -1); len(m) > 0 {
+		last := m[len(m)-1]
+		if module, ok := t.constructors[last[1]+"."+last[2]]; ok {
+			return module
+		}
+	}
+	return t.failedInvoke
+}
+
+// invokeFunction возвращает упавшую функцию invoke
+func (t *moduleTracker) invokeFunction() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.failedFunction
+}
+
+// attributeToModule относит ошибку конфига из конструктора модуля WithModule к его секции:
+// поля, которые нельзя отнести к секции, получают префикс с ее именем,
+// а ошибка без полей становится ошибкой всей секции
+func (l *AppLoader) attributeToModule(err error, modules *moduleTracker) error {
+	if len(l.sections) == 0 {
+		return err
+	}
+	badErr, ok := findBadConfig(err)
+	if !ok {
+		return err
+	}
+	if _, ok := l.badSections(badErr); ok {
+		return err
+	}
+	module := l.moduleSection(modules.module(err))
+	if _, ok := l.fieldSection(module); module == "" || !ok {
+		return err
+	}
+
+	fields := make([]FieldError, 0, len(badErr.Fields)+1)
+	for _, fe := range badErr.Fields {
+		if _, ok := l.fieldSection(fe.Field); !ok {
+			fe.Field = joinPath(module, fe.Field)
+		}
+		fields = append(fields, fe)
+	}
+	if badErr.Cause != nil {
+		fields = append(fields, FieldError{Field: module, Code: CodeInvalid, Reason: badErr.Cause.Error()})
+	} else if len(fields) == 0 {
+		fields = append(fields, FieldError{Field: module, Code: CodeInvalid, Reason: "bad config"})
+	}
+	return ErrBadConfig{Fields: fields}
+}
+
+// moduleSpec - модуль приложения со своей секцией конфига, см. WithModule и Module
+type moduleSpec struct {
+	// имя fx.Module
+	name string
+	// имя секции конфига модуля
+	section string
+	opts    []fx.Option
+}
+
+// Module добавляет в приложение fx.Module с именем name и его конфиг, как WithModule,
+// но имя секции выводится из имени модуля: paymentGateway и payment-gateway читаются
+// из APP_PAYMENT_GATEWAY_* и сохраняются под ключом payment_gateway.
+// Так модуль можно объявить в своем пакете одной опцией и подключать в разные приложения:
+//
+//	var PaymentsModule = loader.Module("payments", new(Config), fx.Provide(NewClient))
+//
+// Ошибки конфига модуля в /loader/status и логах идут с путями от секции: payment_gateway.timeout
+func Module(name string, configPtr interface{}, opts ...fx.Option) Option {
+	return func(l *AppLoader) {
+		section := sectionName(name)
+		WithConfig(section, configPtr)(l)
+		l.modules = append(l.modules, moduleSpec{name: name, section: section, opts: opts})
+	}
+}
+
+// sectionName переводит имя модуля в имя секции: слова CamelCase и все, кроме букв и цифр, разделяются _
+func sectionName(name string) string {
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, p := range parts {
+		parts[i] = splitWords(p)
+	}
+	return strings.ToLower(strings.Join(parts, "_"))
+}
+
+// moduleSection возвращает имя секции модуля с именем fx.Module module
+func (l *AppLoader) moduleSection(module string) string {
+	for _, m := range l.modules {
+		if m.name == module {
+			return m.section
+		}
+	}
+	return module
+}
+
+// moduleOptions собирает модули из WithModule и Module
+func (l *AppLoader) moduleOptions() fx.Option {
+	opts := make([]fx.Option, 0, len(l.modules))
+	for _, m := range l.modules {
+		opts = append(opts, fx.Module(m.name, m.opts...))
+	}
+	return fx.Options(opts...)
+}
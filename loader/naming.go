@@ -0,0 +1,58 @@
+package loader
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// регистр имен переменных окружения, EnvNaming.Case
+const (
+	// имена в верхнем регистре, как у envconfig
+	EnvCaseUpper = "upper"
+	EnvCaseLower = "lower"
+	// имена как в префиксе, тегах и названиях полей
+	EnvCaseKeep = "keep"
+)
+
+// EnvNaming задает, как из полей конфига строятся имена переменных окружения, см. NewEnvSourceNaming.
+// Нулевое значение дает имена envconfig: APP_ECHO_HANDLER_RESPONSE_TIMEOUT.
+// Независимо от правил тег env:"NAME" задает полное имя переменной поля без префикса и имен секций,
+// а у вложенной структуры - полный префикс ее полей
+type EnvNaming struct {
+	// разделитель между префиксом, вложенными структурами и полем, по умолчанию "_"
+	Separator string
+	// EnvCaseUpper (по умолчанию), EnvCaseLower или EnvCaseKeep
+	Case string
+}
+
+func (n EnvNaming) check() error {
+	switch n.Case {
+	case "", EnvCaseUpper, EnvCaseLower, EnvCaseKeep:
+		return nil
+	}
+	return errors.Errorf("unknown env naming case %q", n.Case)
+}
+
+// join добавляет name к prefix через разделитель
+func (n EnvNaming) join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	sep := n.Separator
+	if sep == "" {
+		sep = "_"
+	}
+	return prefix + sep + name
+}
+
+// caseOf приводит имя к регистру из правил
+func (n EnvNaming) caseOf(name string) string {
+	switch n.Case {
+	case EnvCaseLower:
+		return strings.ToLower(name)
+	case EnvCaseKeep:
+		return name
+	}
+	return strings.ToUpper(name)
+}
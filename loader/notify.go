@@ -0,0 +1,182 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// события для Notifier, Notification.Event
+const (
+	// приложение работает на сохраненном или предыдущем конфиге вместо нового
+	NotifyFallbackApplied = "fallback_applied"
+	// рабочий конфиг не удалось записать в хранилище
+	NotifySaveFailed = "save_failed"
+)
+
+// Notification - сообщение дежурным о тихой деградации: приложение работает, но не так, как задумано
+type Notification struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Host  string    `json:"host,omitempty"`
+	// источник конфига приложения
+	Source string `json:"source,omitempty"`
+	// ошибка конфига, из-за которой сработал откат, секретные значения замаскированы
+	ConfigError       string       `json:"config_error,omitempty"`
+	ConfigErrorFields []FieldError `json:"config_error_fields,omitempty"`
+	FallbackIndex     int          `json:"fallback_index,omitempty"`
+	// ошибка записи в хранилище для NotifySaveFailed
+	Error string `json:"error,omitempty"`
+}
+
+func (n Notification) String() string {
+	if n.Event == NotifySaveFailed {
+		return fmt.Sprintf("%s: failed to save known-good config: %s", n.Host, n.Error)
+	}
+	return fmt.Sprintf("%s: app runs on fallback config #%d from %s: %s", n.Host, n.FallbackIndex, n.Source, n.ConfigError)
+}
+
+// Notifier доставляет уведомления, см. WithNotifier.
+// Notify вызывается в отдельной горутине и ограничен LOADER_HTTP_TIMEOUT, ошибка доставки только попадает в лог
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// WebhookNotifier отправляет Notification в json POST запросом на url
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{url: url, client: &http.Client{}}
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	return postJSON(ctx, w.client, w.url, n)
+}
+
+// SlackNotifier отправляет уведомление текстом в incoming webhook Slack
+type SlackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewSlackNotifier(webhookURL string) *SlackNotifier {
+	return &SlackNotifier{url: webhookURL, client: &http.Client{}}
+}
+
+func (s *SlackNotifier) Notify(ctx context.Context, n Notification) error {
+	return postJSON(ctx, s.client, s.url, map[string]string{"text": ":warning: " + n.String()})
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode notification")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send notification")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("failed to send notification to %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// notifyEvents превращает события загрузчика в уведомления
+type notifyEvents struct {
+	NopEvents
+	notifiers []Notifier
+	source    string
+	timeout   time.Duration
+	log       Logger
+	// уведомления в пути, Run дожидается их перед выходом
+	pending sync.WaitGroup
+}
+
+func (e *notifyEvents) OnFallbackApplied(cfg Config) {
+	cfg = cfg.Redacted()
+	e.send(Notification{
+		Event:             NotifyFallbackApplied,
+		Source:            e.source,
+		ConfigError:       cfg.ConfigError,
+		ConfigErrorFields: cfg.ConfigErrorFields,
+		FallbackIndex:     cfg.FallbackIndex,
+	})
+}
+
+func (e *notifyEvents) OnConfigSaved(err error) {
+	if err == nil {
+		return
+	}
+	e.send(Notification{Event: NotifySaveFailed, Source: e.source, Error: err.Error()})
+}
+
+// send доставляет n всем Notifier в фоне, чтобы не задерживать смену конфига
+func (e *notifyEvents) send(n Notification) {
+	n.Time = time.Now().UTC()
+	n.Host, _ = os.Hostname()
+	for _, notifier := range e.notifiers {
+		e.pending.Add(1)
+		go func(notifier Notifier) {
+			defer e.pending.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+			defer cancel()
+			if err := notifier.Notify(ctx, n); err != nil {
+				e.log.Error("failed to send notification", "event", n.Event, "error", err)
+			}
+		}(notifier)
+	}
+}
+
+// initNotifiers добавляет уведомления из WithNotifier, LOADER_NOTIFY_WEBHOOK и LOADER_NOTIFY_SLACK к событиям загрузчика
+func (l *AppLoader) initNotifiers() {
+	if l.cfg.NotifyWebhook != "" {
+		l.notifiers = append(l.notifiers, NewWebhookNotifier(l.cfg.NotifyWebhook))
+	}
+	if l.cfg.NotifySlack != "" {
+		l.notifiers = append(l.notifiers, NewSlackNotifier(l.cfg.NotifySlack))
+	}
+	if len(l.notifiers) == 0 {
+		return
+	}
+	l.notify = &notifyEvents{
+		notifiers: l.notifiers,
+		source:    sourceName(l.source),
+		timeout:   l.cfg.HTTPTimeout,
+		log:       l.log,
+	}
+	l.events = append(l.events, l.notify)
+}
+
+// waitNotifications ждет, пока уйдут отправленные уведомления, но не дольше ctx.
+// Иначе уведомление о том, из-за чего процесс завершается, потерялось бы вместе с ним
+func (l *AppLoader) waitNotifications(ctx context.Context) {
+	if l.notify == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		l.notify.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
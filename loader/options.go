@@ -0,0 +1,253 @@
+package loader
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+)
+
+// Option меняет настройки AppLoader, которые нельзя задать через env
+type Option func(l *AppLoader)
+
+// WithEnvPrefix задает префикс переменных окружения конфига приложения, например APP
+func WithEnvPrefix(prefix string) Option {
+	return func(l *AppLoader) {
+		l.prefix = prefix
+	}
+}
+
+// WithEnvNaming задает правила, по которым строятся имена переменных окружения конфига приложения
+// для источника по умолчанию и описания конфига, см. EnvNaming. На LOADER_* переменные не влияет
+func WithEnvNaming(naming EnvNaming) Option {
+	return func(l *AppLoader) {
+		l.envNaming = naming
+	}
+}
+
+// WithLoaderEnvPrefix заменяет префикс LOADER переменных окружения самого загрузчика,
+// например с MYAPP_LOADER настройка LOADER_STRICT читается из MYAPP_LOADER_STRICT
+func WithLoaderEnvPrefix(prefix string) Option {
+	return func(l *AppLoader) {
+		l.loaderPrefix = prefix
+	}
+}
+
+// WithApp задает fx опции собираемого приложения
+func WithApp(provider fx.Option) Option {
+	return func(l *AppLoader) {
+		l.provider = provider
+	}
+}
+
+// WithAppConfig задает указатель на структуру конфига приложения, в которую он будет прочитан
+func WithAppConfig(configPtr interface{}) Option {
+	return func(l *AppLoader) {
+		l.cfg.App = configPtr
+	}
+}
+
+// WithConfig добавляет отдельный конфиг модуля: он читается из env с префиксом prefix
+// (после префикса из WithEnvPrefix, если он задан), проверяется и откатывается отдельно от других.
+// Опцию можно передать несколько раз, вместо WithAppConfig. В fx граф попадают T и *T каждого конфига.
+func WithConfig(prefix string, configPtr interface{}) Option {
+	return func(l *AppLoader) {
+		l.sections = append(l.sections, section{name: strings.ToLower(prefix), ptr: configPtr})
+	}
+}
+
+// WithModule добавляет в приложение fx.Module с именем name и его собственный конфиг,
+// как WithConfig с префиксом name. Ошибки ErrBadConfig из конструкторов и invoke модуля
+// относятся к его секции, так что откатывается только конфиг этого модуля.
+func WithModule(name string, configPtr interface{}, opts ...fx.Option) Option {
+	return func(l *AppLoader) {
+		WithConfig(name, configPtr)(l)
+		name = strings.ToLower(name)
+		l.modules = append(l.modules, moduleSpec{name: name, section: name, opts: opts})
+	}
+}
+
+// WithTimeouts задает таймауты запуска и остановки приложения.
+// Переменные LOADER_START_TIMEOUT и LOADER_STOP_TIMEOUT имеют приоритет над опцией.
+func WithTimeouts(start, stop time.Duration) Option {
+	return func(l *AppLoader) {
+		l.cfg.StartTimeout = start
+		l.cfg.StopTimeout = stop
+	}
+}
+
+// WithConfigSource задает источник конфига приложения.
+// По умолчанию конфиг читается из env с префиксом из WithEnvPrefix,
+// а если задан LOADER_ENV_FILE - еще и из .env файла.
+// С LOADER_CONFIG_FILE и LOADER_CONFIG_URL env накладывается поверх yaml/json/toml файла
+// и конфига, полученного по HTTP, см. LayeredSource.
+func WithConfigSource(source ConfigSource) Option {
+	return func(l *AppLoader) {
+		l.source = source
+	}
+}
+
+// WithLoaderConfig задает настройки загрузчика целиком, как если бы они пришли из LOADER_* переменных.
+// Файл --loader-config и переменные окружения перекрывают их, а опции, переданные после, заменяют отдельные поля
+func WithLoaderConfig(cfg LoaderConfig) Option {
+	return func(l *AppLoader) {
+		l.cfg.LoaderConfig = cfg
+	}
+}
+
+// WithLoaderConfigFile задает yaml, json или toml файл с настройками загрузчика.
+// Флаг --loader-config имеет приоритет над опцией, а переменные LOADER_* - над файлом
+func WithLoaderConfigFile(path string) Option {
+	return func(l *AppLoader) {
+		l.loaderConfigFile = path
+	}
+}
+
+// WithFallbackStore задает хранилище для последнего рабочего конфига.
+// По умолчанию конфиг хранится в файле, см. WithFallbackPath.
+func WithFallbackStore(store FallbackStore) Option {
+	return func(l *AppLoader) {
+		l.store = store
+	}
+}
+
+// WithLeaderElector включает запись рабочего конфига только с реплики, которую выбрал elector.
+// Нужна, когда реплики делят один ключ хранилища и иначе затирали бы друг у друга свои рабочие конфиги,
+// см. consulstore.NewLeader. Остальные реплики только читают сохраненный конфиг
+func WithLeaderElector(elector LeaderElector) Option {
+	return func(l *AppLoader) {
+		l.leader = elector
+	}
+}
+
+// WithFallbackPath задает путь к файлу последнего рабочего конфига.
+// Переменная LOADER_FALLBACK_PATH имеет приоритет над опцией.
+// Не влияет на хранилище, заданное через WithFallbackStore.
+func WithFallbackPath(path string) Option {
+	return func(l *AppLoader) {
+		l.cfg.FallbackPath = path
+	}
+}
+
+// WithCodec задает формат, в котором сохраняется последний рабочий конфиг.
+// Без опции формат выбирается переменной LOADER_FALLBACK_CODEC (json, yaml или gob),
+// по умолчанию используется json с чтением старых gob файлов.
+func WithCodec(codec ConfigCodec) Option {
+	return func(l *AppLoader) {
+		l.codec = codec
+	}
+}
+
+// WithSchemaVersion задает версию схемы конфига вместо автоматически вычисленной по структуре.
+// Версию стоит менять только при несовместимых изменениях, тогда сохраненный конфиг
+// переживет добавление новых полей.
+func WithSchemaVersion(version string) Option {
+	return func(l *AppLoader) {
+		l.schema = version
+	}
+}
+
+// WithSchemaMigration задает функцию перевода сохраненного конфига со старой схемы.
+// Если политика LOADER_SCHEMA_MISMATCH не задана, с этой опцией используется migrate.
+func WithSchemaMigration(migrate SchemaMigration) Option {
+	return func(l *AppLoader) {
+		l.migrate = migrate
+	}
+}
+
+// WithValidator добавляет проверку конфига приложения, которая выполняется до сборки приложения.
+// Можно передать несколько валидаторов, ошибки всех будут собраны в одну ErrBadConfig.
+func WithValidator(v Validator) Option {
+	return func(l *AppLoader) {
+		l.validators = append(l.validators, v)
+	}
+}
+
+// WithMetrics регистрирует метрики загрузчика в reg.
+// Без опции метрики отдаются только через /metrics админки, см. AppLoader.AdminHandler.
+func WithMetrics(reg prometheus.Registerer) Option {
+	return func(l *AppLoader) {
+		l.metrics.registerer = reg
+	}
+}
+
+// WithTracerProvider отправляет спаны загрузки (чтение конфига, валидация, откат, сборка
+// и запуск приложения) в tp. Без опции используется глобальный otel.GetTracerProvider().
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(l *AppLoader) {
+		l.tracerProvider = tp
+	}
+}
+
+// WithAuditLog добавляет журнал смены конфигов: запись о каждом запуске приложения
+// с новым или откаченным конфигом и об отклоненных конфигах. Можно передать несколько раз,
+// LOADER_AUDIT_LOG добавляет к ним файл.
+func WithAuditLog(a AuditLog) Option {
+	return func(l *AppLoader) {
+		l.auditLog.logs = append(l.auditLog.logs, a)
+	}
+}
+
+// WithAdminMiddleware добавляет свою проверку доступа к админке, например по заголовкам прокси с SSO.
+// Middleware выполняются по порядку после LOADER_ADMIN_TOKEN и клиентского сертификата
+// и могут назвать вызывающего для журнала через ContextWithAdminCaller
+func WithAdminMiddleware(mw func(http.Handler) http.Handler) Option {
+	return func(l *AppLoader) {
+		l.adminMiddleware = append(l.adminMiddleware, mw)
+	}
+}
+
+// WithNotifier добавляет получателя уведомлений о том, что приложение перешло на сохраненный конфиг
+// или рабочий конфиг не удалось сохранить. Готовые получатели - NewWebhookNotifier и NewSlackNotifier,
+// их же включают LOADER_NOTIFY_WEBHOOK и LOADER_NOTIFY_SLACK.
+func WithNotifier(n Notifier) Option {
+	return func(l *AppLoader) {
+		l.notifiers = append(l.notifiers, n)
+	}
+}
+
+// WithHealthProbe добавляет проверку, которую загрузчик вызывает в течение LOADER_PROBATION_PERIOD
+// после старта приложения с новым конфигом. Если она вернула ошибку, конфиг считается плохим
+// и приложение откатывается на последний рабочий конфиг. Без LOADER_PROBATION_PERIOD не вызывается.
+func WithHealthProbe(probe HealthProbe) Option {
+	return func(l *AppLoader) {
+		l.healthProbe = probe
+	}
+}
+
+// WithEvents добавляет обработчик событий загрузчика, см. Events.
+// Можно передать несколько обработчиков, события получат все по порядку.
+func WithEvents(e Events) Option {
+	return func(l *AppLoader) {
+		l.events = append(l.events, e)
+	}
+}
+
+// WithLogger задает логгер загрузчика и собираемого приложения.
+// По умолчанию логи пишутся в stderr в json, NopLogger отключает их.
+func WithLogger(log Logger) Option {
+	return func(l *AppLoader) {
+		l.log = log
+	}
+}
+
+// WithFallbackKey включает шифрование сохраненного конфига ключом AES длиной 16, 24 или 32 байта,
+// например расшифрованным через KMS. Опция имеет приоритет над LOADER_FALLBACK_KEY.
+func WithFallbackKey(key []byte) Option {
+	return func(l *AppLoader) {
+		l.fallbackKey = key
+	}
+}
+
+// WithBadConfigClassifier учит загрузчик считать ошибкой конфига сторонние ошибки, например
+// ошибку разбора dsn из драйвера базы: если classify вернул true для ошибки сборки графа, старта
+// приложения или чтения конфига, она обрабатывается как ErrBadConfig и приводит к откату.
+// Можно задать несколько, ошибка плохая, если ее так классифицировал любой
+func WithBadConfigClassifier(classify func(error) bool) Option {
+	return func(l *AppLoader) {
+		l.classifiers = append(l.classifiers, classify)
+	}
+}
@@ -0,0 +1,176 @@
+package loader
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+)
+
+const defaultProbationInterval = time.Second
+
+// HealthProbe проверяет, что приложение работает, см. WithHealthProbe
+type HealthProbe func(ctx context.Context) error
+
+// confirmConfig делает конфиг cfg, с которым стартовало app, рабочим: сразу,
+// а с LOADER_PROBATION_PERIOD - только когда app продержалось этот срок без ошибок проверок.
+// С LOADER_CRASH_LOOP_THRESHOLD конфиг сохраняется не раньше LOADER_CRASH_LOOP_WINDOW,
+// иначе конфиг, с которым процесс падает, успел бы стать рабочим
+func (l *AppLoader) confirmConfig(cfg *Config, app *fx.App) error {
+	if cfg.UsesFallbackConfig {
+		return nil
+	}
+	if cfg.ProbationPeriod > 0 || cfg.CrashLoopThreshold > 0 {
+		go l.probation(*cfg, app)
+		return nil
+	}
+	return l.saveStarted(cfg, app)
+}
+
+// probation раз в LOADER_PROBATION_INTERVAL проверяет app, запущенное с новым конфигом cfg.
+// Если за LOADER_PROBATION_PERIOD проверка не прошла, конфиг считается плохим
+// и приложение откатывается на последний рабочий конфиг. Прерывается, если app уже подменено.
+func (l *AppLoader) probation(cfg Config, app *fx.App) {
+	ctx := l.runContext()
+	period := cfg.ProbationPeriod
+	if cfg.CrashLoopThreshold > 0 && cfg.CrashLoopWindow > period {
+		period = cfg.CrashLoopWindow
+	}
+	deadline := time.NewTimer(period)
+	defer deadline.Stop()
+	ticker := time.NewTicker(cfg.ProbationInterval)
+	defer ticker.Stop()
+
+	l.log.Info("app is on probation with new config", "period", period.String())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline.C:
+			if !l.onProbation(app) {
+				return
+			}
+			l.log.Info("app passed probation with new config")
+			if err := l.saveStarted(&cfg, app); err != nil {
+				l.log.Error("failed to save config after probation", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if !l.onProbation(app) {
+				return
+			}
+			if cfg.ProbationPeriod <= 0 {
+				continue
+			}
+			if err := l.probe(ctx, cfg); err != nil {
+				l.failProbation(app, err)
+				return
+			}
+		}
+	}
+}
+
+// probe прогоняет проверки приложения из HealthReporter, WithHealthProbe и LOADER_PROBE_URL
+func (l *AppLoader) probe(ctx context.Context, cfg Config) error {
+	l.health.mu.Lock()
+	checks := l.health.checks
+	l.health.mu.Unlock()
+	if checks != nil {
+		if failed := checks.run(); len(failed) > 0 {
+			msgs := make([]string, 0, len(failed))
+			for name, reason := range failed {
+				msgs = append(msgs, name+": "+reason)
+			}
+			sort.Strings(msgs)
+			return errors.Errorf("health checks failed: %s", strings.Join(msgs, "; "))
+		}
+	}
+	if l.healthProbe != nil {
+		if err := l.healthProbe(ctx); err != nil {
+			return errors.Wrap(err, "health probe failed")
+		}
+	}
+	if cfg.ProbeURL != "" {
+		return probeURL(ctx, cfg.ProbeURL, cfg.HTTPTimeout)
+	}
+	return nil
+}
+
+// probeURL считает приложение здоровым, если url отвечает 2xx
+func probeURL(ctx context.Context, url string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "health probe failed")
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("health probe %s responded with %s", url, resp.Status)
+	}
+	return nil
+}
+
+// failProbation откатывает не прошедшее проверку app на последний сохраненный рабочий конфиг
+func (l *AppLoader) failProbation(app *fx.App, probeErr error) {
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+	if l.currentApp() != app || l.shutdown.isRequested() {
+		return
+	}
+
+	badErr := ErrBadConfig{Cause: errors.Wrap(probeErr, "app is unhealthy with new config")}
+	l.log.Error("app failed probation, rolling back", "error", probeErr)
+	prev := l.Config()
+	if prev.Strict {
+		l.rejectConfig(badErr, nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), prev.LoadTimeout)
+	defer cancel()
+	next := prev
+	next.App = DeepCopy(prev.App)
+	fallback, err := l.buildFallback(ctx, &next, badErr)
+	if err != nil {
+		l.log.Error("no fallback config for unhealthy app, it keeps running", "error", err)
+		return
+	}
+	// ошибка уже в логе, а swap восстанавливает приложение на prev, если откаченное не стартовало
+	_ = l.swap(&prev, &next, fallback)
+}
+
+// onProbation сообщает, что app все еще работает, а его конфиг еще не сохранен как рабочий
+func (l *AppLoader) onProbation(app *fx.App) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.app == app && l.confirmed != app
+}
+
+// Promoted сообщает, что приложение готово принимать запросы, а процесс хотя бы раз сохранил свой конфиг
+// как рабочий: прошел LOADER_PROBATION_PERIOD и LOADER_CRASH_LOOP_WINDOW или вызван PromoteCurrentConfig.
+// Пока новый конфиг на испытательном сроке или процесс работает на откате, возвращает false, так что
+// выкатка в Kubernetes с /loader/ready?promoted=1 в readinessProbe встает, не дожидаясь отката.
+// После первого сохранения считается только обычная готовность: при hot reload на испытательном
+// сроке оказываются все экземпляры сразу, и снимать их всех с балансировки нельзя
+func (l *AppLoader) Promoted() bool {
+	return l.Health().Ready && l.promoted.Load()
+}
+
+// runContext возвращает контекст, который отменяется, когда завершается Start
+func (l *AppLoader) runContext() context.Context {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.runCtx == nil {
+		return context.Background()
+	}
+	return l.runCtx
+}
@@ -0,0 +1,81 @@
+package loader
+
+import (
+	"path/filepath"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// defaultSource собирает источник конфига по LOADER_* настройкам, если он не задан WithConfigSource:
+// файл LOADER_CONFIG_FILE и файл профиля, конфиг по LOADER_CONFIG_URL, вывод LOADER_CONFIG_EXEC, env (или .env файл)
+// и env профиля, каждый следующий перекрывает предыдущие по политике LOADER_MERGE_CONFLICT
+func (l *AppLoader) defaultSource(cfgPrefix string) (ConfigSource, error) {
+	profile := l.cfg.Profile
+	if err := checkProfile(profile); err != nil {
+		return nil, err
+	}
+	profilePrefix := ""
+	if profile != "" {
+		profilePrefix = l.envNaming.caseOf(l.envNaming.join(cfgPrefix, profile))
+	}
+
+	var env, profileEnv ConfigSource
+	if l.cfg.EnvFile != "" {
+		base := NewEnvFileSourceNaming(cfgPrefix, l.cfg.EnvFile, l.envNaming)
+		base.profilePrefix = profilePrefix
+		env = base
+		if profile != "" {
+			profileEnv = NewEnvFileSourceNaming(profilePrefix, l.cfg.EnvFile, l.envNaming)
+		}
+	} else {
+		base := NewEnvSourceNaming(cfgPrefix, l.envNaming)
+		base.profilePrefix = profilePrefix
+		env = base
+		if profile != "" {
+			profileEnv = NewEnvSourceNaming(profilePrefix, l.envNaming)
+		}
+	}
+
+	var layers []ConfigSource
+	if l.cfg.ConfigFile != "" {
+		layers = append(layers, NewFileSource(l.cfg.ConfigFile))
+		if profile != "" {
+			layers = append(layers, profileFileSource(l.cfg.ConfigFile, profile))
+		}
+	}
+	if l.cfg.ConfigURL != "" {
+		layers = append(layers, NewHTTPSource(l.cfg.ConfigURL, l.cfg.HTTPTimeout))
+	}
+	if name, args := parseCommandLine(l.cfg.ConfigExec); name != "" {
+		exec := NewExecSource(cfgPrefix, l.cfg.ConfigExecTimeout, name, args...)
+		exec.naming = l.envNaming
+		layers = append(layers, exec)
+	}
+	layers = append(layers, env)
+	if profileEnv != nil {
+		layers = append(layers, profileEnv)
+	}
+	if len(layers) == 1 {
+		return env, nil
+	}
+	return NewLayeredSourcePolicy(l.cfg.MergeConflict, layers...), nil
+}
+
+// checkProfile - имя профиля входит в имена переменных и файлов, поэтому в нем только буквы, цифры и _
+func checkProfile(profile string) error {
+	for _, r := range profile {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return errors.Errorf("invalid profile %q: only letters, digits and _ are allowed", profile)
+		}
+	}
+	return nil
+}
+
+// profileFileSource - файл профиля рядом с базовым: для config/base.yaml и профиля prod это config/prod.yaml.
+// Файла профиля может не быть, тогда профиль задается только переменными окружения
+func profileFileSource(base, profile string) *FileSource {
+	s := NewFileSource(filepath.Join(filepath.Dir(base), profile+filepath.Ext(base)))
+	s.optional = true
+	return s
+}
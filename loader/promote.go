@@ -0,0 +1,46 @@
+package loader
+
+import (
+	"github.com/pkg/errors"
+)
+
+// PromoteCurrentConfig сразу сохраняет текущий конфиг как рабочий, не дожидаясь старта приложения,
+// LOADER_PROBATION_PERIOD или LOADER_CRASH_LOOP_WINDOW. Испытательный срок приложения на этом заканчивается.
+// Сохранить можно и примененный откат, тогда он становится последним рабочим конфигом
+func (l *AppLoader) PromoteCurrentConfig() error {
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+
+	cfg := l.Config()
+	app := l.currentApp()
+	// saveConfig не сохраняет откаты, а здесь это решение оператора
+	cfg.UsesFallbackConfig = false
+	if err := l.saveStarted(&cfg, app); err != nil {
+		return err
+	}
+	if cfg.CrashLoopThreshold > 0 {
+		if sum, err := l.configChecksum(cfg.App); err == nil {
+			l.confirmStart(journalPath(&cfg), sum)
+		}
+	}
+	l.log.Info("current config promoted on request")
+	return nil
+}
+
+// InvalidateFallback удаляет последний сохраненный рабочий конфиг, например если известно, что он плохой.
+// Следующий откат пойдет на предыдущий конфиг из истории, если она есть.
+// Работающее приложение не трогается. Хранилище должно реализовывать DeleteStore
+func (l *AppLoader) InvalidateFallback() error {
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+
+	if err := deleteFromStore(l.store); err != nil {
+		return errors.Wrap(err, "failed to invalidate fallback config")
+	}
+	l.mu.Lock()
+	// текущий конфиг мог быть удаленным, и тогда он снова не сохранен
+	l.confirmed = nil
+	l.mu.Unlock()
+	l.log.Info("fallback config invalidated on request")
+	return nil
+}
@@ -0,0 +1,330 @@
+package loader
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// откуда взято значение поля, ValueOrigin.Source
+const (
+	// значение из тега default или Defaults
+	OriginDefault = "default"
+	// переменная окружения процесса
+	OriginEnv = "env"
+	// переменная из .env файла, см. LOADER_ENV_FILE
+	OriginEnvFile = "env_file"
+	// yaml, json или toml файл
+	OriginFile = "file"
+	// конфиг по http, см. LOADER_CONFIG_URL
+	OriginHTTP = "http"
+	// флаг командной строки
+	OriginFlag = "flag"
+	// поле заполнил хук WithAfterLoad или AfterLoad конфига
+	OriginAfterLoad = "after_load"
+	// сохраненный рабочий конфиг, на котором работает приложение вместо конфига из источника
+	OriginFallback = "fallback"
+	// флаг переключен из админки или удаленным источником, см. SetFlag
+	OriginFlagAdmin  = "flag_admin"
+	OriginFlagRemote = "flag_remote"
+	// значение прочитано раньше, а в источнике уже другое: конфиг еще не перечитан
+	OriginPrevious = "previous"
+	// значение не задано ни одним источником
+	OriginUnset = "unset"
+)
+
+// ValueOrigin - откуда взято текущее значение поля конфига. Для источников без подробностей
+// (например, Vault или Consul) Source - имя источника, как в /loader/status
+type ValueOrigin struct {
+	Source string `json:"source"`
+	// переменная окружения, ключ в файле или ответе, флаг командной строки или имя флага из тега flag;
+	// для fallback - номер сохраненного конфига
+	Key  string `json:"key,omitempty"`
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// originSource - источник, который знает, какие поля cfg задал и из каких ключей
+type originSource interface {
+	origins(cfg interface{}) map[string]ValueOrigin
+}
+
+// Provenance возвращает для каждого поля текущего конфига приложения, откуда взято его значение.
+// Конфиг для этого заново читается из источника, так что ответ покажет и то, что источник
+// с тех пор поменялся (OriginPrevious). Значения полей в ответ не попадают
+func (l *AppLoader) Provenance(ctx context.Context) (map[string]ValueOrigin, error) {
+	cur := l.Config()
+	ctx, cancel := context.WithTimeout(ctx, cur.LoadTimeout)
+	defer cancel()
+
+	loaded := newAppConfig(cur.App)
+	origins, err := l.traceLoad(ctx, loaded)
+	if err != nil && !cur.UsesFallbackConfig {
+		return nil, err
+	}
+	running := flattenConfig(cur.App)
+	fromSource := flattenConfig(loaded)
+	flagOrigins := l.flagOrigins()
+	fallback := ValueOrigin{Source: OriginFallback, Key: strconv.Itoa(cur.FallbackIndex)}
+
+	result := make(map[string]ValueOrigin, len(running))
+	for path, v := range running {
+		origin, ok := origins[path]
+		if !ok {
+			origin = ValueOrigin{Source: OriginUnset}
+		}
+		switch flag, overridden := flagOrigins[path]; {
+		case overridden:
+			origin = flag
+		case (cur.UsesFallbackConfig || cur.CanaryHeld) && inFallbackSection(path, cur.FallbackSections):
+			origin = fallback
+		case err != nil:
+			// источник не читается, а приложение работает на сохраненном конфиге
+			origin = fallback
+		case !reflect.DeepEqual(v.value, fromSource[path].value):
+			origin = ValueOrigin{Source: OriginPrevious}
+		}
+		result[path] = origin
+	}
+	return result, nil
+}
+
+// inFallbackSection проверяет, что поле path откачено: откачен весь конфиг или секция поля
+func inFallbackSection(path string, sections []string) bool {
+	if len(sections) == 0 {
+		return true
+	}
+	for _, s := range sections {
+		if path == s || strings.HasPrefix(path, s+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// flagOrigins возвращает origin переключенных флагов по путям их полей, см. SetFlag
+func (l *AppLoader) flagOrigins() map[string]ValueOrigin {
+	l.flags.mu.Lock()
+	defer l.flags.mu.Unlock()
+	origins := map[string]ValueOrigin{}
+	for name, path := range l.flags.fields {
+		switch _, from := l.flags.value(name); from {
+		case FlagFromAdmin:
+			origins[path] = ValueOrigin{Source: OriginFlagAdmin, Key: name}
+		case FlagFromRemote:
+			origins[path] = ValueOrigin{Source: OriginFlagRemote, Key: name}
+		}
+	}
+	return origins
+}
+
+// traceLoad читает конфиг в appCfg так же, как loadSource, и запоминает, какой шаг
+// или слой источника задал каждое поле. Переключенные флаги не подставляются
+func (l *AppLoader) traceLoad(ctx context.Context, appCfg interface{}) (map[string]ValueOrigin, error) {
+	origins := map[string]ValueOrigin{}
+	step := func(fallback ValueOrigin, src ConfigSource, load func() error) error {
+		before := flattenConfig(appCfg)
+		if err := load(); err != nil {
+			return err
+		}
+		var set map[string]ValueOrigin
+		if s, ok := src.(originSource); ok {
+			set = s.origins(appCfg)
+		}
+		for path, v := range flattenConfig(appCfg) {
+			if o, ok := set[path]; ok {
+				origins[path] = o
+			} else if !reflect.DeepEqual(before[path].value, v.value) {
+				origins[path] = fallback
+			}
+		}
+		return nil
+	}
+
+	if err := step(ValueOrigin{Source: OriginDefault}, nil, func() error { return l.applyDefaults(appCfg) }); err != nil {
+		return nil, err
+	}
+	layers := []ConfigSource{l.source}
+	if ls, ok := l.source.(*LayeredSource); ok {
+		layers = ls.sources
+	}
+	for _, src := range layers {
+		src := src
+		err := step(ValueOrigin{Source: sourceName(src)}, src, func() error {
+			if len(layers) > 1 {
+				return applyLayer(ctx, src, appCfg)
+			}
+			return loadFrom(ctx, src, appCfg)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := l.expandEnv(appCfg); err != nil {
+		return nil, err
+	}
+	if err := step(ValueOrigin{Source: OriginAfterLoad}, nil, func() error { return l.afterLoad(appCfg) }); err != nil {
+		return nil, err
+	}
+	return origins, nil
+}
+
+func (s *EnvSource) origins(cfg interface{}) map[string]ValueOrigin {
+	return envOrigins(s.prefix, cfg, s.naming, func(key string) (ValueOrigin, bool) {
+		if _, ok := s.lookup(key); ok {
+			return ValueOrigin{Source: OriginEnv, Key: key}, true
+		}
+		return ValueOrigin{}, false
+	})
+}
+
+func (s *EnvFileSource) origins(cfg interface{}) map[string]ValueOrigin {
+	lines := map[string]int{}
+	if data, err := os.ReadFile(s.path); err == nil {
+		lines = envFileLines(data)
+	}
+	return envOrigins(s.prefix, cfg, s.naming, func(key string) (ValueOrigin, bool) {
+		if _, ok := os.LookupEnv(key); ok {
+			return ValueOrigin{Source: OriginEnv, Key: key}, true
+		}
+		if line, ok := lines[key]; ok {
+			return ValueOrigin{Source: OriginEnvFile, Key: key, File: s.path, Line: line}, true
+		}
+		return ValueOrigin{}, false
+	})
+}
+
+// envOrigins возвращает origin переменной каждого поля cfg, которую нашел lookup
+func envOrigins(prefix string, cfg interface{}, naming EnvNaming, lookup func(key string) (ValueOrigin, bool)) map[string]ValueOrigin {
+	spec := newAppConfig(cfg)
+	vars, err := gatherEnvVars(prefix, spec, naming)
+	if err != nil {
+		return nil
+	}
+	paths := envVarPaths(spec, vars)
+	origins := map[string]ValueOrigin{}
+	for i, v := range vars {
+		o, ok := lookup(v.Key)
+		if !ok && v.Alt != "" {
+			o, ok = lookup(v.Alt)
+		}
+		if ok {
+			origins[paths[i]] = o
+		}
+	}
+	return origins
+}
+
+// envFileLines возвращает номера строк, на которых в .env файле заданы переменные
+func envFileLines(data []byte) map[string]int {
+	lines := map[string]int{}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimPrefix(strings.TrimSpace(line), "export ")
+		if key, _, ok := strings.Cut(line, "="); ok && !strings.HasPrefix(line, "#") {
+			lines[strings.TrimSpace(key)] = n + 1
+		}
+	}
+	return lines
+}
+
+func (s *FileSource) origins(cfg interface{}) map[string]ValueOrigin {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil
+	}
+	return fileOrigins(data, s.format, cfg, ValueOrigin{Source: OriginFile, File: s.path})
+}
+
+func (s *HTTPSource) origins(cfg interface{}) map[string]ValueOrigin {
+	s.mu.Lock()
+	body := s.body
+	s.mu.Unlock()
+	return fileOrigins(body, FileFormatJSON, cfg, ValueOrigin{Source: OriginHTTP, URL: s.url})
+}
+
+func (s *FlagSource) origins(cfg interface{}) map[string]ValueOrigin {
+	set := map[string]bool{}
+	for _, arg := range s.args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		name, _, _ = strings.Cut(name, "=")
+		set[name] = true
+	}
+	return envOrigins("", cfg, EnvNaming{}, func(key string) (ValueOrigin, bool) {
+		name := flagName(envVar{Key: key})
+		return ValueOrigin{Source: OriginFlag, Key: "--" + name}, set[name]
+	})
+}
+
+// fileOrigins находит поля cfg, заданные в файле data, с ключами и номерами строк.
+// Ключи сопоставляются с путями полей без учета регистра, _ и -, как и при чтении файла
+func fileOrigins(data []byte, format string, cfg interface{}, base ValueOrigin) map[string]ValueOrigin {
+	fields := map[string]string{}
+	for path := range flattenConfig(cfg) {
+		fields[originKey(path)] = path
+	}
+	origins := map[string]ValueOrigin{}
+	add := func(key string, line int) bool {
+		path, ok := fields[originKey(key)]
+		if ok {
+			o := base
+			o.Key, o.Line = key, line
+			origins[path] = o
+		}
+		return ok
+	}
+
+	if format == FileFormatTOML {
+		// у toml нет номеров строк, только ключи
+		var values map[string]interface{}
+		if _, err := toml.Decode(string(data), &values); err == nil {
+			walkValueKeys(values, "", add)
+		}
+		return origins
+	}
+	// json тоже разбирается как yaml, чтобы узнать строки
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return origins
+	}
+	walkNodeKeys(doc.Content[0], "", add)
+	return origins
+}
+
+// walkNodeKeys обходит ключи yaml документа, не спускаясь в значения найденных полей
+func walkNodeKeys(node *yaml.Node, prefix string, add func(key string, line int) bool) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		path := joinPath(prefix, key.Value)
+		if !add(path, key.Line) {
+			walkNodeKeys(value, path, add)
+		}
+	}
+}
+
+func walkValueKeys(values map[string]interface{}, prefix string, add func(key string, line int) bool) {
+	for key, value := range values {
+		path := joinPath(prefix, key)
+		if nested, ok := value.(map[string]interface{}); !add(path, 0) && ok {
+			walkValueKeys(nested, path, add)
+		}
+	}
+}
+
+func originKey(path string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(path))
+}
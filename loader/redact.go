@@ -0,0 +1,114 @@
+package loader
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const redactedValue = "******"
+
+// isSecretField - значение поля нельзя показывать в логах, ошибках и админке.
+// Поля с json:"-" считаются секретными, потому что их и так не видно в сериализованном конфиге.
+func isSecretField(sf reflect.StructField) bool {
+	return isSecretTag(sf.Tag)
+}
+
+func isSecretTag(tag reflect.StructTag) bool {
+	return isTrue(tag.Get("secret")) || tag.Get("json") == "-"
+}
+
+// Redacted возвращает копию конфига, которую можно показывать: значения полей с тегом secret:"true"
+// или json:"-" замаскированы, в том числе в ConfigError и ConfigErrorFields.
+// Строки заменяются на ******, остальные типы - на нулевое значение.
+// Внутрь слайсов и map маскирование не заходит.
+func (c Config) Redacted() Config {
+	secrets := map[string]bool{}
+	var values []string
+	walkFields(c.App, func(f configField) {
+		if !isSecretField(f.Field) {
+			return
+		}
+		secrets[f.Path] = true
+		if v := fieldValue(f.Value); v != nil {
+			if s := fmt.Sprint(v); s != "" && !f.Value.IsZero() {
+				values = append(values, s)
+			}
+		}
+	})
+
+	c.App = redactValue(reflect.ValueOf(c.App)).Interface()
+	for _, v := range values {
+		c.ConfigError = strings.ReplaceAll(c.ConfigError, v, redactedValue)
+	}
+	if len(c.ConfigErrorFields) > 0 {
+		fields := make([]FieldError, len(c.ConfigErrorFields))
+		for i, fe := range c.ConfigErrorFields {
+			if secrets[fe.Field] {
+				fe.Value = nil
+			}
+			fields[i] = fe
+		}
+		c.ConfigErrorFields = fields
+	}
+	return c
+}
+
+func redactValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type().Elem())
+		out.Elem().Set(redactValue(v.Elem()))
+		return out
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.Set(redactValue(v.Elem()))
+		return out
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.PkgPath != "" {
+				continue
+			}
+			f := out.Field(i)
+			if isSecretField(sf) {
+				maskValue(f)
+				continue
+			}
+			f.Set(redactValue(v.Field(i)))
+		}
+		return out
+	}
+	return v
+}
+
+func maskValue(f reflect.Value) {
+	if f.Kind() == reflect.String && f.Len() > 0 {
+		f.SetString(redactedValue)
+		return
+	}
+	f.Set(reflect.Zero(f.Type()))
+}
+
+// redactError убирает значение секретного поля из текста ошибки разбора
+func redactError(err error, value string) error {
+	if value == "" {
+		return err
+	}
+	return redactedError(strings.ReplaceAll(err.Error(), value, redactedValue))
+}
+
+type redactedError string
+
+func (e redactedError) Error() string {
+	return string(e)
+}
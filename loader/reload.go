@@ -0,0 +1,422 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+)
+
+// watch периодически перечитывает конфиг из источника и пересобирает приложение, если он поменялся.
+// Сравнивается с последним прочитанным из источника конфигом, а не с работающим, иначе при работе
+// на откаченном конфиге приложение пересобиралось бы на каждой итерации.
+func (l *AppLoader) watch(ctx context.Context) {
+	loadCtx, cancel := l.loadContext(ctx)
+	last, _ := l.loadSourceConfig(loadCtx)
+	cancel()
+
+	ticker := time.NewTicker(l.Config().WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		l.watchOnce(ctx, &last)
+	}
+}
+
+// watchOnce перечитывает конфиг и пересобирает приложение, если он отличается от last
+func (l *AppLoader) watchOnce(ctx context.Context, last *interface{}) {
+	ctx, cancel := l.loadContext(ctx)
+	defer cancel()
+
+	next, err := l.loadSourceConfig(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			// после ошибки источника прочитанный конфиг сравнивается с работающим заново,
+			// иначе при том же конфиге ошибка так и осталась бы в ConfigError
+			*last = nil
+			l.sourceError(err)
+		}
+		return
+	}
+	if *last != nil && reflect.DeepEqual(next, *last) {
+		return
+	}
+	*last = next
+	if cur := l.Config(); !cur.UsesFallbackConfig && reflect.DeepEqual(next, cur.App) {
+		// конфиг из источника совпадает с работающим, например поменялись только переключенные флаги
+		l.clearSourceError()
+		return
+	}
+	l.log.Info("config changed, reloading")
+	// ошибка уже сохранена в ConfigError, а приложение осталось на прошлом конфиге
+	_ = l.reload(ctx, next)
+}
+
+// watchNotifications перезагружает конфиг на каждое событие источника
+func (l *AppLoader) watchNotifications(ctx context.Context, source NotifyingSource) {
+	for range source.Watch(ctx) {
+		l.log.Info("config source reported a change, reloading")
+		// ошибка уже сохранена в ConfigError, а приложение осталось на прошлом конфиге
+		_ = l.Reload()
+	}
+}
+
+// Reload перечитывает конфиг приложения из источника и пересобирает с ним приложение.
+// Работающее приложение подменяется, только если граф нового собрался без ошибок,
+// иначе оно продолжает работать на текущем конфиге, а ошибка попадает в ConfigError.
+func (l *AppLoader) Reload() (err error) {
+	ctx, cancel := l.loadContext(context.Background())
+	defer cancel()
+	ctx, span := l.startSpan(ctx, spanReload)
+	defer func() {
+		cur := l.Config()
+		span.SetAttributes(fallbackAttrs(&cur)...)
+		endSpan(span, err)
+	}()
+
+	appCfg, err := l.loadSourceConfig(ctx)
+	if err != nil {
+		l.sourceError(err)
+		return errors.Wrap(err, "failed to load config")
+	}
+	if cur := l.Config(); !cur.UsesFallbackConfig && reflect.DeepEqual(appCfg, cur.App) {
+		l.clearSourceError()
+		return nil
+	}
+	return l.reload(ctx, appCfg)
+}
+
+// loadContext ограничивает перезагрузку таймаутом LOADER_LOAD_TIMEOUT
+func (l *AppLoader) loadContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, l.Config().LoadTimeout)
+}
+
+// перезагружает конфиг по SIGHUP
+func (l *AppLoader) reloadOnSighup(ctx context.Context) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			l.log.Info("received SIGHUP, reloading config")
+			// ошибка уже сохранена в ConfigError
+			_ = l.Reload()
+		}
+	}
+}
+
+// читает конфиг из источника в новый экземпляр структуры конфига приложения
+func (l *AppLoader) loadSourceConfig(ctx context.Context) (interface{}, error) {
+	appCfg := newAppConfig(l.Config().App)
+	err := l.loadSource(ctx, appCfg)
+	l.events.OnConfigLoaded(sourceName(l.source), err)
+	if err != nil {
+		l.metrics.loadFailures.WithLabelValues(failureStageLoad).Inc()
+		l.log.Error("failed to load config", "source", sourceName(l.source), "error", err)
+		return nil, err
+	}
+	return appCfg, nil
+}
+
+// newAppConfig создает пустой экземпляр того же типа, что и конфиг приложения
+func newAppConfig(appCfg interface{}) interface{} {
+	return reflect.New(reflect.TypeOf(appCfg).Elem()).Interface()
+}
+
+// reload пересобирает приложение с новым конфигом приложения appCfg.
+// Старое приложение останавливается, только если новое удалось собрать.
+// Если новое не стартовало, поднимается заново приложение на предыдущем конфиге.
+// Если поменялись только поля с тегом reload:"hot", приложение не пересобирается, см. hotReload.
+func (l *AppLoader) reload(ctx context.Context, appCfg interface{}) error {
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+	if l.shutdown.isRequested() {
+		return errStopped
+	}
+
+	prev := l.Config()
+	next := prev
+	next.App = appCfg
+	next.UsesFallbackConfig = false
+	next.FallbackIndex = 0
+	next.FallbackSavedAt = nil
+	next.FallbackDiff = nil
+	next.FallbackSections = nil
+	next.ConfigError = ""
+	next.ConfigErrorFields = nil
+	next.CanaryHeld = false
+	l.timeouts.apply(&next.LoaderConfig)
+	if held := l.canaryHeld(ctx, appCfg); held != nil {
+		next.App = held
+		next.CanaryHeld = true
+		next.FallbackDiff = diffConfigs(flattenConfig(appCfg), flattenConfig(held))
+		l.log.Info("new config is rolling out on canaries, keeping known-good config", "diff", next.FallbackDiff)
+	}
+	if (prev.CanaryHeld || next.CanaryHeld) && reflect.DeepEqual(next.App, prev.App) {
+		// приложение уже работает на этом конфиге, перезапускать его незачем
+		next.App = prev.App
+		next.ConfigHash = prev.ConfigHash
+		next.DefaultedFields = prev.DefaultedFields
+		l.mu.Lock()
+		l.storeConfig(&next)
+		l.mu.Unlock()
+		return nil
+	}
+	if !prev.UsesFallbackConfig && !prev.CanaryHeld && !next.CanaryHeld && onlyHotChanged(prev.App, next.App) {
+		if applied, err := l.hotReload(ctx, &prev, &next); applied {
+			return err
+		}
+	}
+
+	var app *fx.App
+	err := l.checkCrashLoop(&next)
+	if err == nil {
+		app, err = l.buildApp(ctx, &next)
+	}
+	if err != nil {
+		if badErr, ok := unwrapBadConfigError(err); ok {
+			// если плохи только некоторые секции, они остаются на текущих значениях, а остальные обновляются
+			if merged, bad := l.sectionFallback(appCfg, prev.App, badErr); merged != nil && !prev.Strict {
+				return l.reloadSections(ctx, prev, appCfg, merged, bad, badErr)
+			}
+			l.rejectConfig(badErr, appCfg)
+		} else {
+			l.rejectConfig(err, appCfg)
+		}
+		return errors.Wrap(err, "failed to create app with new config")
+	}
+
+	if err := l.swap(&prev, &next, app); err != nil {
+		l.rejectConfig(err, appCfg)
+		return err
+	}
+
+	return l.confirmConfig(&next, app)
+}
+
+// Rollback принудительно переключает приложение на самый новый сохраненный рабочий конфиг,
+// который отличается от текущего. Как и при перезагрузке, текущее приложение
+// останавливается, только если с откаченным конфигом удалось собрать новое.
+func (l *AppLoader) Rollback() error {
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+	if l.shutdown.isRequested() {
+		return errStopped
+	}
+
+	ctx, cancel := l.loadContext(context.Background())
+	defer cancel()
+
+	prev := l.Config()
+	history, err := l.loadFallbackHistory(ctx, &prev)
+	if err != nil {
+		return errors.Wrap(err, "failed to load fallback config")
+	}
+	// в хранилище конфиг лежит в том виде, в каком его сохранили хуки WithBeforeSave и без секретов
+	saved, err := l.savedForm(prev.App)
+	if err != nil {
+		saved = prev.App
+	}
+	current, err := l.codec.Encode(saved)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode config")
+	}
+
+	err = withClass(ErrFallbackUnavailable, errors.New("no fallback config other than the current one"))
+	for i, data := range history {
+		// текущий конфиг обычно и есть последний сохраненный, откатываться на него незачем
+		if _, payload, _, decodeErr := decodeSnapshot(data); decodeErr == nil && bytes.Equal(payload, current) {
+			continue
+		}
+		appCfg := newAppConfig(prev.App)
+		header, applyErr := l.applyFallbackConfig(ctx, data, appCfg)
+		if applyErr != nil {
+			err = withClass(ErrFallbackUnavailable, errors.Wrap(applyErr, "failed to load fallback config"))
+			continue
+		}
+		next := prev
+		next.App = appCfg
+		next.UsesFallbackConfig = true
+		next.FallbackIndex = i
+		next.FallbackSavedAt = header.SavedAt
+		l.applySnapshotTimeouts(&next, header)
+		next.FallbackSections = nil
+		next.FallbackDiff = diffConfigs(flattenConfig(prev.App), flattenConfig(appCfg))
+		next.ConfigError = "rolled back on request"
+		next.ConfigErrorFields = nil
+
+		app, buildErr := l.buildApp(ctx, &next)
+		if buildErr != nil {
+			err = errors.Wrap(buildErr, "failed to create app with fallback config")
+			continue
+		}
+		l.log.Info("rolling back on request", "index", i)
+		if err := l.swap(&prev, &next, app); err != nil {
+			return err
+		}
+		l.events.OnFallbackApplied(next)
+		return nil
+	}
+	return err
+}
+
+// swap останавливает текущее приложение и запускает app с конфигом next,
+// с LOADER_RELOAD_STRATEGY=bluegreen - в обратном порядке, см. swapBlueGreen
+func (l *AppLoader) swap(prev, next *Config, app *fx.App) error {
+	if next.ReloadStrategy == ReloadBlueGreen {
+		return l.swapBlueGreen(prev, next, app)
+	}
+	l.health.setRestarting(true)
+	defer l.health.setRestarting(false)
+
+	old := l.currentApp()
+	stopCtx, cancel := context.WithTimeout(context.Background(), prev.StopTimeout)
+	// ошибка остановки старого приложения не мешает запустить новое
+	_ = l.stopApp(stopCtx, old)
+	cancel()
+
+	l.setCurrent(next, app)
+	l.beginStart(next, app)
+	startCtx, cancel := context.WithTimeout(context.Background(), next.StartTimeout)
+	startErr := app.Start(startCtx)
+	cancel()
+	if startErr == nil {
+		l.log.Info("app restarted with new config", "fallback", next.UsesFallbackConfig, "config_hash", next.ConfigHash)
+		l.audit(auditAction(next), next, prev, nil)
+		l.subs.notify(*next)
+		return nil
+	}
+	l.log.Error("failed to start app with new config, restoring previous config", "error", startErr)
+	l.events.OnAppStartFailed(*next, startErr)
+	l.audit(auditAction(next), next, prev, startErr)
+
+	stopCtx, cancel = context.WithTimeout(context.Background(), next.StopTimeout)
+	_ = l.stopApp(stopCtx, app)
+	cancel()
+
+	// старое приложение уже остановлено, и повторно его не запустить,
+	// поэтому собираем заново на предыдущем конфиге
+	// контекст перезагрузки мог уже истечь, а восстановиться нужно в любом случае
+	buildCtx, cancel := context.WithTimeout(context.Background(), prev.LoadTimeout)
+	prevApp, err := l.buildApp(buildCtx, prev)
+	cancel()
+	if err == nil {
+		startCtx, cancel := context.WithTimeout(context.Background(), prev.StartTimeout)
+		err = prevApp.Start(startCtx)
+		cancel()
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to restore app with previous config after start error: %s", startErr)
+		l.log.Error("no app is running", "error", err)
+		l.events.OnAppStartFailed(*prev, err)
+		l.audit(AuditRollback, prev, next, err)
+		select {
+		case l.failed <- err:
+		default:
+		}
+		return err
+	}
+	l.setCurrent(prev, prevApp)
+	l.audit(AuditRollback, prev, next, nil)
+	return errors.Wrap(startErr, "failed to start app with new config")
+}
+
+func (l *AppLoader) setCurrent(cfg *Config, app *fx.App) {
+	l.mu.Lock()
+	l.storeConfig(cfg)
+	l.app = app
+	l.mu.Unlock()
+
+	select {
+	case l.swapped <- struct{}{}:
+	default:
+	}
+}
+
+// rejectConfig оставляет приложение на текущем конфиге и запоминает, почему новый не применился.
+// rejected - отвергнутый конфиг приложения, если его удалось прочитать, иначе nil
+func (l *AppLoader) rejectConfig(err error, rejected interface{}) {
+	l.mu.Lock()
+	// в строгом режиме не работаем на прошлом конфиге, Start вернет ошибку. Ошибки источника
+	// и сети - не плохой конфиг: из-за одного неудачного опроса работающий процесс не останавливается
+	if _, bad := findBadConfig(l.classifyBadConfig(err)); bad && l.cfg.Strict {
+		cfg := *l.cfg
+		l.mu.Unlock()
+		l.auditReject(&cfg, rejected, err)
+		l.log.Error("config rejected in strict mode, stopping", "error", err)
+		select {
+		case l.failed <- errors.Wrap(err, "bad config in strict mode"):
+		default:
+		}
+		return
+	}
+
+	cfg := *l.cfg
+	cfg.UsesFallbackConfig = true
+	cfg.ConfigError = err.Error()
+	cfg.ConfigErrorFields = badConfigFields(err)
+	cfg.FallbackDiff = nil
+	if rejected != nil {
+		cfg.FallbackDiff = diffConfigs(flattenConfig(rejected), flattenConfig(cfg.App))
+	}
+	l.log.Error("config rejected, app keeps running on previous config", "error", err, "diff", cfg.FallbackDiff)
+	l.storeConfig(&cfg)
+	l.mu.Unlock()
+	l.events.OnFallbackApplied(cfg)
+	l.auditReject(&cfg, rejected, err)
+}
+
+// sourceError запоминает в ConfigError, что конфиг не удалось прочитать из источника.
+// Приложение при этом не подменялось, поэтому признак работы на прошлом конфиге не меняется
+func (l *AppLoader) sourceError(err error) {
+	if _, bad := findBadConfig(l.classifyBadConfig(err)); bad && l.Config().Strict {
+		l.rejectConfig(err, nil)
+		return
+	}
+	l.mu.Lock()
+	cfg := *l.cfg
+	cfg.ConfigError = err.Error()
+	cfg.ConfigErrorFields = badConfigFields(err)
+	l.storeConfig(&cfg)
+	l.mu.Unlock()
+	l.log.Error("failed to load config, app keeps running on current config", "error", err)
+	l.auditReject(&cfg, nil, err)
+}
+
+// clearSourceError убирает ошибку источника из ConfigError, когда он снова отдал работающий конфиг.
+// Ошибка отката не трогается: ее сбросит только подмена приложения
+func (l *AppLoader) clearSourceError() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cfg.UsesFallbackConfig || l.cfg.ConfigError == "" {
+		return
+	}
+	cfg := *l.cfg
+	cfg.ConfigError = ""
+	cfg.ConfigErrorFields = nil
+	l.storeConfig(&cfg)
+}
+
+// auditReject записывает в журнал отклоненный конфиг rejected, если его удалось прочитать
+func (l *AppLoader) auditReject(cfg *Config, rejected interface{}, err error) {
+	rec := *cfg
+	if rejected != nil {
+		rec.App = rejected
+	}
+	l.audit(AuditReject, &rec, nil, err)
+}
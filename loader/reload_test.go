@@ -0,0 +1,94 @@
+package loader
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+)
+
+func TestRejectConfigStrict(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  error
+		stop bool
+	}{
+		{"bad config", errors.Wrap(badPort(), "failed to build app"), true},
+		{"source error", errors.Wrap(errInfra, "failed to load config"), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &AppLoader{
+				cfg:    &Config{LoaderConfig: LoaderConfig{Strict: true, BadConfigMatch: BadConfigMatchAny}},
+				log:    NopLogger(),
+				failed: make(chan error, 1),
+			}
+			l.rejectConfig(tc.err, nil)
+			select {
+			case err := <-l.failed:
+				if !tc.stop {
+					t.Errorf("strict mode stopped on %v", err)
+				}
+			default:
+				if tc.stop {
+					t.Error("strict mode did not stop on bad config")
+				}
+			}
+		})
+	}
+}
+
+// flakySource отдает cfg или, пока задана, ошибку err
+type flakySource struct {
+	cfg fallbackTestConfig
+	err error
+}
+
+func (s *flakySource) Load(cfg interface{}) error {
+	if s.err != nil {
+		return s.err
+	}
+	*cfg.(*fallbackTestConfig) = s.cfg
+	return nil
+}
+
+func TestSourceErrorRecovery(t *testing.T) {
+	for name, poll := range map[string]func(l *AppLoader, last *interface{}){
+		"watch":  func(l *AppLoader, last *interface{}) { l.watchOnce(context.Background(), last) },
+		"reload": func(l *AppLoader, _ *interface{}) { _ = l.Reload() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			src := &flakySource{cfg: fallbackTestConfig{Host: "live", Port: 8080}}
+			l, err := New(
+				WithApp(fx.Options()),
+				WithAppConfig(&fallbackTestConfig{}),
+				WithConfigSource(src),
+				WithFallbackPath(filepath.Join(t.TempDir(), "fallback")),
+				WithLogger(NopLogger()),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := interface{}(&fallbackTestConfig{Host: "live", Port: 8080})
+
+			src.err = errInfra
+			poll(l, &last)
+			cfg := l.Config()
+			if cfg.UsesFallbackConfig {
+				t.Error("source error marked app as running on fallback config")
+			}
+			if cfg.ConfigError == "" {
+				t.Error("source error is not saved in ConfigError")
+			}
+
+			// источник снова отдает работающий конфиг
+			src.err = nil
+			poll(l, &last)
+			cfg = l.Config()
+			if cfg.UsesFallbackConfig || cfg.ConfigError != "" {
+				t.Errorf("stale state after source recovered: fallback %v, error %q", cfg.UsesFallbackConfig, cfg.ConfigError)
+			}
+		})
+	}
+}
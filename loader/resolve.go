@@ -0,0 +1,84 @@
+package loader
+
+import (
+	"context"
+	"reflect"
+)
+
+// ResolveTags заполняет поля cfg с тегом tag значениями, которые resolve возвращает по значению тега.
+// Так внешние хранилища секретов дописывают значения поверх уже загруженного конфига,
+// и дальше они проверяются и откатываются вместе с остальным конфигом.
+// Ошибки resolve и разбора значений возвращаются как ErrBadConfig с путями к полям,
+// сами значения в ошибку не попадают.
+func ResolveTags(cfg interface{}, tag string, resolve func(ref string) (string, error)) error {
+	var errs ValidationErrors
+	walkFields(cfg, func(f configField) {
+		ref, ok := f.Field.Tag.Lookup(tag)
+		if !ok {
+			return
+		}
+		value, err := resolve(ref)
+		if err != nil {
+			errs = append(errs, FieldError{Field: f.Path, Code: CodeUnresolved, Reason: err.Error()})
+			return
+		}
+		if err := setFieldValue(value, f.Value); err != nil {
+			errs = append(errs, FieldError{Field: f.Path, Code: CodeParse, Reason: err.Error()})
+		}
+	})
+	if len(errs) > 0 {
+		return ErrBadConfig{Fields: errs}
+	}
+	return nil
+}
+
+// SecretResolver - источник, который дописывает в конфиг секреты из внешнего хранилища по тегу SecretTag,
+// например vaultsource. Поля с этим тегом не попадают в сохраненный рабочий конфиг: перед сохранением
+// они обнуляются, а при откате на сохраненный конфиг запрашиваются заново через ResolveSecrets.
+// Если хранилище при откате недоступно, сохраненный конфиг считается непригодным
+type SecretResolver interface {
+	SecretTag() string
+	ResolveSecrets(ctx context.Context, cfg interface{}) error
+}
+
+// secretResolvers находит SecretResolver среди источника и слоев LayeredSource
+func secretResolvers(src ConfigSource) []SecretResolver {
+	if r, ok := src.(SecretResolver); ok {
+		return []SecretResolver{r}
+	}
+	var resolvers []SecretResolver
+	if ls, ok := src.(*LayeredSource); ok {
+		for _, layer := range ls.sources {
+			resolvers = append(resolvers, secretResolvers(layer)...)
+		}
+	}
+	return resolvers
+}
+
+// withoutSecrets возвращает копию appCfg с обнуленными полями секретов из внешних хранилищ
+// или сам appCfg, если таких хранилищ нет
+func (l *AppLoader) withoutSecrets(appCfg interface{}) interface{} {
+	resolvers := secretResolvers(l.source)
+	if len(resolvers) == 0 {
+		return appCfg
+	}
+	cfg := DeepCopy(appCfg)
+	for _, r := range resolvers {
+		walkFields(cfg, func(f configField) {
+			if _, ok := f.Field.Tag.Lookup(r.SecretTag()); ok && f.Value.CanSet() {
+				f.Value.Set(reflect.Zero(f.Value.Type()))
+			}
+		})
+	}
+	return cfg
+}
+
+// resolveSecrets заново запрашивает секреты сохраненного конфига appCfg
+func (l *AppLoader) resolveSecrets(ctx context.Context, appCfg interface{}) error {
+	for _, r := range secretResolvers(l.source) {
+		if err := r.ResolveSecrets(ctx, appCfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package loader
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// RollbackReason - почему приложение последний раз перешло на сохраненный или предыдущий конфиг.
+// Переживает перезапуск процесса: хранится рядом с сохраненным конфигом в файле LOADER_FALLBACK_PATH.rollback,
+// так что и после рестарта на исправленном конфиге видно, что было не так с отклоненным
+type RollbackReason struct {
+	ConfigError       string       `json:"config_error"`
+	ConfigErrorFields []FieldError `json:"config_error_fields,omitempty"`
+	FallbackIndex     int          `json:"fallback_index"`
+	// секции, которые откатились отдельно, см. WithConfig
+	FallbackSections []string  `json:"fallback_sections,omitempty"`
+	At               time.Time `json:"at"`
+	// запуск процесса, в котором был откат, и был ли это другой запуск
+	Boot         string `json:"boot"`
+	PreviousBoot bool   `json:"previous_boot"`
+}
+
+func rollbackReasonPath(cfg *Config) string {
+	return cfg.FallbackPath + ".rollback"
+}
+
+func readRollbackReason(path string) (*RollbackReason, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var reason RollbackReason
+	if err := json.Unmarshal(data, &reason); err != nil {
+		return nil, err
+	}
+	reason.PreviousBoot = reason.Boot != bootID
+	return &reason, nil
+}
+
+// initRollbackReason читает причину последнего отката, сохраненную прошлыми запусками,
+// и начинает записывать новые
+func (l *AppLoader) initRollbackReason() {
+	if l.cfg.FallbackStore == FallbackStoreMemory {
+		// в этом режиме на диск ничего не пишется, причина отката живет, пока жив процесс
+		l.events = append(l.events, &rollbackEvents{l: l})
+		return
+	}
+	path := rollbackReasonPath(l.cfg)
+	reason, err := readRollbackReason(path)
+	if err != nil {
+		l.log.Error("failed to read last rollback reason", "error", err)
+	}
+	if reason != nil {
+		l.lastRollback.Store(reason)
+		l.log.Info("last rollback", "at", reason.At, "previous_boot", reason.PreviousBoot, "error", reason.ConfigError)
+	}
+	l.events = append(l.events, &rollbackEvents{path: path, l: l})
+}
+
+// LastRollback возвращает причину последнего отката, в том числе из прошлых запусков процесса
+func (l *AppLoader) LastRollback() (RollbackReason, bool) {
+	reason := l.lastRollback.Load()
+	if reason == nil {
+		return RollbackReason{}, false
+	}
+	return *reason, true
+}
+
+// rollbackEvents сохраняет причину каждого отката
+type rollbackEvents struct {
+	NopEvents
+	// файл для причины отката, пустой - только в памяти
+	path string
+	l    *AppLoader
+}
+
+func (e *rollbackEvents) OnFallbackApplied(cfg Config) {
+	// на диск и в статус не должны попасть секреты из значений полей
+	cfg = cfg.Redacted()
+	reason := RollbackReason{
+		ConfigError:       cfg.ConfigError,
+		ConfigErrorFields: cfg.ConfigErrorFields,
+		FallbackIndex:     cfg.FallbackIndex,
+		FallbackSections:  cfg.FallbackSections,
+		At:                time.Now().UTC(),
+		Boot:              bootID,
+	}
+	e.l.lastRollback.Store(&reason)
+	if e.path == "" {
+		return
+	}
+	if err := writeStateFile(e.path, reason); err != nil {
+		e.l.log.Error("failed to save last rollback reason", "error", err)
+	}
+}
@@ -0,0 +1,304 @@
+// Package s3store хранит последний рабочий конфиг loader в S3-совместимом
+// объектном хранилище (AWS S3, MinIO). Запросы подписываются SigV4 без AWS SDK.
+//
+// Если для бакета включено версионирование, каждое сохранение создает новую
+// версию объекта, и история рабочих конфигов остается в бакете.
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sgrishanin/fx-rollback-proto/loader"
+)
+
+const (
+	defaultRegion  = "us-east-1"
+	defaultTimeout = time.Second * 10
+)
+
+// Store реализует loader.FallbackStore.
+// Используется path-style адресация (endpoint/bucket/key), которую поддерживает и MinIO.
+type Store struct {
+	endpoint *url.URL
+	bucket   string
+	key      string
+	region   string
+	creds    credentials
+	client   *http.Client
+}
+
+type Option func(s *Store)
+
+// WithRegion задает регион для подписи, по умолчанию берется из AWS_REGION
+func WithRegion(region string) Option {
+	return func(s *Store) {
+		s.region = region
+	}
+}
+
+// WithCredentials задает ключи доступа, по умолчанию они берутся из
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY и AWS_SESSION_TOKEN
+func WithCredentials(accessKey, secretKey, sessionToken string) Option {
+	return func(s *Store) {
+		s.creds = credentials{
+			accessKey:    accessKey,
+			secretKey:    secretKey,
+			sessionToken: sessionToken,
+		}
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *Store) {
+		s.client = client
+	}
+}
+
+// New создает хранилище для объекта key в бакете bucket.
+// endpoint - адрес хранилища со схемой, например https://s3.eu-west-1.amazonaws.com или http://minio:9000
+func New(endpoint, bucket, key string, opts ...Option) (*Store, error) {
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid s3 endpoint")
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.Errorf("s3 endpoint %q must contain scheme and host", endpoint)
+	}
+	if bucket == "" || key == "" {
+		return nil, errors.New("s3 bucket and key can't be empty")
+	}
+
+	s := &Store{
+		endpoint: u,
+		bucket:   bucket,
+		key:      strings.TrimPrefix(key, "/"),
+		region:   os.Getenv("AWS_REGION"),
+		creds: credentials{
+			accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		},
+		client: &http.Client{Timeout: defaultTimeout},
+	}
+	if s.region == "" {
+		s.region = defaultRegion
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+var (
+	_ loader.ContextStore        = (*Store)(nil)
+	_ loader.ContextHistoryStore = (*Store)(nil)
+	_ loader.DeleteStore         = (*Store)(nil)
+)
+
+func (s *Store) Save(data []byte) error {
+	return s.SaveContext(context.Background(), data)
+}
+
+func (s *Store) SaveContext(ctx context.Context, data []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, s.key, nil, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+func (s *Store) Load() ([]byte, error) {
+	return s.LoadContext(context.Background())
+}
+
+func (s *Store) LoadContext(ctx context.Context) ([]byte, error) {
+	return s.loadVersion(ctx, "")
+}
+
+// LoadVersion загружает конкретную версию объекта, пустой versionID - последнюю
+func (s *Store) LoadVersion(versionID string) ([]byte, error) {
+	return s.loadVersion(context.Background(), versionID)
+}
+
+func (s *Store) loadVersion(ctx context.Context, versionID string) ([]byte, error) {
+	query := url.Values{}
+	if versionID != "" {
+		query.Set("versionId", versionID)
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.key, query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, loader.ErrFallbackNotFound
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read s3 object")
+	}
+	return data, nil
+}
+
+// Version описывает одну сохраненную версию конфига
+type Version struct {
+	ID           string    `xml:"VersionId"`
+	LastModified time.Time `xml:"LastModified"`
+	IsLatest     bool      `xml:"IsLatest"`
+	Size         int64     `xml:"Size"`
+}
+
+// Versions возвращает версии объекта с конфигом, от новых к старым.
+// Без включенного версионирования бакета версия будет одна.
+func (s *Store) Versions() ([]Version, error) {
+	return s.versions(context.Background())
+}
+
+func (s *Store) versions(ctx context.Context) ([]Version, error) {
+	query := url.Values{
+		"versions": {""},
+		"prefix":   {s.key},
+	}
+	resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Versions []struct {
+			Key string `xml:"Key"`
+			Version
+		} `xml:"Version"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.Wrap(err, "failed to decode s3 versions")
+	}
+
+	versions := make([]Version, 0, len(result.Versions))
+	for _, v := range result.Versions {
+		// prefix может зацепить соседние объекты вроде key.bak
+		if v.Key == s.key {
+			versions = append(versions, v.Version)
+		}
+	}
+	return versions, nil
+}
+
+// LoadHistory загружает не больше n последних версий конфига, от новых к старым
+func (s *Store) LoadHistory(n int) ([][]byte, error) {
+	return s.LoadHistoryContext(context.Background(), n)
+}
+
+func (s *Store) LoadHistoryContext(ctx context.Context, n int) ([][]byte, error) {
+	versions, err := s.versions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) > n {
+		versions = versions[:n]
+	}
+	history := make([][]byte, 0, len(versions))
+	for _, v := range versions {
+		data, err := s.loadVersion(ctx, v.ID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load version %s", v.ID)
+		}
+		history = append(history, data)
+	}
+	return history, nil
+}
+
+// Delete удаляет последнюю версию объекта с конфигом. При включенном версионировании
+// последней становится предыдущая версия, без него объект удаляется целиком
+func (s *Store) Delete() error {
+	ctx := context.Background()
+	versions, err := s.versions(ctx)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return loader.ErrFallbackNotFound
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.key, url.Values{"versionId": {versions[0].ID}}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+// EnableVersioning включает версионирование бакета, чтобы хранились все сохраненные конфиги
+func (s *Store) EnableVersioning() error {
+	body := []byte(`<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Status>Enabled</Status></VersioningConfiguration>`)
+	resp, err := s.do(context.Background(), http.MethodPut, "", url.Values{"versioning": {""}}, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+func (s *Store) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	segments := []string{uriEncode(s.bucket)}
+	if key != "" {
+		for _, segment := range strings.Split(key, "/") {
+			segments = append(segments, uriEncode(segment))
+		}
+	}
+	rawPath := strings.TrimSuffix(s.endpoint.EscapedPath(), "/") + "/" + strings.Join(segments, "/")
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return nil, err
+	}
+
+	u := *s.endpoint
+	u.Path = path
+	u.RawPath = rawPath
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	signRequest(req, body, s.creds, s.region, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "s3 request %s %s failed", method, u.Path)
+	}
+	return resp, nil
+}
+
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	var s3err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err := xml.Unmarshal(body, &s3err); err == nil && s3err.Code != "" {
+		return errors.Errorf("s3 responded with %s: %s: %s", resp.Status, s3err.Code, s3err.Message)
+	}
+	return errors.Errorf("s3 responded with %s", resp.Status)
+}
@@ -0,0 +1,54 @@
+package loader
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrSkipSave возвращает хук из WithBeforeSave, чтобы конфиг не сохранялся как рабочий.
+// Это не ошибка сохранения: в лог пишется только info, а прошлый сохраненный конфиг остается
+var ErrSkipSave = errors.New("config save skipped by hook")
+
+// saveHook получает копию конфига приложения и возвращает то, что нужно сохранить
+type saveHook func(cfg interface{}) (interface{}, error)
+
+// WithBeforeSave добавляет хук, который вызывается перед сохранением рабочего конфига приложения.
+// Хук получает копию конфига, так что работающее приложение изменения не видят, и возвращает
+// конфиг для сохранения: например, без одноразовых токенов или с нормализованными значениями.
+// Если вернуть nil, сохраняется переданная копия, если ErrSkipSave - конфиг не сохраняется.
+// Хуки вызываются по порядку, каждый получает результат предыдущего.
+// T - тип конфига из WithAppConfig или Load[T]. После отката приложение получит конфиг,
+// каким его сохранили хуки
+func WithBeforeSave[T any](hook func(cfg *T) (*T, error)) Option {
+	return func(l *AppLoader) {
+		l.saveHooks = append(l.saveHooks, func(cfg interface{}) (interface{}, error) {
+			typed, ok := cfg.(*T)
+			if !ok {
+				return nil, errors.Errorf("before save hook expects %T, got %T", (*T)(nil), cfg)
+			}
+			res, err := hook(typed)
+			if err != nil || res == nil {
+				return typed, err
+			}
+			return res, nil
+		})
+	}
+}
+
+// savedForm возвращает конфиг приложения appCfg в том виде, в котором его сохранят хуки WithBeforeSave,
+// без секретов из внешних хранилищ, см. SecretResolver
+func (l *AppLoader) savedForm(appCfg interface{}) (interface{}, error) {
+	cfg := l.withoutSecrets(appCfg)
+	if len(l.saveHooks) == 0 {
+		return cfg, nil
+	}
+	if cfg == appCfg {
+		cfg = DeepCopy(appCfg)
+	}
+	for _, hook := range l.saveHooks {
+		var err error
+		if cfg, err = hook(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,128 @@
+package loader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSchemaMismatch означает, что сохраненный конфиг записан для другой структуры конфига
+var ErrSchemaMismatch = errors.New("fallback config schema mismatch")
+
+// что делать, если схема сохраненного конфига не совпадает с текущей (LOADER_SCHEMA_MISMATCH)
+const (
+	// не использовать сохраненный конфиг
+	SchemaMismatchReject = "reject"
+	// прочитать совпадающие поля поверх текущего конфига, остальные оставить как есть
+	SchemaMismatchMerge = "merge"
+	// передать сохраненный конфиг в SchemaMigration
+	SchemaMismatchMigrate = "migrate"
+)
+
+// SchemaMigration переводит конфиг, сохраненный со схемой fromSchema, в текущую структуру dst.
+// payload читается переданным codec загрузчика.
+type SchemaMigration func(fromSchema string, payload []byte, codec ConfigCodec, dst interface{}) error
+
+// теги, от которых зависит, как поле записывается в сохраненный конфиг и читается из него
+var schemaTags = []string{"json", "yaml", "envconfig"}
+
+// schemaHash строит отпечаток структуры конфига по именам, типам и тегам полей из schemaTags,
+// так что схему меняет любое изменение, после которого сохраненный конфиг читается иначе.
+// Остальные теги (desc, validate, default, flag, reload) на чтение не влияют и схему не меняют
+func schemaHash(t reflect.Type) string {
+	b := strings.Builder{}
+	writeSchema(&b, t, map[reflect.Type]bool{})
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:8])
+}
+
+func writeSchema(b *strings.Builder, t reflect.Type, seen map[reflect.Type]bool) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		fmt.Fprintf(b, "%s[", t.Kind())
+		writeSchema(b, t.Elem(), seen)
+		b.WriteString("]")
+	case reflect.Map:
+		b.WriteString("map[")
+		writeSchema(b, t.Key(), seen)
+		b.WriteString("]")
+		writeSchema(b, t.Elem(), seen)
+	case reflect.Struct:
+		if seen[t] {
+			fmt.Fprintf(b, "%s", t)
+			return
+		}
+		seen[t] = true
+		b.WriteString("{")
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fmt.Fprintf(b, "%s %q ", f.Name, schemaTag(f.Tag))
+			writeSchema(b, f.Type, seen)
+			b.WriteString(";")
+		}
+		b.WriteString("}")
+	default:
+		b.WriteString(t.String())
+	}
+}
+
+// schemaTag оставляет от тега поля только теги из schemaTags
+func schemaTag(tag reflect.StructTag) string {
+	var parts []string
+	for _, key := range schemaTags {
+		if value, ok := tag.Lookup(key); ok {
+			parts = append(parts, fmt.Sprintf("%s:%q", key, value))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// декодирует сохраненный конфиг в dst с учетом политики несовпадения схем
+func (l *AppLoader) decodeFallback(header snapshotHeader, versioned bool, payload []byte, dst interface{}) error {
+	// у конфигов, сохраненных до версионирования, схемы нет - читаем их как раньше
+	if !versioned || header.Schema == l.schema {
+		return l.codec.Decode(payload, dst)
+	}
+
+	switch l.cfg.SchemaMismatch {
+	case SchemaMismatchMerge:
+		return l.codec.Decode(payload, dst)
+	case SchemaMismatchMigrate:
+		if err := l.migrate(header.Schema, payload, l.codec, dst); err != nil {
+			return errors.Wrapf(err, "failed to migrate fallback config from schema %s", header.Schema)
+		}
+		return nil
+	default:
+		return errors.Wrapf(ErrSchemaMismatch, "saved with schema %s, current schema is %s", header.Schema, l.schema)
+	}
+}
+
+// mergesFallback проверяет, что сохраненный конфиг читается поверх текущего, см. SchemaMismatchMerge
+func (l *AppLoader) mergesFallback(header snapshotHeader, versioned bool) bool {
+	return versioned && header.Schema != l.schema && l.cfg.SchemaMismatch == SchemaMismatchMerge
+}
+
+func (l *AppLoader) initSchema() error {
+	if l.schema == "" {
+		l.schema = schemaHash(reflect.TypeOf(l.cfg.App))
+	}
+	switch l.cfg.SchemaMismatch {
+	case "":
+		l.cfg.SchemaMismatch = SchemaMismatchReject
+		if l.migrate != nil {
+			l.cfg.SchemaMismatch = SchemaMismatchMigrate
+		}
+	case SchemaMismatchReject, SchemaMismatchMerge:
+	case SchemaMismatchMigrate:
+		if l.migrate == nil {
+			return errors.New("schema mismatch policy is migrate, but no migration is set")
+		}
+	default:
+		return errors.Errorf("unknown schema mismatch policy %q", l.cfg.SchemaMismatch)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// sdNotifier сообщает systemd о состоянии сервиса по протоколу sd_notify (см. LOADER_SD_NOTIFY):
+// пишет датаграммы в сокет NOTIFY_SOCKET, который systemd передает сервисам с Type=notify
+type sdNotifier struct {
+	addr *net.UnixAddr
+}
+
+// newSDNotifier возвращает nil, если процесс запущен не из systemd и сокета нет
+func newSDNotifier() *sdNotifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// абстрактный сокет Linux
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	return &sdNotifier{addr: &net.UnixAddr{Name: socket, Net: "unixgram"}}
+}
+
+// notify отправляет systemd строки состояния, например READY=1
+func (n *sdNotifier) notify(lines ...string) error {
+	conn, err := net.DialUnix(n.addr.Net, nil, n.addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to systemd notify socket")
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		return errors.Wrap(err, "failed to notify systemd")
+	}
+	return nil
+}
+
+// watchdogInterval возвращает, как часто слать WATCHDOG=1, если в юните задан WatchdogSec.
+// systemd ждет keepalive раз в WATCHDOG_USEC, шлем в два раза чаще, как советует sd_watchdog_enabled
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// WATCHDOG_PID задан, если watchdog предназначен не нам, а, например, родительскому процессу
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// sdStatus - строка STATUS= для systemctl status: на каком конфиге работает приложение
+func sdStatus(cfg Config) string {
+	switch {
+	case cfg.UsesFallbackConfig:
+		return fmt.Sprintf("running on fallback config #%d: %s", cfg.FallbackIndex, cfg.ConfigError)
+	case len(cfg.FallbackSections) > 0:
+		return fmt.Sprintf("running with fallback sections %s: %s", strings.Join(cfg.FallbackSections, ", "), cfg.ConfigError)
+	case cfg.CanaryHeld:
+		return "running on known-good config, new config is held for canaries"
+	case cfg.ConfigError != "":
+		return "running, last config rejected: " + cfg.ConfigError
+	}
+	return "running on config " + cfg.ConfigHash
+}
+
+// notifySystemd вызывается, когда приложение стартовало: сообщает systemd READY=1 и текущий STATUS,
+// обновляет STATUS при смене конфига и, если задан WatchdogSec, шлет keepalive, пока проверки
+// здоровья приложения проходят. Работает, пока не отменен ctx
+func (l *AppLoader) notifySystemd(ctx context.Context) {
+	n := newSDNotifier()
+	if n == nil {
+		l.log.Info("systemd notify socket is not set, sd_notify is disabled")
+		return
+	}
+	// секретные значения из конфига не должны попасть в systemctl status и журнал
+	if err := n.notify("READY=1", "STATUS="+sdStatus(l.Config().Redacted()), "MAINPID="+strconv.Itoa(os.Getpid())); err != nil {
+		l.log.Error("failed to notify systemd", "error", err)
+	}
+	configs := l.Subscribe(ctx)
+
+	var keepalive <-chan time.Time
+	if interval := watchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cfg, ok := <-configs:
+			if !ok {
+				return
+			}
+			if err := n.notify("STATUS=" + sdStatus(cfg.Redacted())); err != nil {
+				l.log.Error("failed to notify systemd", "error", err)
+			}
+		case <-keepalive:
+			// пока приложение пересобирается, проверки не выполняются и keepalive идет,
+			// а упавшие проверки работающего приложения его останавливают, и systemd перезапустит сервис
+			if h := l.Health(); len(h.Failed) > 0 {
+				l.log.Error("app is unhealthy, skipping systemd watchdog keepalive", "failed", h.Failed)
+				continue
+			}
+			if err := n.notify("WATCHDOG=1"); err != nil {
+				l.log.Error("failed to notify systemd", "error", err)
+			}
+		}
+	}
+}
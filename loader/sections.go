@@ -0,0 +1,226 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+)
+
+// section - отдельный конфиг модуля со своим префиксом, см. WithConfig
+type section struct {
+	// имя секции в нижнем регистре: префикс переменных окружения и ключ в сохраненном конфиге
+	name string
+	ptr  interface{}
+}
+
+// sectionsConfig собирает из секций одну структуру конфига приложения:
+// каждая секция становится полем-указателем с тегами envconfig и json по ее имени
+func sectionsConfig(sections []section) (interface{}, error) {
+	fields := make([]reflect.StructField, 0, len(sections))
+	seen := map[string]bool{}
+	for i, s := range sections {
+		t := reflect.TypeOf(s.ptr)
+		if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+			return nil, errors.Errorf("config %q must be a pointer to struct", s.name)
+		}
+		if s.name == "" {
+			return nil, errors.New("config prefix must not be empty")
+		}
+		if seen[s.name] {
+			return nil, errors.Errorf("config %q is set twice", s.name)
+		}
+		seen[s.name] = true
+		fields = append(fields, reflect.StructField{
+			Name: "Section" + strconv.Itoa(i),
+			Type: t,
+			Tag:  reflect.StructTag(fmt.Sprintf(`envconfig:"%s" json:"%s"`, s.name, s.name)),
+		})
+	}
+	v := reflect.New(reflect.StructOf(fields))
+	for i, s := range sections {
+		v.Elem().Field(i).Set(reflect.ValueOf(s.ptr))
+	}
+	return v.Interface(), nil
+}
+
+// sectionValues возвращает указатели на конфиги секций внутри конфига приложения
+func sectionValues(appCfg interface{}) []reflect.Value {
+	v := reflect.ValueOf(appCfg).Elem()
+	values := make([]reflect.Value, v.NumField())
+	for i := range values {
+		values[i] = v.Field(i)
+	}
+	return values
+}
+
+// sectionValidators вызывают Validate у секций, которые его реализуют.
+// Пути к полям в ошибках получают имя секции, а ошибка без полей относится ко всей секции.
+func (l *AppLoader) sectionValidators() []Validator {
+	validators := make([]Validator, 0, len(l.sections))
+	for i, s := range l.sections {
+		i, name := i, s.name
+		validators = append(validators, ValidatorFunc(func(appCfg interface{}) error {
+			sv, ok := sectionValues(appCfg)[i].Interface().(SelfValidator)
+			if !ok {
+				return nil
+			}
+			err := sv.Validate()
+			if err == nil {
+				return nil
+			}
+			var ve ValidationErrors
+			var fe FieldError
+			var be ErrBadConfig
+			switch {
+			case errors.As(err, &be) && be.Cause == nil:
+				ve = be.Fields
+			case errors.As(err, &ve):
+			case errors.As(err, &fe):
+				ve = ValidationErrors{fe}
+			default:
+				return BadField(name, nil, err.Error())
+			}
+			out := make(ValidationErrors, len(ve))
+			for j, fe := range ve {
+				fe.Field = joinPath(name, fe.Field)
+				out[j] = fe
+			}
+			return out
+		}))
+	}
+	return validators
+}
+
+// badSections находит секции, к которым относятся плохие поля из err.
+// ok == false, если хотя бы одно поле не удалось отнести к секции.
+func (l *AppLoader) badSections(err error) (names []string, ok bool) {
+	fields := badConfigFields(err)
+	if len(fields) == 0 {
+		return nil, false
+	}
+	bad := map[string]bool{}
+	for _, fe := range fields {
+		name, found := l.fieldSection(fe.Field)
+		if !found {
+			return nil, false
+		}
+		bad[name] = true
+	}
+	for _, s := range l.sections {
+		if bad[s.name] {
+			names = append(names, s.name)
+		}
+	}
+	return names, true
+}
+
+// fieldSection находит секцию по пути к полю (db.port) или имени переменной окружения (APP_DB_PORT)
+func (l *AppLoader) fieldSection(field string) (string, bool) {
+	for _, s := range l.sections {
+		if field == s.name || strings.HasPrefix(field, s.name+".") {
+			return s.name, true
+		}
+		key := strings.ToUpper(s.name)
+		if l.prefix != "" {
+			key = strings.ToUpper(l.prefix) + "_" + key
+		}
+		if strings.HasPrefix(field, key+"_") {
+			return s.name, true
+		}
+	}
+	return "", false
+}
+
+// sectionFallback собирает копию appCfg, в которой плохие секции из err взяты из from.
+// Возвращает nil, если откатывать по секциям нечего: ошибку нельзя отнести к секциям
+// или плохи все секции сразу.
+func (l *AppLoader) sectionFallback(appCfg, from interface{}, err error) (interface{}, []string) {
+	if len(l.sections) == 0 {
+		return nil, nil
+	}
+	bad, ok := l.badSections(err)
+	if !ok || len(bad) == 0 || len(bad) == len(l.sections) {
+		return nil, nil
+	}
+	// копия, чтобы merged не делил с appCfg слайсы и map
+	merged := DeepCopy(appCfg)
+	dst, src := sectionValues(merged), sectionValues(from)
+	for i, s := range l.sections {
+		for _, name := range bad {
+			if s.name == name {
+				dst[i].Set(src[i])
+			}
+		}
+	}
+	return merged, bad
+}
+
+// buildSectionFallback пробует собрать приложение, откатив на сохраненный конфиг только плохие секции.
+// Возвращает nil, если по секциям откатиться не получилось.
+func (l *AppLoader) buildSectionFallback(ctx context.Context, cfg *Config, history [][]byte, configError error, rejected map[string]flatValue) *fx.App {
+	if len(l.sections) == 0 {
+		return nil
+	}
+	for i, data := range history {
+		from := newAppConfig(cfg.App)
+		header, err := l.applyFallbackConfig(ctx, data, from)
+		if err != nil {
+			continue
+		}
+		merged, bad := l.sectionFallback(cfg.App, from, configError)
+		if merged == nil {
+			return nil
+		}
+		next := *cfg
+		next.App = merged
+		next.UsesFallbackConfig = true
+		next.FallbackIndex = i
+		next.FallbackSavedAt = header.SavedAt
+		next.FallbackSections = bad
+		next.FallbackDiff = diffConfigs(rejected, flattenConfig(merged))
+		next.ConfigError = configError.Error()
+		next.ConfigErrorFields = badConfigFields(configError)
+
+		app, err := l.buildApp(ctx, &next)
+		if err != nil {
+			continue
+		}
+		*cfg = next
+		l.log.Info("app built with fallback config for sections", "sections", bad, "index", i, "diff", next.FallbackDiff, "config_hash", next.ConfigHash)
+		l.events.OnFallbackApplied(next)
+		return app
+	}
+	return nil
+}
+
+// reloadSections подменяет приложение на конфиг, в котором плохие секции bad
+// оставлены на текущих значениях. Такой конфиг не сохраняется как рабочий.
+func (l *AppLoader) reloadSections(ctx context.Context, prev Config, appCfg, merged interface{}, bad []string, configError error) error {
+	next := prev
+	next.App = merged
+	next.UsesFallbackConfig = true
+	next.FallbackIndex = 0
+	next.FallbackSavedAt = nil
+	next.FallbackSections = bad
+	next.FallbackDiff = diffConfigs(flattenConfig(appCfg), flattenConfig(merged))
+	next.ConfigError = configError.Error()
+	next.ConfigErrorFields = badConfigFields(configError)
+
+	app, err := l.buildApp(ctx, &next)
+	if err != nil {
+		l.rejectConfig(configError, appCfg)
+		return errors.Wrap(err, "failed to create app with new config")
+	}
+	if err := l.swap(&prev, &next, app); err != nil {
+		l.rejectConfig(err, appCfg)
+		return err
+	}
+	l.log.Error("config partially rejected, bad sections keep previous values", "sections", bad, "error", configError, "diff", next.FallbackDiff, "config_hash", next.ConfigHash)
+	l.events.OnFallbackApplied(next)
+	return errors.Wrap(configError, "failed to apply new config to sections")
+}
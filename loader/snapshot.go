@@ -0,0 +1,78 @@
+package loader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// snapshotHeader - служебная информация, которая сохраняется вместе с конфигом
+type snapshotHeader struct {
+	Schema string `json:"schema,omitempty"`
+	// sha256 конфига, чтобы не применять обрезанный или поврежденный файл
+	Checksum string `json:"sha256,omitempty"`
+	// когда конфиг был сохранен
+	SavedAt *time.Time `json:"saved_at,omitempty"`
+	// таймауты загрузчика вместе с конфигом, см. LOADER_FALLBACK_LOADER_CONFIG
+	Timeouts *loaderTimeouts `json:"loader_timeouts,omitempty"`
+}
+
+// ErrFallbackCorrupted означает, что сохраненный конфиг не прошел проверку контрольной суммы
+var ErrFallbackCorrupted = errors.New("fallback corrupted")
+
+type corruptedError string
+
+func corrupted(reason string) error { return corruptedError(reason) }
+
+func (e corruptedError) Error() string { return ErrFallbackCorrupted.Error() + ": " + string(e) }
+
+func (e corruptedError) Is(target error) bool { return target == ErrFallbackCorrupted }
+
+// заголовок пишется строкой-комментарием перед конфигом, так что yaml остается валидным,
+// а json и yaml можно читать и править руками
+const snapshotMagic = "# loader-snapshot "
+
+func encodeSnapshot(header snapshotHeader, payload []byte) ([]byte, error) {
+	header.Checksum = checksum(payload)
+	h, err := json.Marshal(header)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode snapshot header")
+	}
+	buf := bytes.Buffer{}
+	buf.WriteString(snapshotMagic)
+	buf.Write(h)
+	buf.WriteByte('\n')
+	buf.Write(payload)
+	return buf.Bytes(), nil
+}
+
+// decodeSnapshot отделяет заголовок от конфига.
+// ok == false, если заголовка нет - так выглядят конфиги, сохраненные старыми версиями.
+// Если в заголовке есть контрольная сумма и она не сходится, возвращается ErrFallbackCorrupted
+func decodeSnapshot(data []byte) (header snapshotHeader, payload []byte, ok bool, err error) {
+	if !bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return header, data, false, nil
+	}
+	data = data[len(snapshotMagic):]
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return header, nil, false, corrupted("snapshot header is not terminated")
+	}
+	if err := json.Unmarshal(data[:end], &header); err != nil {
+		return header, nil, false, corrupted("failed to decode snapshot header: " + err.Error())
+	}
+	payload = data[end+1:]
+	if header.Checksum != "" && header.Checksum != checksum(payload) {
+		return header, nil, false, corrupted("checksum mismatch")
+	}
+	return header, payload, true, nil
+}
+
+func checksum(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
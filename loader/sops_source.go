@@ -0,0 +1,92 @@
+package loader
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// утилита, которой расшифровываются файлы sops, ищется в PATH
+const sopsBinary = "sops"
+
+// сколько ждать sops, если загрузку не ограничивает ctx: с KMS расшифровка идет в облако
+const defaultSOPSTimeout = 30 * time.Second
+
+// SOPSSource загружает конфиг из yaml или json файла, зашифрованного sops (age, AWS/GCP KMS, Azure Key Vault,
+// PGP). Файл расшифровывается утилитой sops при каждой загрузке, так что зашифрованный конфиг можно хранить
+// в git, а расшифрованный не попадает на диск. Ключи sops находит сам: SOPS_AGE_KEY_FILE, учетные данные облака.
+// FileSource сам узнает файлы sops по метаданным, так что SOPSSource нужен, только чтобы требовать шифрования.
+// Ключи и значения разбираются так же, как в FileSource.
+// Если файл не удалось расшифровать (не тот ключ, файл поврежден или изменен без sops), Load возвращает
+// ErrBadConfig, и загрузчик откатывается на последний рабочий конфиг. Если sops не установлен - обычную ошибку.
+type SOPSSource struct {
+	path   string
+	format string
+}
+
+// NewSOPSSource создает источник, формат которого определяется по расширению файла
+func NewSOPSSource(path string) *SOPSSource {
+	return &SOPSSource{path: path, format: NewFileSource(path).format}
+}
+
+func (s *SOPSSource) String() string {
+	return "sops " + s.path
+}
+
+func (s *SOPSSource) Load(cfg interface{}) error {
+	return s.LoadContext(context.Background(), cfg)
+}
+
+// LoadContext прерывает sops, когда отменен ctx
+func (s *SOPSSource) LoadContext(ctx context.Context, cfg interface{}) error {
+	values, err := decryptSOPS(ctx, s.path, s.format)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("config must be a struct pointer")
+	}
+	return decodeValues(values, v.Elem(), "")
+}
+
+// isSOPSFile проверяет, что разобранный файл зашифрован sops: sops кладет в него ключ sops
+// с зашифрованными ключами данных и mac всего файла
+func isSOPSFile(values map[string]interface{}) bool {
+	meta, ok := values["sops"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = meta["mac"]
+	return ok
+}
+
+// decryptSOPS расшифровывает файл path утилитой sops и возвращает разобранные значения
+func decryptSOPS(ctx context.Context, path, format string) (map[string]interface{}, error) {
+	if format != FileFormatYAML && format != FileFormatJSON {
+		return nil, errors.Errorf("sops config file %s must be yaml or json, got %q", path, format)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultSOPSTimeout)
+		defer cancel()
+	}
+	out, err := runCommand(ctx, sopsBinary, "--decrypt", "--input-type", format, "--output-type", FileFormatJSON, path)
+	var exitErr *commandError
+	switch {
+	case errors.As(err, &exitErr):
+		return nil, ErrBadConfig{Cause: errors.Wrapf(err, "failed to decrypt config file %s", path)}
+	case errors.Is(err, exec.ErrNotFound):
+		return nil, errors.Wrap(err, "sops is required to decrypt config file "+path)
+	case err != nil:
+		return nil, errors.Wrapf(err, "failed to decrypt config file %s", path)
+	}
+	values, err := parseConfigFile(out, FileFormatJSON)
+	if err != nil {
+		return nil, ErrBadConfig{Cause: errors.Wrapf(err, "failed to parse decrypted config file %s", path)}
+	}
+	return values, nil
+}
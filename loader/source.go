@@ -0,0 +1,288 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/pkg/errors"
+)
+
+// ConfigSource загружает конфиг приложения.
+// Если значения не удается разобрать, Load должен вернуть ErrBadConfig -
+// тогда загрузчик откатится на последний рабочий конфиг.
+// Остальные ошибки считаются инфраструктурными и прерывают загрузку.
+type ConfigSource interface {
+	Load(cfg interface{}) error
+}
+
+// NotifyingSource - источник, который сам сообщает об изменениях конфига.
+// С LOADER_WATCH загрузчик не опрашивает такой источник, а перечитывает конфиг
+// на каждое событие из канала Watch. Канал закрывается, когда отменен ctx.
+type NotifyingSource interface {
+	ConfigSource
+	Watch(ctx context.Context) <-chan struct{}
+}
+
+// EnvSource загружает конфиг из переменных окружения с префиксом по правилам envconfig
+type EnvSource struct {
+	prefix string
+	lookup func(key string) (string, bool)
+	// перечисляет переменные окружения для LOADER_UNKNOWN_ENV, у NewLookupSource не задан
+	environ func() []string
+	naming  EnvNaming
+	// переменные профиля под этим префиксом читает свой слой, см. LOADER_PROFILE
+	profilePrefix string
+}
+
+func NewEnvSource(prefix string) *EnvSource {
+	return &EnvSource{prefix: prefix, lookup: os.LookupEnv, environ: os.Environ}
+}
+
+// NewEnvSourceNaming создает источник, который строит имена переменных по своим правилам naming,
+// например NewEnvSourceNaming("app", EnvNaming{Separator: "__", Case: EnvCaseLower}) читает app__server__port
+func NewEnvSourceNaming(prefix string, naming EnvNaming) *EnvSource {
+	return &EnvSource{prefix: prefix, lookup: os.LookupEnv, environ: os.Environ, naming: naming}
+}
+
+// NewLookupSource создает источник, который разбирает конфиг по правилам envconfig,
+// но берет значения переменных из lookup, а не из окружения процесса
+func NewLookupSource(prefix string, lookup func(key string) (string, bool)) *EnvSource {
+	return &EnvSource{prefix: prefix, lookup: lookup}
+}
+
+func (s *EnvSource) String() string {
+	return "env " + s.prefix
+}
+
+func (s *EnvSource) Load(cfg interface{}) error {
+	return loadEnv(s.prefix, cfg, s.lookup, s.naming, true)
+}
+
+func (s *EnvSource) loadLayer(cfg interface{}) error {
+	return loadEnv(s.prefix, cfg, s.lookup, s.naming, false)
+}
+
+func loadEnv(prefix string, cfg interface{}, lookup func(key string) (string, bool), naming EnvNaming, withDefaults bool) error {
+	err := processEnvLayer(prefix, cfg, lookup, naming, withDefaults)
+	if err == nil {
+		return nil
+	}
+	parseErr := &envconfig.ParseError{}
+	if errors.As(err, &parseErr) {
+		return BadFieldCode(parseErr.KeyName, parseErr.Value, CodeParse, parseErr.Err.Error())
+	}
+	missingErr := &missingKeyError{}
+	if errors.As(err, &missingErr) {
+		return BadFieldCode(missingErr.Key, nil, CodeRequired, "missing value")
+	}
+	return err
+}
+
+// EnvFileSource загружает конфиг из .env файла.
+// Переменные окружения процесса имеют приоритет над значениями из файла.
+// Файл перечитывается при каждой загрузке, поэтому его можно менять для hot reload.
+type EnvFileSource struct {
+	prefix string
+	path   string
+	naming EnvNaming
+	// переменные профиля под этим префиксом читает свой слой, см. LOADER_PROFILE
+	profilePrefix string
+}
+
+func NewEnvFileSource(prefix, path string) *EnvFileSource {
+	return &EnvFileSource{prefix: prefix, path: path}
+}
+
+// NewEnvFileSourceNaming - то же, что NewEnvSourceNaming, но для .env файла
+func NewEnvFileSourceNaming(prefix, path string, naming EnvNaming) *EnvFileSource {
+	return &EnvFileSource{prefix: prefix, path: path, naming: naming}
+}
+
+func (s *EnvFileSource) String() string {
+	return "env file " + s.path
+}
+
+func (s *EnvFileSource) Load(cfg interface{}) error {
+	return s.load(cfg, true)
+}
+
+func (s *EnvFileSource) loadLayer(cfg interface{}) error {
+	return s.load(cfg, false)
+}
+
+func (s *EnvFileSource) load(cfg interface{}, withDefaults bool) error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return ErrBadConfig{Cause: errors.Wrap(err, "failed to read env file")}
+	}
+	vars, err := parseEnvFile(data)
+	if err != nil {
+		return ErrBadConfig{Cause: errors.Wrapf(err, "failed to parse env file %s", s.path)}
+	}
+	return loadEnv(s.prefix, cfg, func(key string) (string, bool) {
+		if value, ok := os.LookupEnv(key); ok {
+			return value, true
+		}
+		value, ok := vars[key]
+		return value, ok
+	}, s.naming, withDefaults)
+}
+
+// LayeredSource собирает конфиг из нескольких источников: сначала подставляются значения
+// из тегов default, затем по порядку применяются источники, и каждый следующий перекрывает
+// значения предыдущих. Например, NewLayeredSource(NewFileSource("app.yaml"), NewEnvSource("APP"))
+// дает приоритет env > файл > default.
+//
+// Поле, которое задали несколько слоев, разрешается политикой: по умолчанию (MergeLastWins) побеждает
+// последний слой, а с MergeError разные значения в разных слоях - ErrBadConfig с CodeConflict по каждому полю,
+// и загрузчик откатывается. Политику отдельного поля и вложенных в него задает тег merge:"error" или merge:"last_wins".
+type LayeredSource struct {
+	sources []ConfigSource
+	policy  string
+
+	mu      sync.Mutex
+	winners map[string]string
+}
+
+func NewLayeredSource(sources ...ConfigSource) *LayeredSource {
+	return &LayeredSource{sources: sources}
+}
+
+// NewLayeredSourcePolicy создает источник, в котором поля без тега merge разрешаются политикой policy
+func NewLayeredSourcePolicy(policy string, sources ...ConfigSource) *LayeredSource {
+	return &LayeredSource{sources: sources, policy: policy}
+}
+
+func (s *LayeredSource) String() string {
+	names := make([]string, 0, len(s.sources))
+	for _, src := range s.sources {
+		names = append(names, sourceName(src))
+	}
+	return "layered(" + strings.Join(names, ", ") + ")"
+}
+
+func (s *LayeredSource) Load(cfg interface{}) error {
+	return s.LoadContext(context.Background(), cfg)
+}
+
+// LoadContext передает ctx источникам, которые его поддерживают
+func (s *LayeredSource) LoadContext(ctx context.Context, cfg interface{}) error {
+	policies, err := mergePolicies(cfg, s.policy)
+	if err != nil {
+		return err
+	}
+	if err := applyDefaults(cfg); err != nil {
+		return ErrBadConfig{Cause: err}
+	}
+	winners := map[string]string{}
+	var conflicts []FieldError
+	for _, src := range s.sources {
+		before := flattenConfig(cfg)
+		if err := applyLayer(ctx, src, cfg); err != nil {
+			return err
+		}
+		after := flattenConfig(cfg)
+		name := sourceName(src)
+		for _, path := range layerFields(src, before, after, cfg) {
+			prev, ok := winners[path]
+			if ok && policies[path] == MergeError && !reflect.DeepEqual(before[path].value, after[path].value) {
+				conflicts = append(conflicts, mergeConflict(path, prev, name))
+			}
+			winners[path] = name
+		}
+	}
+	s.mu.Lock()
+	s.winners = winners
+	s.mu.Unlock()
+	if len(conflicts) > 0 {
+		sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Field < conflicts[j].Field })
+		return ErrBadConfig{Cause: errors.New("config sources conflict"), Fields: conflicts}
+	}
+	return nil
+}
+
+// Winners возвращает, какой слой задал каждое поле при последней загрузке: путь поля -> имя источника.
+// Полей, значения которых остались из тегов default, в ответе нет
+func (s *LayeredSource) Winners() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	winners := make(map[string]string, len(s.winners))
+	for path, name := range s.winners {
+		winners[path] = name
+	}
+	return winners
+}
+
+// applyLayer дописывает в cfg значения из src поверх уже загруженных
+func applyLayer(ctx context.Context, src ConfigSource, cfg interface{}) error {
+	if ls, ok := src.(contextLayerSource); ok {
+		return ls.loadLayerContext(ctx, cfg)
+	}
+	if ls, ok := src.(layerSource); ok {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return ls.loadLayer(cfg)
+	}
+	return loadFrom(ctx, src, cfg)
+}
+
+// layerSource - источник, который умеет дописывать значения поверх уже загруженных,
+// не затирая их значениями из тегов default
+type layerSource interface {
+	loadLayer(cfg interface{}) error
+}
+
+// contextLayerSource - layerSource, загрузку из которого можно прервать через ctx
+type contextLayerSource interface {
+	loadLayerContext(ctx context.Context, cfg interface{}) error
+}
+
+// parseEnvFile разбирает строки вида KEY=value, export KEY=value и комментарии.
+// Значения в двойных кавычках поддерживают экранирование, в одинарных берутся как есть.
+func parseEnvFile(data []byte) (map[string]string, error) {
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return nil, errors.Errorf("line %d: expected KEY=value", n)
+		}
+		key := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, errors.Errorf("line %d: invalid quoted value", n)
+			}
+			value = unquoted
+		case strings.HasPrefix(value, "'"):
+			if len(value) < 2 || !strings.HasSuffix(value, "'") {
+				return nil, errors.Errorf("line %d: invalid quoted value", n)
+			}
+			value = value[1 : len(value)-1]
+		default:
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = strings.TrimSpace(value[:j])
+			}
+		}
+		vars[key] = value
+	}
+	return vars, scanner.Err()
+}
@@ -0,0 +1,58 @@
+package loader
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrFallbackStale означает, что сохраненный конфиг старше LOADER_FALLBACK_MAX_AGE
+var ErrFallbackStale = errors.New("fallback config is stale")
+
+// что делать с сохраненным конфигом старше LOADER_FALLBACK_MAX_AGE (LOADER_FALLBACK_STALE_POLICY)
+const (
+	// не использовать сохраненный конфиг
+	StalePolicyReject = "reject"
+	// использовать, но написать в лог
+	StalePolicyWarn = "warn"
+)
+
+func (l *AppLoader) initStalePolicy() error {
+	switch l.cfg.FallbackStalePolicy {
+	case "":
+		l.cfg.FallbackStalePolicy = StalePolicyReject
+	case StalePolicyReject, StalePolicyWarn:
+	default:
+		return errors.Errorf("unknown fallback stale policy %q", l.cfg.FallbackStalePolicy)
+	}
+	return nil
+}
+
+// checkFallbackAge проверяет, что сохраненный конфиг не старше LOADER_FALLBACK_MAX_AGE
+func (l *AppLoader) checkFallbackAge(header snapshotHeader) error {
+	if l.cfg.FallbackMaxAge <= 0 {
+		return nil
+	}
+	if header.SavedAt == nil {
+		// конфиги, сохраненные прошлыми версиями, без времени сохранения - возраст неизвестен
+		l.log.Info("fallback config has no saved_at, its age is unknown")
+		return nil
+	}
+	age := time.Since(*header.SavedAt)
+	if age <= l.cfg.FallbackMaxAge {
+		return nil
+	}
+	if l.cfg.FallbackStalePolicy == StalePolicyWarn {
+		l.log.Error("fallback config is stale, using it anyway", "age", age.Round(time.Second).String())
+		return nil
+	}
+	return errors.Wrapf(ErrFallbackStale, "saved %s ago, max age is %s", age.Round(time.Second), l.cfg.FallbackMaxAge)
+}
+
+// needsRefresh говорит, пора ли перезаписать неизменившийся конфиг, чтобы обновить время сохранения
+func (l *AppLoader) needsRefresh(header snapshotHeader) bool {
+	if l.cfg.FallbackMaxAge <= 0 {
+		return false
+	}
+	return header.SavedAt == nil || time.Since(*header.SavedAt) > l.cfg.FallbackMaxAge/2
+}
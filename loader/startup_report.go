@@ -0,0 +1,180 @@
+package loader
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// чем закончился запуск процесса, см. StartupReport
+const (
+	// приложение стартовало с конфигом из источника
+	StartupStarted = "started"
+	// приложение стартовало, но на сохраненном конфиге: новый конфиг отклонен
+	StartupFallback = "fallback"
+	// приложение не стартовало
+	StartupFailed = "failed"
+)
+
+// LOADER_STARTUP_REPORT, при котором отчет пишется в stdout одной строкой
+const startupReportStdout = "stdout"
+
+// StartupReport - итог запуска процесса, который загрузчик один раз за запуск пишет в файл
+// LOADER_STARTUP_REPORT или строкой в stdout, чтобы инструменты деплоя решали по нему, удалась ли выкатка.
+// Секретные значения из конфига в ошибках замаскированы
+type StartupReport struct {
+	Status     string    `json:"status"`
+	Time       time.Time `json:"time"`
+	Boot       string    `json:"boot"`
+	Source     string    `json:"source"`
+	ConfigHash string    `json:"config_hash,omitempty"`
+	// ошибка чтения конфига из источника
+	LoadError          string   `json:"load_error,omitempty"`
+	UsesFallbackConfig bool     `json:"uses_fallback_config"`
+	FallbackIndex      int      `json:"fallback_index"`
+	FallbackSections   []string `json:"fallback_sections,omitempty"`
+	// почему отклонен конфиг из источника и какие поля не прошли проверку
+	ConfigError       string       `json:"config_error,omitempty"`
+	ConfigErrorFields []FieldError `json:"config_error_fields,omitempty"`
+	// сколько раз собиралось приложение (с новым и сохраненными конфигами) и сколько это заняло всего
+	Builds        int    `json:"builds"`
+	BuildDuration string `json:"build_duration"`
+	// сколько конструкторов и связей между ними в fx графе запущенного приложения
+	GraphConstructors int `json:"graph_constructors,omitempty"`
+	GraphEdges        int `json:"graph_edges,omitempty"`
+	// сколько прошло от создания загрузчика до старта приложения или ошибки
+	StartupDuration string `json:"startup_duration"`
+	// ошибка, с которой не удался запуск
+	Error string `json:"error,omitempty"`
+}
+
+// startupReport собирает StartupReport из событий загрузчика
+type startupReport struct {
+	NopEvents
+	// файл отчета или stdout, см. LOADER_STARTUP_REPORT
+	path    string
+	began   time.Time
+	written sync.Once
+
+	mu        sync.Mutex
+	loadErr   error
+	builds    int
+	buildTime time.Duration
+}
+
+func (r *startupReport) OnConfigLoaded(_ string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil && r.loadErr == nil {
+		r.loadErr = err
+	}
+}
+
+func (r *startupReport) OnAppBuilt(_ Config, duration time.Duration, _ error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.builds++
+	r.buildTime += duration
+}
+
+// initStartupReport начинает собирать отчет о запуске, если задан LOADER_STARTUP_REPORT
+func (l *AppLoader) initStartupReport() {
+	if l.cfg.StartupReport == "" {
+		return
+	}
+	l.startupReport = &startupReport{path: l.cfg.StartupReport, began: time.Now()}
+	l.events = append(l.events, l.startupReport)
+}
+
+// writeStartupReport пишет отчет о запуске с приложением app, которое стартовало или нет с ошибкой err.
+// Отчет пишется один раз, последующие вызовы ничего не делают
+func (l *AppLoader) writeStartupReport(app *fx.App, err error) {
+	r := l.startupReport
+	if r == nil {
+		return
+	}
+	r.written.Do(func() {
+		report := l.buildStartupReport(app, err)
+		if werr := r.save(report); werr != nil {
+			l.log.Error("failed to write startup report", "error", werr)
+		}
+	})
+}
+
+func (l *AppLoader) buildStartupReport(app *fx.App, err error) StartupReport {
+	r := l.startupReport
+	cfg := l.Config()
+	report := StartupReport{
+		Status:             StartupStarted,
+		Time:               time.Now().UTC(),
+		Boot:               bootID,
+		Source:             sourceName(l.source),
+		UsesFallbackConfig: cfg.UsesFallbackConfig,
+		FallbackIndex:      cfg.FallbackIndex,
+		FallbackSections:   cfg.FallbackSections,
+		StartupDuration:    time.Since(r.began).Round(time.Millisecond).String(),
+	}
+	if app != nil {
+		report.ConfigHash = cfg.ConfigHash
+		if graph, ok := l.graphs.get(app); ok {
+			report.GraphConstructors, report.GraphEdges = graphSize(string(graph))
+		}
+	}
+	switch {
+	case err != nil:
+		report.Status = StartupFailed
+	case cfg.UsesFallbackConfig || len(cfg.FallbackSections) > 0:
+		report.Status = StartupFallback
+	}
+
+	r.mu.Lock()
+	report.Builds = r.builds
+	report.BuildDuration = r.buildTime.Round(time.Millisecond).String()
+	loadErr := r.loadErr
+	r.mu.Unlock()
+
+	// секретные значения из конфига не должны попасть в отчет вместе с ошибками
+	redacted := cfg.Redacted()
+	report.ConfigError = redacted.ConfigError
+	report.ConfigErrorFields = redacted.ConfigErrorFields
+	masked := cfg
+	if loadErr != nil {
+		masked.ConfigError = loadErr.Error()
+		report.LoadError = masked.Redacted().ConfigError
+	}
+	if err != nil {
+		masked.ConfigError = err.Error()
+		report.Error = masked.Redacted().ConfigError
+	}
+	return report
+}
+
+func (r *startupReport) save(report StartupReport) error {
+	if r.path == startupReportStdout {
+		data, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	return writeStateFile(r.path, report)
+}
+
+// graphSize считает конструкторы и связи между ними в графе fx.DotGraph
+func graphSize(graph string) (constructors, edges int) {
+	for _, line := range strings.Split(graph, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.Contains(line, " -> "):
+			edges++
+		case strings.HasPrefix(line, "constructor_"):
+			constructors++
+		}
+	}
+	return constructors, edges
+}
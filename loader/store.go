@@ -0,0 +1,258 @@
+package loader
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrFallbackNotFound возвращается FallbackStore, если сохраненного конфига еще нет
+var ErrFallbackNotFound = errors.New("fallback config does not exist")
+
+// FallbackStore хранит последний известный рабочий конфиг приложения.
+// Реализации не разбирают содержимое и работают с уже сериализованным конфигом.
+type FallbackStore interface {
+	// Save сохраняет конфиг, перезаписывая предыдущий
+	Save(data []byte) error
+	// Load возвращает последний сохраненный конфиг или ErrFallbackNotFound
+	Load() ([]byte, error)
+}
+
+// HistoryStore - хранилище, которое помнит несколько последних рабочих конфигов.
+// Если текущий сохраненный конфиг тоже окажется плохим, загрузчик пойдет по истории назад.
+type HistoryStore interface {
+	FallbackStore
+	// LoadHistory возвращает не больше n сохраненных конфигов, от самого нового к самому старому
+	LoadHistory(n int) ([][]byte, error)
+}
+
+// DeleteStore - хранилище, из которого можно удалить последний сохраненный конфиг,
+// см. AppLoader.InvalidateFallback
+type DeleteStore interface {
+	FallbackStore
+	// Delete удаляет последний сохраненный конфиг, предыдущий из истории становится последним.
+	// Если сохраненного конфига нет, возвращает ErrFallbackNotFound
+	Delete() error
+}
+
+// ErrDeleteNotSupported означает, что хранилище не реализует DeleteStore
+var ErrDeleteNotSupported = errors.New("fallback store does not support delete")
+
+func deleteFromStore(store FallbackStore) error {
+	ds, ok := store.(DeleteStore)
+	if !ok {
+		return errors.Wrapf(ErrDeleteNotSupported, "%T", store)
+	}
+	return ds.Delete()
+}
+
+const defaultFallbackPath = "fallback_config"
+
+// defaultFallbackFile - путь к файлу конфига по умолчанию: fallback_config в текущей директории,
+// а если его там нет и писать туда нельзя - в директории состояния приложения, принятой в ОС
+func defaultFallbackFile() string {
+	if _, err := os.Stat(defaultFallbackPath); err == nil || dirWritable(".") {
+		return defaultFallbackPath
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return defaultFallbackPath
+	}
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	return filepath.Join(dir, name, defaultFallbackPath)
+}
+
+// stateDir возвращает директорию для состояния приложений:
+// %APPDATA% в Windows, $XDG_STATE_HOME или ~/.local/state в остальных ОС
+func stateDir() (string, error) {
+	if runtime.GOOS == "windows" {
+		return os.UserConfigDir()
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state"), nil
+}
+
+func dirWritable(dir string) bool {
+	f, err := os.CreateTemp(dir, ".loader-probe-*")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
+
+// запись и переименование файлов конфига; тесты подменяют их, чтобы имитировать сбой посреди сохранения
+var (
+	writeFile  = func(f *os.File, data []byte) (int, error) { return f.Write(data) }
+	renameFile = os.Rename
+)
+
+// FileStore хранит конфиг в файле на локальном диске.
+// Предыдущие конфиги хранятся рядом в файлах path.1, path.2 и т.д.
+type FileStore struct {
+	path  string
+	depth int
+}
+
+func NewFileStore(path string) *FileStore {
+	return NewFileHistoryStore(path, 1)
+}
+
+// NewFileHistoryStore создает FileStore, который хранит depth последних конфигов
+func NewFileHistoryStore(path string, depth int) *FileStore {
+	if depth < 1 {
+		depth = 1
+	}
+	return &FileStore{path: path, depth: depth}
+}
+
+// Save сохраняет конфиг, при необходимости создавая родительские директории.
+// Предыдущие конфиги сдвигаются по истории, самый старый удаляется.
+// Конфиг сначала пишется во временный файл и только потом переименовывается,
+// так что при падении посреди записи остается предыдущий целый конфиг.
+func (s *FileStore) Save(data []byte) error {
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.Wrap(err, "failed to create fallback config directory")
+	}
+	tmp, err := writeTempFile(dir, filepath.Base(s.path), data)
+	if err != nil {
+		return errors.Wrap(err, "failed to write fallback config")
+	}
+	defer os.Remove(tmp)
+
+	for i := s.depth - 1; i > 0; i-- {
+		var err error
+		if i == 1 {
+			// текущий конфиг не переименовываем, а копируем жесткой ссылкой,
+			// чтобы до замены на новый он оставался на месте
+			os.Remove(s.historyPath(1))
+			if err = os.Link(s.path, s.historyPath(1)); err != nil && !os.IsNotExist(err) {
+				err = renameFile(s.path, s.historyPath(1))
+			}
+		} else {
+			err = renameFile(s.historyPath(i-1), s.historyPath(i))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to rotate fallback config history")
+		}
+	}
+	if err := renameFile(tmp, s.path); err != nil {
+		return errors.Wrap(err, "failed to write fallback config")
+	}
+	return syncDir(dir)
+}
+
+// Delete удаляет последний конфиг и сдвигает историю назад: path.1 становится path и т.д.
+func (s *FileStore) Delete() error {
+	if _, err := os.Stat(s.path); err != nil {
+		if os.IsNotExist(err) {
+			return ErrFallbackNotFound
+		}
+		return err
+	}
+	// переименование поверх атомарно, так что файл path не пропадает, пока история сдвигается
+	i := 1
+	for ; i < s.depth; i++ {
+		err := os.Rename(s.historyPath(i), s.historyPath(i-1))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to rotate fallback config history")
+		}
+	}
+	if i == 1 {
+		// истории нет, удаляем единственный конфиг
+		if err := os.Remove(s.path); err != nil {
+			return errors.Wrap(err, "failed to delete fallback config")
+		}
+	}
+	return syncDir(filepath.Dir(s.path))
+}
+
+func (s *FileStore) Load() ([]byte, error) {
+	return s.load(0)
+}
+
+func (s *FileStore) LoadHistory(n int) ([][]byte, error) {
+	if n > s.depth {
+		n = s.depth
+	}
+	history := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		data, err := s.load(i)
+		if err == ErrFallbackNotFound {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, data)
+	}
+	return history, nil
+}
+
+func (s *FileStore) load(i int) ([]byte, error) {
+	data, err := os.ReadFile(s.historyPath(i))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrFallbackNotFound
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *FileStore) historyPath(i int) string {
+	if i == 0 {
+		return s.path
+	}
+	return s.path + "." + strconv.Itoa(i)
+}
+
+// writeTempFile пишет data во временный файл в dir и сбрасывает его на диск
+func writeTempFile(dir, name string, data []byte) (string, error) {
+	f, err := os.CreateTemp(dir, "."+name+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err = writeFile(f, data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// syncDir сбрасывает на диск директорию, чтобы переименование пережило падение
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return errors.Wrap(err, "failed to sync fallback config directory")
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package loader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tmp []string
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			tmp = append(tmp, e.Name())
+		}
+	}
+	return tmp
+}
+
+func TestFileStoreFailedWriteKeepsPrevious(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fallback")
+	s := NewFileHistoryStore(path, 2)
+	if err := s.Save([]byte("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	// запись обрывается на середине
+	defer func(orig func(f *os.File, data []byte) (int, error)) { writeFile = orig }(writeFile)
+	writeFile = func(f *os.File, data []byte) (int, error) {
+		n, _ := f.Write(data[:len(data)/2])
+		return n, errors.New("disk full")
+	}
+	if err := s.Save([]byte("new config")); err == nil {
+		t.Fatal("Save succeeded with failed write")
+	}
+
+	if got := readFile(t, path); got != "old" {
+		t.Errorf("path = %q, want previous config", got)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("history rotated on failed write: %v", err)
+	}
+	if tmp := tempFiles(t, dir); len(tmp) > 0 {
+		t.Errorf("temp files left: %v", tmp)
+	}
+}
+
+func TestFileStoreCrashBetweenRotationAndRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fallback")
+	s := NewFileHistoryStore(path, 3)
+	for _, data := range []string{"v1", "v2"} {
+		if err := s.Save([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// история уже сдвинута, а новый конфиг не успел встать на место path
+	defer func(orig func(string, string) error) { renameFile = orig }(renameFile)
+	renameFile = func(from, to string) error {
+		if to == path {
+			return errors.New("crash")
+		}
+		return os.Rename(from, to)
+	}
+	if err := s.Save([]byte("v3")); err == nil {
+		t.Fatal("Save succeeded with failed rename")
+	}
+
+	if got := readFile(t, path); got != "v2" {
+		t.Errorf("path = %q, want v2", got)
+	}
+	if got := readFile(t, path+".1"); got != "v2" {
+		t.Errorf("path.1 = %q, want v2", got)
+	}
+	if got := readFile(t, path+".2"); got != "v1" {
+		t.Errorf("path.2 = %q, want v1", got)
+	}
+	if tmp := tempFiles(t, dir); len(tmp) > 0 {
+		t.Errorf("temp files left: %v", tmp)
+	}
+
+	// следующее сохранение проходит поверх оставшихся файлов
+	renameFile = os.Rename
+	if err := s.Save([]byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "v3" {
+		t.Errorf("path = %q, want v3", got)
+	}
+}
+
+func TestFileStoreHistoryRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "fallback")
+	s := NewFileHistoryStore(path, 3)
+	if _, err := s.Load(); !errors.Is(err, ErrFallbackNotFound) {
+		t.Fatalf("Load of empty store = %v, want ErrFallbackNotFound", err)
+	}
+
+	want := []string{"v1", "v2 v1", "v3 v2 v1", "v4 v3 v2", "v5 v4 v3"}
+	for i, data := range []string{"v1", "v2", "v3", "v4", "v5"} {
+		if err := s.Save([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		history, err := s.LoadHistory(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := joinHistory(history); got != want[i] {
+			t.Errorf("after saving %s history = %q, want %q", data, got, want[i])
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("history deeper than depth: %v", err)
+	}
+
+	if err := s.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	history, err := s.LoadHistory(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := joinHistory(history); got != "v4 v3" {
+		t.Errorf("history after Delete = %q, want v4 v3", got)
+	}
+}
+
+func joinHistory(history [][]byte) string {
+	s := make([]string, len(history))
+	for i, data := range history {
+		s[i] = string(data)
+	}
+	return strings.Join(s, " ")
+}
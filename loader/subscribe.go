@@ -0,0 +1,47 @@
+package loader
+
+import (
+	"context"
+	"sync"
+)
+
+// subscribers рассылает новый конфиг после hot reload или отката
+type subscribers struct {
+	mu   sync.Mutex
+	subs map[chan Config]struct{}
+}
+
+// Subscribe возвращает канал, в который приходит конфиг каждый раз, когда приложение
+// переключилось на новый конфиг (hot reload, откат, запуск после ожидания рабочего конфига).
+// Медленный читатель получает только последний конфиг. Канал закрывается, когда отменен ctx.
+func (l *AppLoader) Subscribe(ctx context.Context) <-chan Config {
+	ch := make(chan Config, 1)
+	l.subs.mu.Lock()
+	if l.subs.subs == nil {
+		l.subs.subs = map[chan Config]struct{}{}
+	}
+	l.subs.subs[ch] = struct{}{}
+	l.subs.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		l.subs.mu.Lock()
+		delete(l.subs.subs, ch)
+		close(ch)
+		l.subs.mu.Unlock()
+	}()
+	return ch
+}
+
+// notify отправляет cfg всем подписчикам, заменяя непрочитанный конфиг
+func (s *subscribers) notify(cfg Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- cfg
+	}
+}
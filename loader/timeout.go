@@ -0,0 +1,142 @@
+package loader
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLoadTimeout означает, что загрузка конфига и сборка приложения не уложились в LOADER_LOAD_TIMEOUT
+var ErrLoadTimeout = errors.New("loader timed out")
+
+// фазы загрузки, в которых может истечь LOADER_LOAD_TIMEOUT
+const (
+	phaseLoad     = "load"
+	phaseValidate = "validate"
+	phaseGraph    = "graph"
+	phaseFallback = "fallback"
+	phaseSave     = "save"
+)
+
+type timeoutError struct {
+	phase string
+	cause error
+}
+
+func (e *timeoutError) Error() string {
+	return ErrLoadTimeout.Error() + " in phase " + e.phase + ": " + e.cause.Error()
+}
+
+func (e *timeoutError) Is(target error) bool { return target == ErrLoadTimeout }
+
+func (e *timeoutError) Unwrap() error { return e.cause }
+
+// phaseError помечает err фазой phase, если err вызвана истекшим ctx
+func phaseError(ctx context.Context, phase string, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, ErrLoadTimeout) {
+		return err
+	}
+	return &timeoutError{phase: phase, cause: err}
+}
+
+// withinPhase выполняет fn, но перестает ждать ее, когда истек ctx.
+// Нужна для шагов, которые сами ctx не принимают, например конструкторов fx:
+// зависший конструктор продолжит работать в фоне, а загрузка вернет ошибку
+func withinPhase(ctx context.Context, phase string, fn func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return phaseError(ctx, phase, ctx.Err())
+	}
+}
+
+// границы таймаутов загрузчика: за меньшее время ничего не успеть, а большее скорее всего опечатка
+const (
+	minLoaderTimeout = time.Millisecond * 100
+	maxLoaderTimeout = time.Hour
+)
+
+// loaderTimeouts - таймауты загрузчика, которые с LOADER_FALLBACK_LOADER_CONFIG
+// сохраняются вместе с рабочим конфигом и восстанавливаются при откате на него
+type loaderTimeouts struct {
+	StartTimeout time.Duration `json:"start_timeout"`
+	LoadTimeout  time.Duration `json:"load_timeout"`
+	StopTimeout  time.Duration `json:"stop_timeout"`
+	HTTPTimeout  time.Duration `json:"http_timeout"`
+}
+
+type timeoutField struct {
+	name  string
+	value *time.Duration
+}
+
+func timeoutFields(t *loaderTimeouts) []timeoutField {
+	return []timeoutField{
+		{"LOADER_START_TIMEOUT", &t.StartTimeout},
+		{"LOADER_LOAD_TIMEOUT", &t.LoadTimeout},
+		{"LOADER_STOP_TIMEOUT", &t.StopTimeout},
+		{"LOADER_HTTP_TIMEOUT", &t.HTTPTimeout},
+	}
+}
+
+func timeoutsOf(cfg *LoaderConfig) loaderTimeouts {
+	return loaderTimeouts{
+		StartTimeout: cfg.StartTimeout,
+		LoadTimeout:  cfg.LoadTimeout,
+		StopTimeout:  cfg.StopTimeout,
+		HTTPTimeout:  cfg.HTTPTimeout,
+	}
+}
+
+func (t loaderTimeouts) apply(cfg *LoaderConfig) {
+	cfg.StartTimeout = t.StartTimeout
+	cfg.LoadTimeout = t.LoadTimeout
+	cfg.StopTimeout = t.StopTimeout
+	cfg.HTTPTimeout = t.HTTPTimeout
+}
+
+// checkTimeouts не пропускает отрицательные таймауты: с ними загрузчик сдавался бы сразу
+func checkTimeouts(t loaderTimeouts) error {
+	for _, f := range timeoutFields(&t) {
+		if *f.value < 0 {
+			return errors.Errorf("%s must not be negative, got %s", f.name, *f.value)
+		}
+	}
+	return nil
+}
+
+// clampTimeouts приводит таймауты к границам minLoaderTimeout и maxLoaderTimeout
+func (l *AppLoader) clampTimeouts(t *loaderTimeouts) {
+	for _, f := range timeoutFields(t) {
+		clamped := *f.value
+		if clamped < minLoaderTimeout {
+			clamped = minLoaderTimeout
+		}
+		if clamped > maxLoaderTimeout {
+			clamped = maxLoaderTimeout
+		}
+		if clamped != *f.value {
+			l.log.Error("loader timeout is out of bounds, clamped", "name", f.name, "value", f.value.String(), "clamped", clamped.String())
+			*f.value = clamped
+		}
+	}
+}
+
+// applySnapshotTimeouts берет для cfg таймауты, сохраненные вместе с рабочим конфигом, если это включено
+// в LOADER_FALLBACK_LOADER_CONFIG. Поврежденные таймауты в сохраненном конфиге пропускаются
+func (l *AppLoader) applySnapshotTimeouts(cfg *Config, header snapshotHeader) {
+	if !cfg.FallbackLoaderConfig || header.Timeouts == nil {
+		return
+	}
+	t := *header.Timeouts
+	if err := checkTimeouts(t); err != nil {
+		l.log.Error("ignoring timeouts saved with fallback config", "error", err)
+		return
+	}
+	l.clampTimeouts(&t)
+	t.apply(&cfg.LoaderConfig)
+}
@@ -0,0 +1,61 @@
+package loader
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/sgrishanin/fx-rollback-proto/loader"
+
+// спаны загрузки. Спаны New и Reload - корневые для остальных, если у ctx вызывающего нет своего спана
+const (
+	spanStartup  = "loader.startup"
+	spanReload   = "loader.reload"
+	spanLoad     = "loader.config_load"
+	spanValidate = "loader.validate"
+	spanFallback = "loader.fallback_load"
+	spanBuild    = "loader.build"
+	spanStart    = "loader.app_start"
+)
+
+// tracer берет провайдер из WithTracerProvider, а без него - глобальный провайдер OpenTelemetry,
+// который ничего не отправляет, пока его не задали через otel.SetTracerProvider
+func (l *AppLoader) tracer() trace.Tracer {
+	if l.tracerProvider == nil {
+		return otel.GetTracerProvider().Tracer(tracerName)
+	}
+	return l.tracerProvider.Tracer(tracerName)
+}
+
+func (l *AppLoader) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return l.tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan записывает в span ошибку err, если она есть, и закрывает его
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// fallbackAttrs описывают, на каком конфиге собрано или запущено приложение
+func fallbackAttrs(cfg *Config) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.Bool("fallback_applied", cfg.UsesFallbackConfig)}
+	if cfg.UsesFallbackConfig {
+		attrs = append(attrs, attribute.Int("fallback_index", cfg.FallbackIndex))
+	}
+	return attrs
+}
+
+// traceRollback отмечает в текущем спане, почему загрузчик откатывается на сохраненный конфиг
+func traceRollback(ctx context.Context, configError error) {
+	trace.SpanFromContext(ctx).AddEvent("rollback", trace.WithAttributes(
+		attribute.String("config_error", configError.Error()),
+	))
+}
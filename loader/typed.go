@@ -0,0 +1,28 @@
+package loader
+
+import "go.uber.org/fx"
+
+// TypedLoader - AppLoader, который знает тип конфига приложения T
+type TypedLoader[T any] struct {
+	*AppLoader
+}
+
+// Load загружает конфиг приложения типа T из env с префиксом cfgPrefix
+// и собирает с ним приложение из appProvider. T должен быть структурой.
+// Текущий конфиг отдает AppConfig, без приведения Config.App к нужному типу.
+func Load[T any](cfgPrefix string, appProvider fx.Option, opts ...Option) (*TypedLoader[T], error) {
+	l, err := New(append([]Option{
+		WithEnvPrefix(cfgPrefix),
+		WithApp(appProvider),
+		WithAppConfig(new(T)),
+	}, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedLoader[T]{AppLoader: l}, nil
+}
+
+// AppConfig возвращает текущий конфиг приложения
+func (l *TypedLoader[T]) AppConfig() T {
+	return *l.Config().App.(*T)
+}
@@ -0,0 +1,156 @@
+package loader
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// что делать с переменными под префиксом конфига, которым не соответствует ни одно поле (LOADER_UNKNOWN_ENV)
+const (
+	// не проверять
+	UnknownEnvIgnore = "ignore"
+	// написать в лог
+	UnknownEnvWarn = "warn"
+	// считать конфиг плохим, как и с неразобранным значением
+	UnknownEnvStrict = "strict"
+)
+
+func (l *AppLoader) initUnknownEnvPolicy() error {
+	switch l.cfg.UnknownEnv {
+	case "":
+		l.cfg.UnknownEnv = UnknownEnvIgnore
+	case UnknownEnvIgnore, UnknownEnvWarn, UnknownEnvStrict:
+	default:
+		return errors.Errorf("unknown env policy %q", l.cfg.UnknownEnv)
+	}
+	return nil
+}
+
+// envScanner - источник, который может перечислить свои переменные, не попавшие ни в одно поле cfg
+type envScanner interface {
+	unknownEnv(cfg interface{}) ([]string, error)
+}
+
+// checkUnknownEnv ищет в источнике переменные с опечатками, например APP_SERVER_PROT вместо APP_SERVER_PORT
+func (l *AppLoader) checkUnknownEnv(appCfg interface{}) error {
+	if l.cfg.UnknownEnv == UnknownEnvIgnore {
+		return nil
+	}
+	scanner, ok := l.source.(envScanner)
+	if !ok {
+		return nil
+	}
+	unknown, err := scanner.unknownEnv(appCfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to check unknown env")
+	}
+	if l.cfg.UnknownEnv == UnknownEnvStrict && len(unknown) > 0 {
+		var fields []FieldError
+		for _, name := range unknown {
+			fields = append(fields, FieldError{Field: name, Code: CodeUnknown, Reason: "unknown variable"})
+		}
+		return ErrBadConfig{Fields: fields}
+	}
+	// работающий источник перечитывается на каждом опросе, пишем в лог только новые переменные
+	joined := strings.Join(unknown, ",")
+	if prev := l.unknownEnv.Swap(&joined); len(unknown) > 0 && (prev == nil || *prev != joined) {
+		l.log.Error("env has variables that match no config field", "vars", unknown)
+	}
+	return nil
+}
+
+func (s *EnvSource) unknownEnv(cfg interface{}) ([]string, error) {
+	// у NewLookupSource переменные не перечислить
+	if s.environ == nil {
+		return nil, nil
+	}
+	return unknownEnvVars(s.prefix, s.profilePrefix, cfg, s.naming, envNames(s.environ()))
+}
+
+func (s *EnvFileSource) unknownEnv(cfg interface{}) ([]string, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read env file")
+	}
+	vars, err := parseEnvFile(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse env file %s", s.path)
+	}
+	names := envNames(os.Environ())
+	for name := range vars {
+		names = append(names, name)
+	}
+	return unknownEnvVars(s.prefix, s.profilePrefix, cfg, s.naming, names)
+}
+
+func (s *LayeredSource) unknownEnv(cfg interface{}) ([]string, error) {
+	seen := map[string]bool{}
+	var unknown []string
+	for _, src := range s.sources {
+		scanner, ok := src.(envScanner)
+		if !ok {
+			continue
+		}
+		names, err := scanner.unknownEnv(cfg)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				unknown = append(unknown, name)
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// unknownEnvVars возвращает отсортированные имена из names под префиксом prefix, которые не читает ни одно поле cfg.
+// Имена под skipPrefix пропускаются, их проверяет слой профиля
+func unknownEnvVars(prefix, skipPrefix string, cfg interface{}, naming EnvNaming, names []string) ([]string, error) {
+	// без префикса под конфиг попадает все окружение процесса
+	if prefix == "" {
+		return nil, nil
+	}
+	vars, err := gatherEnvVars(prefix, newAppConfig(cfg), naming)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(vars))
+	for _, v := range vars {
+		known[v.Key] = true
+	}
+	start := naming.caseOf(naming.join(prefix, ""))
+	skip := ""
+	if skipPrefix != "" {
+		skip = naming.caseOf(naming.join(skipPrefix, ""))
+	}
+	var unknown []string
+	for _, name := range names {
+		if skip != "" && strings.HasPrefix(name, skip) {
+			continue
+		}
+		if strings.HasPrefix(name, start) && !known[name] {
+			// переменная может быть и в окружении, и в .env файле
+			known[name] = true
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// envNames возвращает имена переменных из пар KEY=value
+func envNames(environ []string) []string {
+	names := make([]string, 0, len(environ))
+	for _, kv := range environ {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			names = append(names, kv[:i])
+		}
+	}
+	return names
+}
@@ -0,0 +1,101 @@
+package loader
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Validator проверяет семантическую корректность конфига приложения.
+// Загрузчик вызывает валидаторы до сборки fx графа, так что ошибки конфига
+// не смешиваются с ошибками связывания и находятся в одном месте.
+// cfg - указатель на конфиг приложения.
+type Validator interface {
+	Validate(cfg interface{}) error
+}
+
+type ValidatorFunc func(cfg interface{}) error
+
+func (f ValidatorFunc) Validate(cfg interface{}) error {
+	return f(cfg)
+}
+
+// SelfValidator может реализовать сам конфиг приложения, загрузчик вызовет его
+// после проверки тегов validate и перед валидаторами из опций
+type SelfValidator interface {
+	Validate() error
+}
+
+// FieldError описывает проблему с конкретным полем конфига
+type FieldError struct {
+	// путь к полю, например server.port, или имя переменной окружения
+	Field string      `json:"field"`
+	Value interface{} `json:"value,omitempty"`
+	// машиночитаемый код ошибки, например CodeOutOfRange
+	Code   string `json:"code,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+// ValidationErrors - все проблемы, найденные в конфиге
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// validate прогоняет конфиг через теги validate и все валидаторы
+// и собирает найденные ошибки в одну ErrBadConfig.
+// Если валидатор прервался из-за отмены ctx, возвращается ошибка ctx, а не ErrBadConfig
+func (l *AppLoader) validate(ctx context.Context, appCfg interface{}) error {
+	validators := []Validator{ValidatorFunc(ValidateTags)}
+	if sv, ok := appCfg.(SelfValidator); ok {
+		validators = append(validators, ValidatorFunc(func(interface{}) error { return sv.Validate() }))
+	}
+	validators = append(validators, l.sectionValidators()...)
+	validators = append(validators, l.validators...)
+
+	var fieldErrs ValidationErrors
+	var other []string
+	for _, v := range validators {
+		err := validateWith(ctx, v, appCfg)
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return phaseError(ctx, phaseValidate, errors.Wrap(ctx.Err(), "config validation interrupted"))
+		}
+		var ve ValidationErrors
+		var fe FieldError
+		var be ErrBadConfig
+		switch {
+		case errors.As(err, new(tagError)):
+			// ошибка в коде: сохраненный конфиг проверяется теми же тегами, откат не поможет
+			return err
+		case errors.As(err, &be) && be.Cause == nil:
+			fieldErrs = append(fieldErrs, be.Fields...)
+		case errors.As(err, &ve):
+			fieldErrs = append(fieldErrs, ve...)
+		case errors.As(err, &fe):
+			fieldErrs = append(fieldErrs, fe)
+		default:
+			other = append(other, err.Error())
+		}
+	}
+
+	if len(other) > 0 {
+		return ErrBadConfig{Cause: errors.New(strings.Join(other, "; ")), Fields: fieldErrs}
+	}
+	if len(fieldErrs) > 0 {
+		return ErrBadConfig{Fields: fieldErrs}
+	}
+	return nil
+}
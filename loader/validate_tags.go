@@ -0,0 +1,225 @@
+package loader
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ValidateTags проверяет поля конфига по тегам validate, например
+//
+//	Port int `validate:"required,min=8000,max=8999"`
+//
+// Поддерживаются правила:
+//   - required - значение не должно быть пустым;
+//   - min=N, max=N - границы для чисел и длительностей, для строк, слайсов и map - границы длины;
+//   - oneof=a b c - значение должно быть одним из перечисленных через пробел;
+//   - regexp=expr - строка должна соответствовать выражению, правило должно быть последним,
+//     потому что выражение может содержать запятые.
+//
+// Все нарушения возвращаются вместе как ValidationErrors. Неправильный тег - ошибка в коде, а не в конфиге,
+// она возвращается сразу и откат на сохраненный конфиг не вызывает.
+// Загрузчик вызывает ValidateTags для конфига приложения сам.
+func ValidateTags(cfg interface{}) error {
+	var errs ValidationErrors
+	var tagErr error
+	walkFields(cfg, func(f configField) {
+		tag, ok := f.Field.Tag.Lookup("validate")
+		if !ok || tagErr != nil {
+			return
+		}
+		for _, rule := range splitRules(tag) {
+			code, reason, err := checkRule(rule, f.Value)
+			if err != nil {
+				tagErr = tagError{errors.Wrapf(err, "invalid validate tag on %s", f.Path)}
+				return
+			}
+			if reason != "" {
+				fe := FieldError{Field: f.Path, Value: fieldValue(f.Value), Code: code, Reason: reason}
+				if isSecretField(f.Field) {
+					fe.Value = nil
+				}
+				errs = append(errs, fe)
+			}
+		}
+	})
+	if tagErr != nil {
+		return tagErr
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// tagError - неправильный тег validate
+type tagError struct {
+	err error
+}
+
+func (e tagError) Error() string {
+	return e.err.Error()
+}
+
+func (e tagError) Unwrap() error {
+	return e.err
+}
+
+func splitRules(tag string) []string {
+	var rules []string
+	for tag != "" {
+		if strings.HasPrefix(tag, "regexp=") {
+			return append(rules, tag)
+		}
+		i := strings.IndexByte(tag, ',')
+		if i < 0 {
+			return append(rules, tag)
+		}
+		rules = append(rules, tag[:i])
+		tag = tag[i+1:]
+	}
+	return rules
+}
+
+// checkRule возвращает код и описание нарушения или пустые строки, если значение подходит
+func checkRule(rule string, v reflect.Value) (string, string, error) {
+	name, arg := rule, ""
+	if i := strings.IndexByte(rule, '='); i >= 0 {
+		name, arg = rule[:i], rule[i+1:]
+	}
+
+	switch name {
+	case "required":
+		if isEmpty(v) {
+			return CodeRequired, "is required", nil
+		}
+		return "", "", nil
+	case "min", "max":
+		return checkBound(name, arg, v)
+	case "oneof":
+		options := strings.Fields(arg)
+		v = deref(v)
+		if !v.IsValid() {
+			// пустой указатель проверяется правилом required
+			return "", "", nil
+		}
+		s := fmt.Sprint(v.Interface())
+		for _, o := range options {
+			if s == o {
+				return "", "", nil
+			}
+		}
+		return CodeNotAllowed, fmt.Sprintf("must be one of [%s]", strings.Join(options, " ")), nil
+	case "regexp":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return "", "", err
+		}
+		// тип проверяется и у пустого указателя: неподходящий тег - ошибка независимо от значения
+		t := v.Type()
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.String {
+			return "", "", errors.Errorf("regexp can't be applied to %s", t)
+		}
+		v = deref(v)
+		if !v.IsValid() {
+			return "", "", nil
+		}
+		if !re.MatchString(v.String()) {
+			return CodeInvalidFormat, fmt.Sprintf("must match %s", arg), nil
+		}
+		return "", "", nil
+	}
+	return "", "", errors.Errorf("unknown rule %q", name)
+}
+
+func checkBound(name, arg string, v reflect.Value) (string, string, error) {
+	v = deref(v)
+	if !v.IsValid() {
+		// пустой указатель проверяется правилом required
+		return "", "", nil
+	}
+
+	var value, bound float64
+	// у длительностей и размеров граница разбирается в своих единицах и может быть нулевой
+	var parsed bool
+	var unit string
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(arg)
+			if err != nil {
+				return "", "", err
+			}
+			value, bound, parsed = float64(v.Int()), float64(d), true
+			break
+		}
+		if v.Type() == reflect.TypeOf(ByteSize(0)) {
+			size, err := ParseByteSize(arg)
+			if err != nil {
+				return "", "", err
+			}
+			value, bound, parsed = float64(v.Int()), float64(size), true
+			break
+		}
+		value = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		value = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		value = v.Float()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		value = float64(v.Len())
+		unit = "length "
+	default:
+		return "", "", errors.Errorf("%s can't be applied to %s", name, v.Type())
+	}
+	if !parsed {
+		b, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return "", "", err
+		}
+		bound = b
+	}
+
+	if name == "min" && value < bound {
+		return CodeOutOfRange, fmt.Sprintf("%smust be >= %s", unit, arg), nil
+	}
+	if name == "max" && value > bound {
+		return CodeOutOfRange, fmt.Sprintf("%smust be <= %s", unit, arg), nil
+	}
+	return "", "", nil
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// значение поля для FieldError, пустой указатель дает nil
+func fieldValue(v reflect.Value) interface{} {
+	v = deref(v)
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+	return v.Interface()
+}
+
+func deref(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
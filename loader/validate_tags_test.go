@@ -0,0 +1,54 @@
+package loader
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidateTagsNilPointers(t *testing.T) {
+	type config struct {
+		Mode    *string        `validate:"oneof=a b"`
+		Name    *string        `validate:"regexp=^[a-z]+$"`
+		Timeout *time.Duration `validate:"min=0s"`
+	}
+	if err := ValidateTags(&config{}); err != nil {
+		t.Fatalf("ValidateTags with nil pointers = %v, want nil", err)
+	}
+
+	mode, name := "c", "Bad"
+	err := ValidateTags(&config{Mode: &mode, Name: &name})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("ValidateTags = %v, want violations of mode and name", err)
+	}
+	if errs[0].Code != CodeNotAllowed || errs[1].Code != CodeInvalidFormat {
+		t.Errorf("codes = %s, %s, want %s, %s", errs[0].Code, errs[1].Code, CodeNotAllowed, CodeInvalidFormat)
+	}
+}
+
+func TestValidateTagsZeroBounds(t *testing.T) {
+	type config struct {
+		Timeout time.Duration `validate:"min=0s,max=1m"`
+		Size    ByteSize      `validate:"min=0B"`
+	}
+	if err := ValidateTags(&config{Timeout: time.Second}); err != nil {
+		t.Fatalf("ValidateTags = %v, want nil", err)
+	}
+	if err := ValidateTags(&config{Timeout: -time.Second}); err == nil {
+		t.Error("negative timeout passed min=0s")
+	}
+}
+
+func TestValidateTagsInvalidTag(t *testing.T) {
+	type config struct {
+		Port *int `validate:"regexp=^[0-9]+$"`
+	}
+	err := ValidateTags(&config{})
+	if err == nil || !errors.As(err, new(tagError)) {
+		t.Fatalf("ValidateTags = %v, want invalid tag error", err)
+	}
+	if _, ok := findBadConfig(err); ok {
+		t.Error("invalid tag is reported as bad config")
+	}
+}
@@ -0,0 +1,214 @@
+package loader
+
+import (
+	"math"
+	"net"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Типы для полей конфига, которые иначе пришлось бы хранить строками и разбирать в конструкторах.
+// Они разбираются и проверяются при чтении из env, файлов и хранилища, а сохраняются
+// и показываются в админке в том же текстовом виде, в котором их задают.
+// Пустое значение дает нулевое значение типа, обязательность проверяет тег validate:"required"
+
+// ByteSize - размер в байтах: 512, 512B, 10KB, 10MiB, 1.5GiB. KB, MB, GB, TB - степени 1000,
+// KiB, MiB, GiB, TiB - степени 1024, регистр не важен. Теги min и max принимают размер в том же виде
+type ByteSize int64
+
+type sizeUnit struct {
+	name string
+	size int64
+}
+
+// от большего к меньшему, чтобы MarshalText выбрал самую крупную единицу
+var sizeUnits = []sizeUnit{
+	{"TiB", 1 << 40}, {"TB", 1e12},
+	{"GiB", 1 << 30}, {"GB", 1e9},
+	{"MiB", 1 << 20}, {"MB", 1e6},
+	{"KiB", 1 << 10}, {"KB", 1e3},
+	{"B", 1},
+}
+
+// ParseByteSize разбирает размер в формате ByteSize
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	num, unit := s[:i], strings.TrimSpace(s[i:])
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, errors.Errorf("invalid size %q", s)
+	}
+	mult := int64(1)
+	if unit != "" {
+		mult = 0
+		for _, u := range sizeUnits {
+			if strings.EqualFold(unit, u.name) {
+				mult = u.size
+				break
+			}
+		}
+		if mult == 0 {
+			return 0, errors.Errorf("unknown size unit %q", unit)
+		}
+	}
+	size := n * float64(mult)
+	if size >= math.MaxInt64 {
+		return 0, errors.Errorf("size %q is too large", s)
+	}
+	return ByteSize(size), nil
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*b = 0
+		return nil
+	}
+	size, err := ParseByteSize(string(text))
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+func (b ByteSize) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+func (b ByteSize) String() string {
+	for _, u := range sizeUnits {
+		if b != 0 && int64(b)%u.size == 0 {
+			return strconv.FormatInt(int64(b)/u.size, 10) + u.name
+		}
+	}
+	return strconv.FormatInt(int64(b), 10) + "B"
+}
+
+// URL - абсолютный адрес со схемой, например https://api.example.com/v1
+type URL struct {
+	url.URL
+}
+
+func (u *URL) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*u = URL{}
+		return nil
+	}
+	parsed, err := url.Parse(string(text))
+	if err != nil {
+		// в ошибке url.Parse есть сам адрес, а в нем может быть пароль
+		return errors.New("invalid URL")
+	}
+	if parsed.Scheme == "" {
+		return errors.New("URL must have a scheme")
+	}
+	u.URL = *parsed
+	return nil
+}
+
+func (u URL) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// HostPort - адрес host:port, хост может быть пустым (:8080)
+type HostPort struct {
+	Host string
+	Port int
+}
+
+func (h *HostPort) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*h = HostPort{}
+		return nil
+	}
+	host, port, err := net.SplitHostPort(string(text))
+	if err != nil {
+		return errors.Wrap(err, "invalid host:port")
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return errors.Errorf("invalid port %q", port)
+	}
+	*h = HostPort{Host: host, Port: int(p)}
+	return nil
+}
+
+func (h HostPort) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// String возвращает адрес для net.Listen и net.Dial
+func (h HostPort) String() string {
+	if h == (HostPort{}) {
+		return ""
+	}
+	return net.JoinHostPort(h.Host, strconv.Itoa(h.Port))
+}
+
+// CIDR - подсеть, например 10.0.0.0/8 или fd00::/8. Биты адреса за маской обнуляются: 10.1.2.3/8 дает 10.0.0.0/8
+type CIDR struct {
+	netip.Prefix
+}
+
+func (c *CIDR) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*c = CIDR{}
+		return nil
+	}
+	p, err := netip.ParsePrefix(string(text))
+	if err != nil {
+		return errors.Errorf("invalid CIDR %q", text)
+	}
+	c.Prefix = p.Masked()
+	return nil
+}
+
+func (c CIDR) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c CIDR) String() string {
+	if !c.IsValid() {
+		return ""
+	}
+	return c.Prefix.String()
+}
+
+// Regexp - регулярное выражение, которое компилируется при чтении конфига.
+// Для пустого значения Regexp nil, и вызывать его методы нельзя
+type Regexp struct {
+	*regexp.Regexp
+}
+
+func (r *Regexp) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*r = Regexp{}
+		return nil
+	}
+	re, err := regexp.Compile(string(text))
+	if err != nil {
+		return errors.Wrap(err, "invalid regexp")
+	}
+	r.Regexp = re
+	return nil
+}
+
+func (r Regexp) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r Regexp) String() string {
+	if r.Regexp == nil {
+		return ""
+	}
+	return r.Regexp.String()
+}
@@ -0,0 +1,206 @@
+package vaultsource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// auth выдает действующий токен, продлевая или получая его заново, когда подходит срок
+type auth interface {
+	token(ctx context.Context, s *Source) (string, error)
+}
+
+// tokenAuth - заранее выданный токен. Если он продлеваемый, продлевается по renew-self
+type tokenAuth struct {
+	static    string
+	lookedUp  bool
+	renewable bool
+	renewAt   time.Time
+}
+
+func (a *tokenAuth) token(ctx context.Context, s *Source) (string, error) {
+	if a.static == "" {
+		return "", errors.New("vault token is not set")
+	}
+	if !a.lookedUp {
+		// узнаем ttl токена, чтобы продлевать его вовремя
+		ttl, renewable, err := s.lookupSelf(ctx, a.static)
+		if err != nil {
+			return "", err
+		}
+		a.lookedUp = true
+		a.renewable = renewable
+		a.renewAt = renewTime(ttl)
+	}
+	if a.renewable && !a.renewAt.IsZero() && time.Now().After(a.renewAt) {
+		// если продлить не вышло, токен еще может быть жив - попробуем в следующий раз
+		if ttl, err := s.renewSelf(ctx, a.static); err == nil {
+			a.renewAt = renewTime(ttl)
+		}
+	}
+	return a.static, nil
+}
+
+// appRoleAuth получает токен по role_id и secret_id, продлевает его,
+// а когда продлить уже нельзя - входит заново
+type appRoleAuth struct {
+	roleID   string
+	secretID string
+
+	current   string
+	renewable bool
+	renewAt   time.Time
+}
+
+func (a *appRoleAuth) token(ctx context.Context, s *Source) (string, error) {
+	if a.current != "" && (a.renewAt.IsZero() || time.Now().Before(a.renewAt)) {
+		return a.current, nil
+	}
+	if a.current != "" && a.renewable {
+		if ttl, err := s.renewSelf(ctx, a.current); err == nil {
+			a.renewAt = renewTime(ttl)
+			return a.current, nil
+		}
+	}
+
+	var resp authResponse
+	body := map[string]string{"role_id": a.roleID, "secret_id": a.secretID}
+	if err := s.do(ctx, http.MethodPost, "auth/approle/login", "", body, &resp); err != nil {
+		return "", errors.Wrap(err, "approle login failed")
+	}
+	a.current = resp.Auth.ClientToken
+	a.renewable = resp.Auth.Renewable
+	a.renewAt = renewTime(resp.Auth.LeaseDuration)
+	return a.current, nil
+}
+
+// токен продлевается, когда прошли две трети его ttl
+func renewTime(ttlSeconds int64) time.Time {
+	if ttlSeconds <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(ttlSeconds) * time.Second * 2 / 3)
+}
+
+type authResponse struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int64  `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+}
+
+func (s *Source) lookupSelf(ctx context.Context, token string) (int64, bool, error) {
+	var resp struct {
+		Data struct {
+			TTL       int64 `json:"ttl"`
+			Renewable bool  `json:"renewable"`
+		} `json:"data"`
+	}
+	if err := s.do(ctx, http.MethodGet, "auth/token/lookup-self", token, nil, &resp); err != nil {
+		return 0, false, errors.Wrap(err, "token lookup failed")
+	}
+	return resp.Data.TTL, resp.Data.Renewable, nil
+}
+
+func (s *Source) renewSelf(ctx context.Context, token string) (int64, error) {
+	var resp authResponse
+	if err := s.do(ctx, http.MethodPost, "auth/token/renew-self", token, struct{}{}, &resp); err != nil {
+		return 0, errors.Wrap(err, "token renewal failed")
+	}
+	return resp.Auth.LeaseDuration, nil
+}
+
+// readSecret читает данные секрета, для KV v2 разворачивает data.data
+func (s *Source) readSecret(ctx context.Context, token, path string) (map[string]interface{}, error) {
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := s.do(ctx, http.MethodGet, path, token, nil, &resp); err != nil {
+		return nil, errors.Wrapf(err, "failed to read vault secret %s", path)
+	}
+	if inner, ok := resp.Data["data"].(map[string]interface{}); ok {
+		if _, ok := resp.Data["metadata"]; ok {
+			return inner, nil
+		}
+	}
+	return resp.Data, nil
+}
+
+func (s *Source) do(ctx context.Context, method, path, token string, body interface{}, out interface{}) error {
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.addr+"/v1/"+path, &reqBody)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if s.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", s.namespace)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "vault request failed")
+	}
+	defer resp.Body.Close()
+
+	d := json.NewDecoder(resp.Body)
+	d.UseNumber()
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = d.Decode(&vaultErr)
+		return &statusError{status: resp.Status, code: resp.StatusCode, errors: vaultErr.Errors}
+	}
+	if err := d.Decode(out); err != nil {
+		return errors.Wrap(err, "failed to decode vault response")
+	}
+	return nil
+}
+
+// statusError - ответ Vault с кодом, отличным от 200
+type statusError struct {
+	status string
+	code   int
+	errors []string
+}
+
+func (e *statusError) Error() string {
+	if len(e.errors) > 0 {
+		return fmt.Sprintf("vault returned %s: %s", e.status, strings.Join(e.errors, "; "))
+	}
+	return "vault returned " + e.status
+}
+
+// isNotFound - Vault ответил, что секрета по такому пути нет. KV отвечает 404 и на удаленный секрет
+func isNotFound(err error) bool {
+	var statusErr *statusError
+	return errors.As(err, &statusErr) && statusErr.code == http.StatusNotFound
+}
+
+// значения секретов приводятся к строке и дальше разбираются как переменные окружения
+func stringify(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number, bool:
+		return fmt.Sprint(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
@@ -0,0 +1,193 @@
+// Package vaultsource дописывает в конфиг loader секреты из HashiCorp Vault.
+// Работает напрямую с HTTP API Vault, чтобы не тянуть в зависимости его клиент.
+package vaultsource
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/sgrishanin/fx-rollback-proto/loader"
+)
+
+const (
+	secretTag      = "vault"
+	defaultAddr    = "https://127.0.0.1:8200"
+	defaultTimeout = time.Second * 10
+)
+
+// Source загружает конфиг из base, а затем заполняет поля с тегом vault:"path#key"
+// значениями из Vault, например
+//
+//	Password string `vault:"secret/data/my-app#db_password"`
+//
+// Поддерживаются KV v1 и v2 (для v2 в пути нужен сегмент data).
+// Значения из Vault не сохраняются в рабочий конфиг, при откате они запрашиваются заново.
+// Отсутствующий секрет или ключ и значение, которое не удалось разобрать, считаются плохим конфигом,
+// так что загрузчик откатится на последний рабочий конфиг. Недоступный Vault или отказ во входе
+// и в доступе к секрету - ошибка инфраструктуры: откат с ней не поможет, загрузка завершается ошибкой.
+type Source struct {
+	base loader.ConfigSource
+
+	addr      string
+	namespace string
+	client    *http.Client
+
+	mu   sync.Mutex
+	auth auth
+}
+
+type Option func(s *Source)
+
+// WithAddress задает адрес Vault, по умолчанию берется из VAULT_ADDR
+func WithAddress(addr string) Option {
+	return func(s *Source) {
+		s.addr = addr
+	}
+}
+
+// WithToken задает токен, по умолчанию берется из VAULT_TOKEN
+func WithToken(token string) Option {
+	return func(s *Source) {
+		s.auth = &tokenAuth{static: token}
+	}
+}
+
+// WithAppRole включает вход через AppRole, по умолчанию используется,
+// если заданы VAULT_ROLE_ID и VAULT_SECRET_ID
+func WithAppRole(roleID, secretID string) Option {
+	return func(s *Source) {
+		s.auth = &appRoleAuth{roleID: roleID, secretID: secretID}
+	}
+}
+
+// WithNamespace задает namespace Vault Enterprise, по умолчанию берется из VAULT_NAMESPACE
+func WithNamespace(namespace string) Option {
+	return func(s *Source) {
+		s.namespace = namespace
+	}
+}
+
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *Source) {
+		s.client = client
+	}
+}
+
+func New(base loader.ConfigSource, opts ...Option) *Source {
+	s := &Source{
+		base:      base,
+		addr:      os.Getenv("VAULT_ADDR"),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		client:    &http.Client{Timeout: defaultTimeout},
+		auth:      &tokenAuth{static: os.Getenv("VAULT_TOKEN")},
+	}
+	if roleID, secretID := os.Getenv("VAULT_ROLE_ID"), os.Getenv("VAULT_SECRET_ID"); roleID != "" && secretID != "" {
+		s.auth = &appRoleAuth{roleID: roleID, secretID: secretID}
+	}
+	if s.addr == "" {
+		s.addr = defaultAddr
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.addr = strings.TrimSuffix(s.addr, "/")
+	return s
+}
+
+var (
+	_ loader.ContextSource  = (*Source)(nil)
+	_ loader.SecretResolver = (*Source)(nil)
+)
+
+func (s *Source) String() string {
+	return "vault " + s.addr
+}
+
+func (s *Source) Load(cfg interface{}) error {
+	return s.LoadContext(context.Background(), cfg)
+}
+
+// LoadContext прерывает запросы к Vault, когда отменен ctx.
+// Если base реализует loader.ContextSource, ctx передается и ему
+func (s *Source) LoadContext(ctx context.Context, cfg interface{}) error {
+	var err error
+	if cs, ok := s.base.(loader.ContextSource); ok {
+		err = cs.LoadContext(ctx, cfg)
+	} else {
+		err = s.base.Load(cfg)
+	}
+	if err != nil {
+		return err
+	}
+	return s.ResolveSecrets(ctx, cfg)
+}
+
+// SecretTag - тег полей, значения которых берутся из Vault. Загрузчик не сохраняет их
+// в рабочий конфиг на диск, а при откате запрашивает заново
+func (s *Source) SecretTag() string {
+	return secretTag
+}
+
+// ResolveSecrets заполняет поля cfg с тегом vault значениями из Vault, не читая base
+func (s *Source) ResolveSecrets(ctx context.Context, cfg interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.auth.token(ctx, s)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.Wrap(err, "failed to authenticate in vault")
+	}
+
+	// один секрет обычно содержит несколько ключей, читаем каждый путь один раз
+	secrets := map[string]map[string]interface{}{}
+	// недоступный Vault или отказ в доступе - не ошибка конфига, остальные секреты уже не читаем
+	var vaultErr error
+	err = loader.ResolveTags(cfg, secretTag, func(ref string) (string, error) {
+		path, key, ok := splitRef(ref)
+		if !ok {
+			return "", errors.Errorf("invalid vault reference %q, expected path#key", ref)
+		}
+		if vaultErr != nil {
+			return "", vaultErr
+		}
+		data, ok := secrets[path]
+		if !ok {
+			if data, err = s.readSecret(ctx, token, path); err != nil {
+				if !isNotFound(err) {
+					vaultErr = err
+				}
+				return "", err
+			}
+			secrets[path] = data
+		}
+		value, ok := data[key]
+		if !ok {
+			return "", errors.Errorf("key %q not found in vault secret %s", key, path)
+		}
+		return stringify(value), nil
+	})
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return ctx.Err()
+	case vaultErr != nil:
+		return errors.Wrap(vaultErr, "failed to read secrets from vault")
+	}
+	return err
+}
+
+func splitRef(ref string) (path, key string, ok bool) {
+	i := strings.LastIndexByte(ref, '#')
+	if i <= 0 || i == len(ref)-1 {
+		return "", "", false
+	}
+	return strings.Trim(ref[:i], "/"), ref[i+1:], true
+}